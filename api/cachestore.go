@@ -0,0 +1,269 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/cache"
+	"github.com/reedperry/gogram/log"
+
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"time"
+)
+
+// Time a cached entity is kept before it is read from its store again. Writes always remove
+// cached entities, so this only limits how long a write that raced with a read can go unseen.
+const CACHE_TTL = 10 * time.Minute
+
+// CachedUserStore is a read-through cache in front of a UserStore. Users are cached by ID,
+// and removed from the cache whenever they are created, updated or deleted.
+type CachedUserStore struct {
+	UserStore
+	Cache cache.Cache
+	Stats cache.Stats
+}
+
+// CachedEventStore is a read-through cache in front of an EventStore. Events are cached by
+// ID, and removed from the cache whenever they are stored or deleted. Feeds are not cached.
+type CachedEventStore struct {
+	EventStore
+	Cache cache.Cache
+	Stats cache.Stats
+}
+
+// CachedPostStore is a read-through cache in front of a PostStore. Posts are cached by ID,
+// and removed from the cache whenever they are stored or deleted. Listings are not cached.
+type CachedPostStore struct {
+	PostStore
+	Cache cache.Cache
+	Stats cache.Stats
+}
+
+func NewCachedUserStore(s UserStore, cc cache.Cache) *CachedUserStore {
+	return &CachedUserStore{UserStore: s, Cache: cc}
+}
+
+func NewCachedEventStore(s EventStore, cc cache.Cache) *CachedEventStore {
+	return &CachedEventStore{EventStore: s, Cache: cc}
+}
+
+func NewCachedPostStore(s PostStore, cc cache.Cache) *CachedPostStore {
+	return &CachedPostStore{PostStore: s, Cache: cc}
+}
+
+// CacheStats responds with the hit and miss counts of each cached store to admins. Counts
+// are kept by each server, or instance, since it started.
+func CacheStats(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	p, err := GetRequestPrincipal(r, c)
+	if err != nil || !Can(p, ACTION_VIEW_STATS, nil) {
+		log.Infof(c, "User %+v cannot view cache stats.", p)
+		http.Error(w, "Only admins can view cache stats.", http.StatusForbidden)
+		return
+	}
+
+	stats := make(map[string]cache.Stats)
+	if s, ok := Users.(*CachedUserStore); ok {
+		stats["users"] = s.Stats.Get()
+	}
+	if s, ok := Events.(*CachedEventStore); ok {
+		stats["events"] = s.Stats.Get()
+	}
+	if s, ok := Posts.(*CachedPostStore); ok {
+		stats["posts"] = s.Stats.Get()
+	}
+
+	sendJsonResponse(w, stats)
+}
+
+func userCacheKey(userID string) string {
+	return USER_KIND + "/" + userID
+}
+
+func (s *CachedUserStore) Get(userID string, c context.Context) (*AppUser, error) {
+	appUser := new(AppUser)
+	if cacheGet(s.Cache, &s.Stats, userCacheKey(userID), appUser, c) {
+		return appUser, nil
+	}
+
+	appUser, err := s.UserStore.Get(userID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(s.Cache, userCacheKey(userID), appUser, c)
+	return appUser, nil
+}
+
+func (s *CachedUserStore) GetMulti(userIDs []string, c context.Context) (map[string]*AppUser, error) {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = userCacheKey(userID)
+	}
+
+	cached, err := s.Cache.GetMulti(c, keys)
+	if err != nil {
+		log.Warningf(c, "Failed to read users from cache: %v", err)
+	}
+
+	found := make(map[string]*AppUser, len(userIDs))
+	missing := make([]string, 0, len(userIDs))
+	for i, userID := range userIDs {
+		appUser := new(AppUser)
+		if value, ok := cached[keys[i]]; ok && decodeCached(value, appUser) == nil {
+			s.Stats.Hit()
+			found[userID] = appUser
+		} else {
+			s.Stats.Miss()
+			missing = append(missing, userID)
+		}
+	}
+
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	stored, err := s.UserStore.GetMulti(missing, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for userID, appUser := range stored {
+		found[userID] = appUser
+		cacheSet(s.Cache, userCacheKey(userID), appUser, c)
+	}
+
+	return found, nil
+}
+
+func (s *CachedUserStore) Create(appUser *AppUser, c context.Context) error {
+	err := s.UserStore.Create(appUser, c)
+	cacheDelete(s.Cache, userCacheKey(appUser.ID), c)
+	return err
+}
+
+func (s *CachedUserStore) Update(appUser *AppUser, c context.Context) error {
+	err := s.UserStore.Update(appUser, c)
+	cacheDelete(s.Cache, userCacheKey(appUser.ID), c)
+	return err
+}
+
+func (s *CachedUserStore) Delete(userID string, c context.Context) error {
+	err := s.UserStore.Delete(userID, c)
+	cacheDelete(s.Cache, userCacheKey(userID), c)
+	return err
+}
+
+func eventCacheKey(eventID string) string {
+	return EVENT_KIND + "/" + eventID
+}
+
+func (s *CachedEventStore) Get(eventID string, c context.Context) (*Event, error) {
+	event := new(Event)
+	if cacheGet(s.Cache, &s.Stats, eventCacheKey(eventID), event, c) {
+		return event, nil
+	}
+
+	event, err := s.EventStore.Get(eventID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(s.Cache, eventCacheKey(eventID), event, c)
+	return event, nil
+}
+
+func (s *CachedEventStore) Put(event *Event, c context.Context) error {
+	err := s.EventStore.Put(event, c)
+	cacheDelete(s.Cache, eventCacheKey(event.ID), c)
+	return err
+}
+
+func (s *CachedEventStore) Update(eventID string, update func(*Event) error, c context.Context) (*Event, error) {
+	event, err := s.EventStore.Update(eventID, update, c)
+	cacheDelete(s.Cache, eventCacheKey(eventID), c)
+	return event, err
+}
+
+func (s *CachedEventStore) Delete(eventID string, c context.Context) error {
+	err := s.EventStore.Delete(eventID, c)
+	cacheDelete(s.Cache, eventCacheKey(eventID), c)
+	return err
+}
+
+func postCacheKey(postID string) string {
+	return POST_KIND + "/" + postID
+}
+
+func (s *CachedPostStore) Get(postID string, c context.Context) (*Post, error) {
+	post := new(Post)
+	if cacheGet(s.Cache, &s.Stats, postCacheKey(postID), post, c) {
+		return post, nil
+	}
+
+	post, err := s.PostStore.Get(postID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(s.Cache, postCacheKey(postID), post, c)
+	return post, nil
+}
+
+func (s *CachedPostStore) Put(post *Post, c context.Context) error {
+	err := s.PostStore.Put(post, c)
+	cacheDelete(s.Cache, postCacheKey(post.ID), c)
+	return err
+}
+
+func (s *CachedPostStore) Delete(postID string, c context.Context) error {
+	err := s.PostStore.Delete(postID, c)
+	cacheDelete(s.Cache, postCacheKey(postID), c)
+	return err
+}
+
+// cacheGet reads a cached entity into v, and reports whether it was found. A cache that
+// fails is treated as a miss, so that requests fall back to the store.
+func cacheGet(cc cache.Cache, stats *cache.Stats, key string, v interface{}, c context.Context) bool {
+	value, err := cc.Get(c, key)
+	if err == nil {
+		err = decodeCached(value, v)
+	}
+
+	if err != nil {
+		if err != cache.ErrCacheMiss {
+			log.Warningf(c, "Failed to read %v from cache: %v", key, err)
+		}
+		stats.Miss()
+		return false
+	}
+
+	stats.Hit()
+	return true
+}
+
+func cacheSet(cc cache.Cache, key string, v interface{}, c context.Context) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		log.Warningf(c, "Failed to encode %v for cache: %v", key, err)
+		return
+	}
+
+	if err := cc.Set(c, key, buf.Bytes(), CACHE_TTL); err != nil {
+		log.Warningf(c, "Failed to cache %v: %v", key, err)
+	}
+}
+
+// cacheDelete removes an entity from the cache. It is called after every write, even a
+// failed one, since the entity may have changed anyway.
+func cacheDelete(cc cache.Cache, key string, c context.Context) {
+	if err := cc.Delete(c, key); err != nil {
+		log.Errorf(c, "Failed to remove %v from cache, it may be stale for up to %v: %v", key, CACHE_TTL, err)
+	}
+}
+
+func decodeCached(value []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(value)).Decode(v)
+}
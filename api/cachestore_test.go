@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/cache"
+)
+
+func TestCachedUserStore(t *testing.T) {
+	s := NewCachedUserStore(NewMemoryUserStore(), cache.NewLRU(100))
+	s.Create(&AppUser{ID: "1", Username: "one"}, nil)
+
+	for i := 0; i < 3; i++ {
+		if appUser, err := s.Get("1", nil); err != nil || appUser.Username != "one" {
+			t.Fatalf("Get() returned %+v, %v.", appUser, err)
+		}
+	}
+
+	if stats := s.Stats.Get(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Got %+v after 3 lookups. Wanted 2 hits and 1 miss.", stats)
+	}
+
+	// Updates must not leave a stale user in the cache.
+	s.Update(&AppUser{ID: "1", Username: "renamed"}, nil)
+	if appUser, _ := s.Get("1", nil); appUser.Username != "renamed" {
+		t.Errorf("Get() after Update() returned username %q. Wanted \"renamed\".", appUser.Username)
+	}
+
+	s.Create(&AppUser{ID: "2", Username: "two"}, nil)
+	found, err := s.GetMulti([]string{"1", "2", "3"}, nil)
+	if err != nil || len(found) != 2 {
+		t.Errorf("GetMulti() returned %v, %v. Wanted 2 users.", found, err)
+	}
+
+	s.Delete("1", nil)
+	if _, err := s.Get("1", nil); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted user returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestCachedEventStore(t *testing.T) {
+	s := NewCachedEventStore(NewMemoryEventStore(), cache.NewLRU(100))
+	s.Put(&Event{ID: "1", Name: "Before"}, nil)
+	s.Get("1", nil)
+
+	s.Put(&Event{ID: "1", Name: "After"}, nil)
+	if event, _ := s.Get("1", nil); event.Name != "After" {
+		t.Errorf("Get() after Put() returned name %q. Wanted \"After\".", event.Name)
+	}
+
+	s.Delete("1", nil)
+	if _, err := s.Get("1", nil); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	defer func(users UserStore) {
+		Users = users
+	}(Users)
+	Users = NewCachedUserStore(NewMemoryUserStore(), cache.NewLRU(100))
+	Users.Create(&AppUser{ID: "u", Username: "someone", Role: ROLE_USER}, context.Background())
+	Users.Create(&AppUser{ID: "a", Username: "admin", Role: ROLE_ADMIN}, context.Background())
+
+	for userID, want := range map[string]int{"": http.StatusForbidden, "u": http.StatusForbidden, "a": http.StatusOK} {
+		req := httptest.NewRequest("GET", "/a/stats/cache", nil)
+		if userID != "" {
+			req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		}
+		w := httptest.NewRecorder()
+		CacheStats(w, req)
+		if w.Code != want {
+			t.Errorf("CacheStats() for user %q returned %v. Wanted %v.", userID, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"net/http"
+	"net/url"
+)
+
+// Queue for tasks which delete content and accounts in the background, configured in
+// queue.yaml.
+const DELETION_QUEUE = "deletions"
+
+// Queue for retrying image deletions which failed, configured in queue.yaml with
+// exponential backoff.
+const IMAGE_DELETION_QUEUE = "image-deletions"
+
+// Number of times a failed image deletion is retried before a DeadLetter is recorded for
+// it. It must match the task_retry_limit of IMAGE_DELETION_QUEUE, and the RetryLimit of
+// a LocalQueue.
+const MAX_IMAGE_DELETE_RETRIES = 5
+
+// DeleteUserTask runs a JOB_DELETE_USER job. If the job deletes the user's content, one
+// page of posts and their images is deleted, and the task queues itself again until no
+// posts are left. The account itself is deleted last, along with the user's exports. It
+// must be called as a task with the jobID form value.
+func DeleteUserTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	jobID := r.FormValue("jobID")
+	job, err := FetchJob(jobID, c)
+	if err == ErrNoSuchEntity {
+		log.Errorf(c, "Job %v to delete a user does not exist.", jobID)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch job.", http.StatusInternalServerError)
+		return
+	}
+	if job.Status == JOB_DONE || job.Status == JOB_FAILED {
+		return
+	}
+
+	job.Status = JOB_RUNNING
+	more, runErr := runUserDeletion(job, c)
+	retry := false
+	if runErr != nil {
+		retry = recordJobError(job, runErr, r)
+		if retry {
+			log.Errorf(c, "Job %v failed to delete user %v, it will be retried: %v", job.ID, job.UserID, runErr)
+		} else {
+			log.Errorf(c, "Job %v failed to delete user %v, giving up: %v", job.ID, job.UserID, runErr)
+		}
+	} else if !more {
+		log.Infof(c, "Job %v deleted user %v.", job.ID, job.UserID)
+		job.Status = JOB_DONE
+		job.Error = ""
+	}
+
+	err = saveJob(job, c)
+	if err != nil {
+		log.Errorf(c, "Failed to store job %v: %v", job.ID, err)
+	}
+
+	if runErr == nil && err == nil && more {
+		err = queueUserDeletion(job.ID, c)
+	}
+
+	if retry || err != nil {
+		http.Error(w, "Failed to delete user.", http.StatusInternalServerError)
+	}
+}
+
+// runUserDeletion does the next step of a JOB_DELETE_USER job, and reports whether there
+// is more left to do. Each step purges the first page of the user's trash, or deletes the
+// first page of the user's posts, so no cursor is kept between steps.
+func runUserDeletion(job *Job, c context.Context) (bool, error) {
+	if job.DeleteContent {
+		trash, err := Trash.ByOwner(job.UserID, PageRequest{Order: "Trashed", Limit: MAX_PAGE_SIZE}, c)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range trash.Items {
+			if err := purgeTrashItem(&trash.Items[i], c); err != nil {
+				return false, err
+			}
+		}
+
+		if len(trash.Items) != 0 {
+			return true, nil
+		}
+
+		page, err := FetchUserPosts(job.UserID, PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}, c)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range page.Items {
+			if err := deletePostContent(&page.Items[i], c); err != nil {
+				return false, err
+			}
+			job.Progress++
+		}
+
+		if page.NextCursor != "" {
+			return true, nil
+		}
+	}
+
+	if err := deleteTokens(job.UserID, c); err != nil {
+		return false, err
+	}
+	if err := deleteMemberships(job.UserID, c); err != nil {
+		return false, err
+	}
+	if err := deleteExports(job.UserID, c); err != nil {
+		return false, err
+	}
+
+	return false, deleteAppUser(job.UserID, c)
+}
+
+// deleteExports deletes all of a user's exports, which hold their personal data whether
+// or not their content is deleted.
+func deleteExports(userID string, c context.Context) error {
+	for {
+		objs, _, err := imgstore.List(c, EXPORT_PREFIX+userID+"/", "", MAX_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		if len(objs) == 0 {
+			return nil
+		}
+
+		for _, obj := range objs {
+			if err := imgstore.Store.Delete(c, obj.Name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func queueUserDeletion(jobID string, c context.Context) error {
+	return tasks.Add(c, DELETION_QUEUE, "/t/delete-user", url.Values{
+		"jobID": {jobID},
+	})
+}
+
+// DeleteImageTask retries the deletion of an image and its resized copies. When the last
+// retry fails, a DeadLetter is recorded instead of failing again. It must be called as a
+// task with the filename form value, and the revoke form value if public access to the
+// files may not have been revoked.
+func DeleteImageTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	filename := r.FormValue("filename")
+	if r.FormValue("revoke") != "" {
+		imgstore.Revoke(c, filename)
+	}
+
+	err := imgstore.Delete(c, filename)
+	if err == nil {
+		log.Infof(c, "Deleted file %v after %v retries.", filename, tasks.RetryCount(r))
+		return
+	}
+
+	if tasks.RetryCount(r) < MAX_IMAGE_DELETE_RETRIES {
+		http.Error(w, "Failed to delete file.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := recordDeadLetter(r, err, c); err != nil {
+		log.Errorf(c, "Failed to record dead letter for file %v: %v", filename, err)
+		http.Error(w, "Failed to delete file.", http.StatusInternalServerError)
+	}
+}
+
+// deleteImage revokes public access to an image and its resized copies right away, then
+// deletes them. If the deletion fails, it is retried in the background, so an error is
+// only returned if the retry cannot be queued. If revoking access failed too, the retry
+// revokes it again.
+func deleteImage(filename string, c context.Context) error {
+	revokeErr := imgstore.Revoke(c, filename)
+
+	err := imgstore.Delete(c, filename)
+	if err == nil {
+		return nil
+	}
+
+	params := url.Values{"filename": {filename}}
+	if revokeErr != nil {
+		log.Errorf(c, "Failed to delete file %v, which may still be public, queueing a retry: %v", filename, err)
+		params.Set("revoke", "true")
+	} else {
+		log.Warningf(c, "Failed to delete file %v, queueing a retry: %v", filename, err)
+	}
+
+	return tasks.Add(c, IMAGE_DELETION_QUEUE, "/t/delete-image", params)
+}
+
+// deletePostContent deletes a post's image and its resized copies, and then the post.
+// The post is deleted last so that a failure to queue a retry of the image deletion can
+// be retried without losing track of the images.
+func deletePostContent(post *Post, c context.Context) error {
+	if post.Image != "" {
+		if err := deleteImage(post.ImageName(), c); err != nil {
+			return err
+		}
+	}
+
+	return deletePost(post.ID, c)
+}
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/tasks"
+)
+
+func TestDeleteEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(users UserStore, events EventStore, members MemberStore, links InviteLinkStore, posts PostStore, trash TrashStore, store imgstore.BlobStore, queue tasks.Queue) {
+		Users, Events, Members, InviteLinks, Posts, Trash, imgstore.Store, tasks.Default = users, events, members, links, posts, trash, store, queue
+	}(Users, Events, Members, InviteLinks, Posts, Trash, imgstore.Store, tasks.Default)
+	memTrash := NewMemoryTrashStore()
+	Users, Events, Posts, Trash = NewMemoryUserStore(), NewMemoryEventStore(), NewMemoryPostStore(), memTrash
+	Members, InviteLinks = NewMemoryMemberStore(), NewMemoryInviteLinkStore()
+	imgstore.Store = &imgstore.LocalStore{Dir: dir}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/e/{id}", DeleteEvent).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/restore", RestoreEvent).Methods("POST")
+	r.HandleFunc("/t/trash-event-posts", TrashEventPostsTask).Methods("POST")
+	r.HandleFunc("/t/restore-event-posts", RestoreEventPostsTask).Methods("POST")
+	r.HandleFunc("/t/purge-trash", PurgeTrashTask).Methods("POST")
+
+	queue := tasks.NewLocalQueue(map[string]http.Handler{"default": r})
+	queue.MinBackoff = time.Millisecond
+	tasks.Default = queue
+	defer queue.Shutdown(context.Background())
+
+	c := context.Background()
+	Events.Put(&Event{ID: "e", Creator: "creator"}, c)
+	Members.Put(&Member{EventID: "e", UserID: "u", Status: MEMBER_JOINED}, c)
+	InviteLinks.Put(&InviteLink{ID: "l", EventID: "e", MaxUses: 1}, c)
+
+	// More than a page of posts, so that trashing has to continue past the first one.
+	count := MAX_PAGE_SIZE + 5
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		post := &Post{ID: strconv.Itoa(i), UserID: "u", EventID: "e", Created: start.Add(time.Duration(i))}
+		post.Image = "link"
+		Posts.Put(post, c)
+
+		filename := post.ImageName()
+		for _, name := range append([]string{filename}, imgstore.VariantNames(filename)...) {
+			imgstore.Write(c, name, "image/gif", strings.NewReader("GIF89a"))
+		}
+	}
+	Posts.Put(&Post{ID: "other", UserID: "u", EventID: "other"}, c)
+
+	serveAs := func(userID, method, url string) int {
+		req := httptest.NewRequest(method, url, nil)
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	waitForPosts := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			page, err := Posts.ByEvent("e", PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := len(page.Items)
+			if page.NextCursor != "" {
+				n = count
+			}
+			if n == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("The event has %v posts after 5 seconds. Wanted %v.", n, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if code := serveAs("someone", "DELETE", "/a/e/e"); code != http.StatusForbidden {
+		t.Errorf("DeleteEvent() by another user returned status %v. Wanted %v.", code, http.StatusForbidden)
+	}
+	if code := serveAs("creator", "DELETE", "/a/e/e"); code != http.StatusOK {
+		t.Fatalf("DeleteEvent() by its creator returned status %v.", code)
+	}
+
+	if _, err := Events.Get("e", c); err != ErrNoSuchEntity {
+		t.Errorf("Get() of the deleted event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+	waitForPosts(0)
+
+	files, _ := ioutil.ReadDir(dir + "/u")
+	if len(files) != 3*count {
+		t.Errorf("%v image files remain in the trash. Wanted %v.", len(files), 3*count)
+	}
+	if _, err := Posts.Get("other", c); err != nil {
+		t.Errorf("A post of another event was deleted: %v", err)
+	}
+
+	if code := serveAs("someone", "POST", "/a/e/e/restore"); code != http.StatusNotFound {
+		t.Errorf("RestoreEvent() by another user returned status %v. Wanted %v.", code, http.StatusNotFound)
+	}
+	if code := serveAs("creator", "POST", "/a/e/e/restore"); code != http.StatusOK {
+		t.Fatalf("RestoreEvent() by its creator returned status %v.", code)
+	}
+	if _, err := Events.Get("e", c); err != nil {
+		t.Errorf("Get() of the restored event failed: %v", err)
+	}
+	waitForPosts(count)
+
+	// Delete the event again, and let it expire in the trash.
+	if code := serveAs("creator", "DELETE", "/a/e/e"); code != http.StatusOK {
+		t.Fatalf("DeleteEvent() by its creator returned status %v.", code)
+	}
+	waitForPosts(0)
+
+	memTrash.mu.Lock()
+	for key, item := range memTrash.items {
+		item.Trashed = item.Trashed.Add(-TRASH_TTL)
+		memTrash.items[key] = item
+	}
+	memTrash.mu.Unlock()
+
+	if code := serveAs("creator", "POST", "/a/e/e/restore"); code != http.StatusGone {
+		t.Errorf("RestoreEvent() of an expired event returned status %v. Wanted %v.", code, http.StatusGone)
+	}
+
+	tasks.Add(c, DELETION_QUEUE, "/t/purge-trash", nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		memTrash.mu.RLock()
+		n := len(memTrash.items)
+		memTrash.mu.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v items remain in the trash after 5 seconds.", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := Members.Get("e", "u", c); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a member of the purged event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+	if _, err := InviteLinks.Get("l", c); err != ErrNoSuchEntity {
+		t.Errorf("Get() of an invite link to the purged event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	files, _ = ioutil.ReadDir(dir + "/u")
+	if len(files) != 0 {
+		t.Errorf("%v image files remain after purging the trash.", len(files))
+	}
+}
+
+func TestPurgeTrashLiveEvent(t *testing.T) {
+	defer func(events EventStore, members MemberStore, trash TrashStore) {
+		Events, Members, Trash = events, members, trash
+	}(Events, Members, Trash)
+	Events, Members, Trash = NewMemoryEventStore(), NewMemoryMemberStore(), NewMemoryTrashStore()
+
+	// DeleteEvent put the event back after trashing it, but failed to delete its copy.
+	c := context.Background()
+	event := &Event{ID: "e", Name: "Live", Creator: "creator"}
+	item, err := newTrashItem(EVENT_KIND, event.ID, event.Creator, "", "creator", &Event{ID: "e", Name: "Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Trash.Put(item, c)
+	Events.Put(event, c)
+	Members.Put(&Member{EventID: "e", UserID: "u", Status: MEMBER_JOINED}, c)
+
+	if err := restoreTrashItem(item, c); err != nil {
+		t.Fatalf("restoreTrashItem() failed: %v", err)
+	}
+	if event, _ := Events.Get("e", c); event.Name != "Live" {
+		t.Errorf("restoreTrashItem() overwrote the live event with %+v.", event)
+	}
+
+	Trash.Put(item, c)
+	if err := purgeTrashItem(item, c); err != nil {
+		t.Fatalf("purgeTrashItem() failed: %v", err)
+	}
+	if _, err := Members.Get("e", "u", c); err != nil {
+		t.Errorf("purgeTrashItem() deleted a member of the live event: %v", err)
+	}
+	if _, err := Trash.Get(EVENT_KIND, "e", c); err != ErrNoSuchEntity {
+		t.Errorf("Get() of the purged copy returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	defer func(users UserStore, posts PostStore, jobs JobStore, trash TrashStore, tokens TokenStore, members MemberStore, store imgstore.BlobStore, queue tasks.Queue) {
+		Users, Posts, Jobs, Trash, Tokens, Members, imgstore.Store, tasks.Default = users, posts, jobs, trash, tokens, members, store, queue
+	}(Users, Posts, Jobs, Trash, Tokens, Members, imgstore.Store, tasks.Default)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/u/{username}", DeleteUser).Methods("DELETE")
+	r.HandleFunc("/a/jobs/{id}", GetJob).Methods("GET")
+	r.HandleFunc("/t/delete-user", DeleteUserTask).Methods("POST")
+
+	c := context.Background()
+	for _, content := range []string{"anonymize", "delete"} {
+		dir, err := ioutil.TempDir("", "cleanup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		Users, Posts, Jobs, Trash = NewMemoryUserStore(), NewMemoryPostStore(), NewMemoryJobStore(), NewMemoryTrashStore()
+		Tokens, Members = NewMemoryTokenStore(), NewMemoryMemberStore()
+		imgstore.Store = &imgstore.LocalStore{Dir: dir}
+		queue := tasks.NewLocalQueue(map[string]http.Handler{"default": r})
+		tasks.Default = queue
+
+		Users.Create(&AppUser{ID: "u", Username: "someone"}, c)
+		Tokens.Put(&AccessToken{ID: "t", UserID: "u", Scope: TOKEN_WRITE}, c)
+		Members.Put(&Member{EventID: "e", UserID: "u", Status: MEMBER_INVITED}, c)
+		for i := 0; i < MAX_PAGE_SIZE+5; i++ {
+			post := &Post{ID: strconv.Itoa(i), UserID: "u", EventID: "e", Image: "link"}
+			Posts.Put(post, c)
+			imgstore.Write(c, post.ImageName(), "image/gif", strings.NewReader("GIF89a"))
+		}
+		trashed := &Post{ID: "trashed", UserID: "u", EventID: "e", Image: "link"}
+		trashPost(trashed, "", "u", c)
+		imgstore.Write(c, trashed.ImageName(), "image/gif", strings.NewReader("GIF89a"))
+
+		serve := func(method, url string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, url, nil)
+			req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: "u"}))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		w := serve("DELETE", "/a/u/someone?content="+content)
+		var resp JobResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("DeleteUser() returned status %v and %q.", w.Code, w.Body.String())
+		}
+
+		jobURL := "/a/jobs/" + resp.Data.ID
+		deadline := time.Now().Add(5 * time.Second)
+		for resp.Data.Status != JOB_DONE {
+			if time.Now().After(deadline) {
+				t.Fatalf("Job to %v content is still %q after 5 seconds.", content, resp.Data.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			resp = JobResponse{}
+			json.Unmarshal(serve("GET", jobURL).Body.Bytes(), &resp)
+		}
+		queue.Shutdown(c)
+
+		if _, err := Users.Get("u", c); err != ErrNoSuchEntity {
+			t.Errorf("Get() of the deleted user returned %v. Wanted ErrNoSuchEntity.", err)
+		}
+		if _, err := Tokens.Get("t", c); err != ErrNoSuchEntity {
+			t.Errorf("Get() of the deleted user's token returned %v. Wanted ErrNoSuchEntity.", err)
+		}
+		if _, err := Members.Get("e", "u", c); err != ErrNoSuchEntity {
+			t.Errorf("Get() of the deleted user's invite returned %v. Wanted ErrNoSuchEntity.", err)
+		}
+
+		page, _ := Posts.ByUser("u", PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}, c)
+		files, _ := ioutil.ReadDir(dir + "/u")
+		switch content {
+		case "anonymize":
+			if len(page.Items) != MAX_PAGE_SIZE || len(files) == 0 {
+				t.Errorf("Anonymizing left %v posts and %v images. Wanted all of them.", len(page.Items), len(files))
+			}
+		case "delete":
+			if len(page.Items) != 0 || len(files) != 0 || resp.Data.Progress != MAX_PAGE_SIZE+5 {
+				t.Errorf("Deleting content left %v posts and %v images, with progress %v.",
+					len(page.Items), len(files), resp.Data.Progress)
+			}
+		}
+	}
+}
+
+// failingTokenStore fails to list tokens.
+type failingTokenStore struct {
+	*MemoryTokenStore
+}
+
+func (s failingTokenStore) ByUser(userID string, c context.Context) ([]AccessToken, error) {
+	return nil, errors.New("listing tokens failed")
+}
+
+func TestDeleteUserFails(t *testing.T) {
+	defer func(users UserStore, jobs JobStore, tokens TokenStore, queue tasks.Queue) {
+		Users, Jobs, Tokens, tasks.Default = users, jobs, tokens, queue
+	}(Users, Jobs, Tokens, tasks.Default)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/t/delete-user", DeleteUserTask).Methods("POST")
+
+	c := context.Background()
+	Users, Jobs, Tokens = NewMemoryUserStore(), NewMemoryJobStore(), failingTokenStore{NewMemoryTokenStore()}
+	queue := tasks.NewLocalQueue(map[string]http.Handler{"default": r})
+	queue.MinBackoff = time.Millisecond
+	tasks.Default = queue
+
+	Users.Create(&AppUser{ID: "u", Username: "someone"}, c)
+	job := newJob(JOB_DELETE_USER, "u")
+	saveJob(job, c)
+	if err := queueUserDeletion(job.ID, c); err != nil {
+		t.Fatalf("queueUserDeletion() failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status != JOB_FAILED && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		job, _ = Jobs.Get(job.ID, c)
+	}
+	queue.Shutdown(c)
+
+	if job.Status != JOB_FAILED || job.Error != "listing tokens failed" {
+		t.Errorf("Job is %q with error %q after its task gave up. Wanted it to fail.", job.Status, job.Error)
+	}
+	if _, err := Users.Get("u", c); err != nil {
+		t.Errorf("Get() of the user whose deletion failed returned %v.", err)
+	}
+}
+
+// failingBlobStore fails to delete objects until failures runs out, and records revoked
+// objects, failing to revoke them if failRevoke is set.
+type failingBlobStore struct {
+	imgstore.LocalStore
+	mu         sync.Mutex
+	failures   int
+	failRevoke bool
+	revoked    []string
+}
+
+func (s *failingBlobStore) Delete(c context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures != 0 {
+		s.failures--
+		return errors.New("delete failed")
+	}
+	return s.LocalStore.Delete(c, name)
+}
+
+func (s *failingBlobStore) Revoke(c context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked = append(s.revoked, name)
+	if s.failRevoke {
+		return errors.New("revoke failed")
+	}
+	return nil
+}
+
+func TestDeleteImageRetries(t *testing.T) {
+	defer func(deadLetters DeadLetterStore, store imgstore.BlobStore, queue tasks.Queue) {
+		DeadLetters, imgstore.Store, tasks.Default = deadLetters, store, queue
+	}(DeadLetters, imgstore.Store, tasks.Default)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/t/delete-image", DeleteImageTask).Methods("POST")
+
+	c := context.Background()
+	// The first deletion fails for one try and succeeds when retried, and the second one
+	// fails every time. The third one also fails to revoke access, so the retry revokes it.
+	for _, test := range []struct {
+		failures   int
+		failRevoke bool
+	}{{1, false}, {-1, false}, {1, true}} {
+		failures := test.failures
+		dir, err := ioutil.TempDir("", "cleanup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		store := &failingBlobStore{LocalStore: imgstore.LocalStore{Dir: dir}, failures: failures, failRevoke: test.failRevoke}
+		deadLetters := NewMemoryDeadLetterStore()
+		imgstore.Store, DeadLetters = store, deadLetters
+		imgstore.Write(c, "u/p", "image/gif", strings.NewReader("GIF89a"))
+
+		queue := tasks.NewLocalQueue(map[string]http.Handler{"default": r})
+		queue.MinBackoff = time.Millisecond
+		tasks.Default = queue
+
+		if err := deleteImage("u/p", c); err != nil {
+			t.Fatalf("deleteImage() failed to queue a retry: %v", err)
+		}
+		// The retry may have revoked access again already.
+		store.mu.Lock()
+		if len(store.revoked) < 3 {
+			t.Errorf("Revoked %v before deleting. Wanted the image and its 2 copies.", store.revoked)
+		}
+		store.mu.Unlock()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err := imgstore.Store.Stat(c, "u/p")
+			deadLetters.mu.RLock()
+			done := err == imgstore.ErrObjectNotExist || len(deadLetters.deadLetters) > 0
+			deadLetters.mu.RUnlock()
+			if done || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		queue.Shutdown(c)
+
+		_, err = imgstore.Store.Stat(c, "u/p")
+		if failures > 0 && err != imgstore.ErrObjectNotExist {
+			t.Errorf("The image was not deleted by a retry: %v", err)
+		}
+		if test.failRevoke && len(store.revoked) != 6 {
+			t.Errorf("Revoked %v. Wanted access revoked again by the retry.", store.revoked)
+		}
+		if failures < 0 {
+			for _, deadLetter := range deadLetters.deadLetters {
+				if deadLetter.Retries != MAX_IMAGE_DELETE_RETRIES || deadLetter.Params != "filename=u%2Fp" {
+					t.Errorf("Recorded dead letter %+v.", deadLetter)
+				}
+			}
+			if len(deadLetters.deadLetters) != 1 {
+				t.Errorf("Recorded %v dead letters. Wanted 1.", len(deadLetters.deadLetters))
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/log"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Most co-hosts an event may have.
+const MAX_COHOSTS = 10
+
+var (
+	ErrAlreadyCoHost  = errors.New("This user is already a co-host.")
+	ErrTooManyCoHosts = fmt.Errorf("An event can have at most %v co-hosts.", MAX_COHOSTS)
+	ErrNotCoHost      = errors.New("This user is not a co-host.")
+)
+
+type CoHostListResponse struct {
+	Ok bool `json:"ok"`
+	// Data holds the usernames of the co-hosts.
+	Data []string `json:"data"`
+}
+
+// ListCoHosts responds with the usernames of an event's co-hosts to users who can view it.
+func ListCoHosts(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	if err := event.AuthorizeView(p, c); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	sendCoHosts(w, event, c)
+}
+
+// AddCoHost makes the user named in the request body a co-host of an event. Only the
+// event's creator and admins may add co-hosts.
+func AddCoHost(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	if !Can(p, ACTION_MANAGE_HOSTS, event) {
+		log.Infof(c, "User %v cannot add co-hosts to event %v.", p.ID, event.ID)
+		http.Error(w, "Only the creator of an event can add co-hosts.", http.StatusForbidden)
+		return
+	}
+
+	req := new(inviteRequest)
+	if err := readEntity(r, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username := strings.ToLower(req.Username)
+	appUser, err := FetchAppUserByName(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.Error(w, fmt.Sprintf("There is no user named '%v'.", username), http.StatusBadRequest)
+		return
+	}
+
+	if appUser.ID == event.Creator {
+		http.Error(w, "The creator of an event cannot be its co-host.", http.StatusBadRequest)
+		return
+	}
+
+	eventID := event.ID
+	event, err = Events.Update(eventID, func(event *Event) error {
+		if event.IsCoHost(appUser.ID) {
+			return ErrAlreadyCoHost
+		}
+		if len(event.CoHosts) >= MAX_COHOSTS {
+			return ErrTooManyCoHosts
+		}
+
+		event.CoHosts = append(event.CoHosts, appUser.ID)
+		event.Modified = time.Now()
+		return nil
+	}, c)
+	switch err {
+	case nil:
+	case ErrAlreadyCoHost, ErrTooManyCoHosts:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		log.Errorf(c, "Failed to add co-host %v to event %v: %v", appUser.ID, eventID, err)
+		http.Error(w, "Failed to add co-host.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v made user %v a co-host of event %v.", p.ID, appUser.ID, event.ID)
+	sendCoHosts(w, event, c)
+}
+
+// RemoveCoHost removes the co-host named in the request URL from an event. The creator
+// of an event and admins may remove any co-host, and co-hosts may remove themselves.
+func RemoveCoHost(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+	appUser, err := FetchAppUserByName(username, c)
+	if err != nil || !event.IsCoHost(appUser.ID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if appUser.ID != p.ID && !Can(p, ACTION_MANAGE_HOSTS, event) {
+		log.Infof(c, "User %v cannot remove co-hosts of event %v.", p.ID, event.ID)
+		http.Error(w, "Only the creator of an event can remove co-hosts.", http.StatusForbidden)
+		return
+	}
+
+	eventID := event.ID
+	event, err = Events.Update(eventID, func(event *Event) error {
+		// The co-host may have been removed since the event was fetched.
+		if !event.IsCoHost(appUser.ID) {
+			return ErrNotCoHost
+		}
+
+		coHosts := make([]string, 0, len(event.CoHosts))
+		for _, id := range event.CoHosts {
+			if id != appUser.ID {
+				coHosts = append(coHosts, id)
+			}
+		}
+		event.CoHosts = coHosts
+		event.Modified = time.Now()
+		return nil
+	}, c)
+	switch err {
+	case nil:
+	case ErrNotCoHost:
+		http.NotFound(w, r)
+		return
+	default:
+		log.Errorf(c, "Failed to remove co-host %v of event %v: %v", appUser.ID, eventID, err)
+		http.Error(w, "Failed to remove co-host.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v removed co-host %v of event %v.", p.ID, appUser.ID, event.ID)
+	sendCoHosts(w, event, c)
+}
+
+// sendCoHosts responds with the usernames of an event's co-hosts.
+func sendCoHosts(w http.ResponseWriter, event *Event, c context.Context) {
+	usernames, err := FetchUsernames(event.CoHosts, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch co-hosts.", http.StatusInternalServerError)
+		return
+	}
+
+	names := make([]string, len(event.CoHosts))
+	for i, id := range event.CoHosts {
+		names[i] = usernames[id]
+	}
+
+	resp := CoHostListResponse{true, names}
+	sendJsonResponse(w, resp)
+}
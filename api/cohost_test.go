@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+)
+
+func TestCoHosts(t *testing.T) {
+	defer func(users UserStore, events EventStore, posts PostStore, members MemberStore, trash TrashStore) {
+		Users, Events, Posts, Members, Trash = users, events, posts, members, trash
+	}(Users, Events, Posts, Members, Trash)
+	Users, Events, Posts, Members, Trash = NewMemoryUserStore(), NewMemoryEventStore(), NewMemoryPostStore(), NewMemoryMemberStore(), NewMemoryTrashStore()
+
+	c := context.Background()
+	for _, name := range []string{"creator", "host", "guest"} {
+		Users.Create(&AppUser{ID: name, Username: name}, c)
+	}
+	now := time.Now()
+	Events.Put(&Event{ID: "e", Name: "Party", Description: "Fun", Creator: "creator", Private: true,
+		Start: now, End: now.Add(time.Hour), Created: now}, c)
+	Posts.Put(&Post{ID: "p", UserID: "guest", EventID: "e", Created: now}, c)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/p/{id}", DeletePost).Methods("DELETE")
+	r.HandleFunc("/a/p/{id}/restore", RestorePost).Methods("POST")
+	r.HandleFunc("/a/e/{id}", UpdateEvent).Methods("PUT")
+	r.HandleFunc("/a/e/{id}", DeleteEvent).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/members", InviteMember).Methods("POST")
+	r.HandleFunc("/a/e/{id}/cohosts", ListCoHosts).Methods("GET")
+	r.HandleFunc("/a/e/{id}/cohosts", AddCoHost).Methods("POST")
+	r.HandleFunc("/a/e/{id}/cohosts/{username}", RemoveCoHost).Methods("DELETE")
+
+	serveAs := func(userID, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	addTests := []struct {
+		userID, username string
+		want             int
+	}{
+		{"guest", "host", http.StatusForbidden},
+		{"creator", "creator", http.StatusBadRequest},
+		{"creator", "nobody", http.StatusBadRequest},
+		{"creator", "host", http.StatusOK},
+		{"creator", "host", http.StatusConflict},
+		{"host", "guest", http.StatusForbidden},
+	}
+	for _, test := range addTests {
+		w := serveAs(test.userID, "POST", "/a/e/e/cohosts", `{"username": "`+test.username+`"}`)
+		if w.Code != test.want {
+			t.Errorf("AddCoHost() of %v by %v returned %v %q. Wanted %v.", test.username, test.userID, w.Code, w.Body.String(), test.want)
+		}
+	}
+
+	var list CoHostListResponse
+	w := serveAs("host", "GET", "/a/e/e/cohosts", "")
+	if json.Unmarshal(w.Body.Bytes(), &list); w.Code != http.StatusOK || len(list.Data) != 1 || list.Data[0] != "host" {
+		t.Errorf("ListCoHosts() returned %v %q.", w.Code, w.Body.String())
+	}
+	if w := serveAs("guest", "GET", "/a/e/e/cohosts", ""); w.Code != http.StatusForbidden {
+		t.Errorf("ListCoHosts() of a private event by a non-member returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+
+	// Co-hosts can edit the event and manage its members, but not delete it.
+	body := fmt.Sprintf(`{"name": "Big party", "desc": "Fun", "start": %q, "end": %q, "private": true}`,
+		now.Format(time.RFC3339), now.Add(2*time.Hour).Format(time.RFC3339))
+	if w := serveAs("host", "PUT", "/a/e/e", body); w.Code != http.StatusOK {
+		t.Errorf("UpdateEvent() by a co-host returned %v %q.", w.Code, w.Body.String())
+	}
+	if event, _ := Events.Get("e", c); event.Name != "Big party" || event.Creator != "creator" || !event.IsCoHost("host") {
+		t.Errorf("UpdateEvent() by a co-host stored %+v.", event)
+	}
+	if w := serveAs("host", "POST", "/a/e/e/members", `{"username": "guest"}`); w.Code != http.StatusCreated && w.Code != http.StatusOK {
+		t.Errorf("InviteMember() by a co-host returned %v %q.", w.Code, w.Body.String())
+	}
+	if w := serveAs("host", "DELETE", "/a/e/e", ""); w.Code != http.StatusForbidden {
+		t.Errorf("DeleteEvent() by a co-host returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+
+	// Co-hosts moderate posts, which the author cannot restore.
+	if w := serveAs("host", "DELETE", "/a/p/p", ""); w.Code != http.StatusOK {
+		t.Fatalf("DeletePost() by a co-host returned %v %q.", w.Code, w.Body.String())
+	}
+	if w := serveAs("guest", "POST", "/a/p/p/restore", ""); w.Code != http.StatusForbidden {
+		t.Errorf("RestorePost() of a removed post by its author returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+	if w := serveAs("host", "POST", "/a/p/p/restore", ""); w.Code != http.StatusOK {
+		t.Errorf("RestorePost() by a co-host returned %v %q.", w.Code, w.Body.String())
+	}
+
+	if w := serveAs("guest", "DELETE", "/a/e/e/cohosts/host", ""); w.Code != http.StatusForbidden {
+		t.Errorf("RemoveCoHost() by another user returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+	if w := serveAs("host", "DELETE", "/a/e/e/cohosts/creator", ""); w.Code != http.StatusNotFound {
+		t.Errorf("RemoveCoHost() of the creator returned %v. Wanted %v.", w.Code, http.StatusNotFound)
+	}
+	if w := serveAs("host", "DELETE", "/a/e/e/cohosts/host", ""); w.Code != http.StatusOK {
+		t.Errorf("RemoveCoHost() of themselves by a co-host returned %v %q.", w.Code, w.Body.String())
+	}
+	if w := serveAs("host", "DELETE", "/a/p/p", ""); w.Code != http.StatusForbidden {
+		t.Errorf("DeletePost() by a removed co-host returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build appengine
+// +build appengine
+
+package api
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// NewContext returns the context used while handling a request.
+func NewContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
@@ -0,0 +1,15 @@
+//go:build !appengine
+// +build !appengine
+
+package api
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// NewContext returns the context used while handling a request.
+func NewContext(r *http.Request) context.Context {
+	return r.Context()
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"errors"
+	"net/http"
+	"time"
+)
+
+const DEAD_LETTER_KIND = "deadLetter"
+
+// A DeadLetter records a task which failed too many times to be retried again, so that
+// the work it was meant to do can be looked into and finished by hand.
+type DeadLetter struct {
+	ID    string
+	Queue string
+	Path  string
+	// Params are the task's form values, URL encoded.
+	Params  string
+	Error   string
+	Retries int
+	Created time.Time
+}
+
+// recordDeadLetter stores a DeadLetter for a task request which failed with taskErr, and
+// will not be retried.
+func recordDeadLetter(r *http.Request, taskErr error, c context.Context) error {
+	r.ParseForm()
+	deadLetter := &DeadLetter{
+		ID:      IDs.Next().String(),
+		Queue:   r.Header.Get("X-AppEngine-QueueName"),
+		Path:    r.URL.Path,
+		Params:  r.PostForm.Encode(),
+		Error:   taskErr.Error(),
+		Retries: tasks.RetryCount(r),
+		Created: time.Now(),
+	}
+
+	log.Criticalf(c, "Task %v on queue %v with %v failed after %v retries, recording dead letter %v: %v",
+		deadLetter.Path, deadLetter.Queue, deadLetter.Params, deadLetter.Retries, deadLetter.ID, taskErr)
+
+	return DeadLetters.Put(deadLetter, c)
+}
+
+func getDeadLetterDSKey(deadLetterID string, c context.Context) (*datastore.Key, error) {
+	if deadLetterID == "" {
+		return nil, errors.New("No deadLetterID provided.")
+	}
+
+	return datastore.NewKey(c, DEAD_LETTER_KIND, deadLetterID, 0, nil), nil
+}
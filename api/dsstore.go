@@ -0,0 +1,913 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+
+	"time"
+)
+
+// DatastoreUserStore stores AppUsers in App Engine Datastore.
+type DatastoreUserStore struct{}
+
+// DatastoreEventStore stores Events in App Engine Datastore.
+type DatastoreEventStore struct{}
+
+// DatastorePostStore stores Posts in App Engine Datastore.
+type DatastorePostStore struct{}
+
+// DatastoreJobStore stores Jobs in App Engine Datastore.
+type DatastoreJobStore struct{}
+
+// DatastoreDeadLetterStore stores DeadLetters in App Engine Datastore.
+type DatastoreDeadLetterStore struct{}
+
+// DatastoreTrashStore stores TrashItems in App Engine Datastore.
+type DatastoreTrashStore struct{}
+
+// DatastoreTokenStore stores AccessTokens in App Engine Datastore.
+type DatastoreTokenStore struct{}
+
+// DatastoreMemberStore stores Members in App Engine Datastore.
+type DatastoreMemberStore struct{}
+
+// DatastoreInviteLinkStore stores InviteLinks in App Engine Datastore.
+type DatastoreInviteLinkStore struct{}
+
+func (s *DatastoreUserStore) Get(userID string, c context.Context) (*AppUser, error) {
+	appUser := new(AppUser)
+	userKey, err := getUserDSKey(userID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = datastore.Get(c, userKey, appUser)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return appUser, nil
+}
+
+func (s *DatastoreUserStore) GetMulti(userIDs []string, c context.Context) (map[string]*AppUser, error) {
+	keys := make([]*datastore.Key, len(userIDs))
+	for i, userID := range userIDs {
+		key, err := getUserDSKey(userID, c)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	appUsers := make([]AppUser, len(keys))
+	err := datastore.GetMulti(c, keys, appUsers)
+	errs, isMulti := err.(appengine.MultiError)
+	if err != nil && !isMulti {
+		return nil, err
+	}
+
+	found := make(map[string]*AppUser, len(appUsers))
+	for i := range appUsers {
+		if isMulti && errs[i] != nil {
+			if errs[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return nil, errs[i]
+		}
+		found[userIDs[i]] = &appUsers[i]
+	}
+
+	return found, nil
+}
+
+// GetByName looks up the username's claim, so it sees users created or renamed a moment
+// ago. Users saved before usernames were claimed are found with a query instead.
+func (s *DatastoreUserStore) GetByName(username string, c context.Context) (*AppUser, error) {
+	var claim usernameClaim
+	err := datastore.Get(c, usernameDSKey(username, c), &claim)
+	if err == nil {
+		return s.Get(claim.UserID, c)
+	}
+	if err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+
+	q := datastore.NewQuery(USER_KIND).
+		Filter("Username =", username)
+
+	for r := q.Run(c); ; {
+		var u AppUser
+		_, err := r.Next(&u)
+		if err == datastore.Done {
+			return nil, ErrNoSuchEntity
+		}
+		if err != nil {
+			log.Errorf(c, "Query failed to fetch AppUser with username '%v'\n%v", username, err)
+			return nil, err
+		}
+
+		return &u, nil
+	}
+}
+
+func (s *DatastoreUserStore) Create(appUser *AppUser, c context.Context) error {
+	userKey, err := getUserDSKey(appUser.ID, c)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkUnclaimedUsername(appUser, c); err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		err := datastore.Get(tc, userKey, new(AppUser))
+		if err == nil {
+			return ErrUserExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if err := claimUsername(appUser.Username, appUser.ID, tc); err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(tc, userKey, appUser)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+func (s *DatastoreUserStore) Update(appUser *AppUser, c context.Context) error {
+	userKey, err := getUserDSKey(appUser.ID, c)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkUnclaimedUsername(appUser, c); err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		var existing AppUser
+		if err := datastore.Get(tc, userKey, &existing); err != nil {
+			return dsError(err)
+		}
+
+		if err := claimUsername(appUser.Username, appUser.ID, tc); err != nil {
+			return err
+		}
+
+		if !sameUsername(existing.Username, appUser.Username) {
+			if err := releaseUsername(existing.Username, appUser.ID, tc); err != nil {
+				return err
+			}
+		}
+
+		_, err := datastore.Put(tc, userKey, appUser)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+// checkUnclaimedUsername returns ErrUsernameTaken if another user saved before usernames
+// were claimed has appUser's username, as such users have no claim to conflict with.
+func (s *DatastoreUserStore) checkUnclaimedUsername(appUser *AppUser, c context.Context) error {
+	existing, err := s.GetByName(appUser.Username, c)
+	if err == ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != appUser.ID {
+		return ErrUsernameTaken
+	}
+
+	return nil
+}
+
+func (s *DatastoreUserStore) Delete(userID string, c context.Context) error {
+	userKey, err := getUserDSKey(userID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		var existing AppUser
+		err := datastore.Get(tc, userKey, &existing)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := releaseUsername(existing.Username, userID, tc); err != nil {
+			return err
+		}
+
+		return datastore.Delete(tc, userKey)
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+func (s *DatastoreUserStore) All(req PageRequest, c context.Context) (*UserPage, error) {
+	q := datastore.NewQuery(USER_KIND).
+		Order(req.Order).
+		Limit(req.Limit + 1)
+
+	if req.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(req.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		q = q.Start(cursor)
+	}
+
+	page := &UserPage{Items: make([]AppUser, 0, req.Limit)}
+	var next datastore.Cursor
+
+	for it := q.Run(c); ; {
+		var appUser AppUser
+		_, err := it.Next(&appUser)
+		if err == datastore.Done {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Items) == req.Limit {
+			page.NextCursor = next.String()
+			return page, nil
+		}
+
+		page.Items = append(page.Items, appUser)
+		if len(page.Items) == req.Limit {
+			if next, err = it.Cursor(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *DatastoreEventStore) Get(eventID string, c context.Context) (*Event, error) {
+	eventKey, err := getEventDSKey(eventID, c)
+	if err != nil {
+		log.Errorf(c, "Cannot fetch Event: %v", err)
+		return nil, err
+	}
+
+	event := new(Event)
+	err = datastore.Get(c, eventKey, event)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return event, nil
+}
+
+func (s *DatastoreEventStore) Put(event *Event, c context.Context) error {
+	eventKey, err := getEventDSKey(event.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to create event entity key: %v\n", err)
+		return err
+	}
+
+	if _, err := datastore.Put(c, eventKey, event); err != nil {
+		log.Errorf(c, "Failed to store event entity: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *DatastoreEventStore) Update(eventID string, update func(*Event) error, c context.Context) (*Event, error) {
+	eventKey, err := getEventDSKey(eventID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var event *Event
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		// The transaction may be retried, and Get appends to slices, so each try starts
+		// with a new event.
+		event = new(Event)
+		if err := datastore.Get(tc, eventKey, event); err != nil {
+			return dsError(err)
+		}
+		if err := update(event); err != nil {
+			return err
+		}
+
+		_, err := datastore.Put(tc, eventKey, event)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func (s *DatastoreEventStore) Delete(eventID string, c context.Context) error {
+	eventKey, err := getEventDSKey(eventID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, eventKey)
+}
+
+func (s *DatastoreEventStore) Feed(order string, page int, c context.Context) ([]Event, error) {
+	events := make([]Event, 0, PAGE_SIZE)
+
+	q := datastore.NewQuery(EVENT_KIND).
+		Order(order).
+		Limit(PAGE_SIZE).
+		Offset(PAGE_SIZE * page)
+
+	_, err := q.GetAll(c, &events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// FeedPage uses Datastore query cursors, which are already opaque.
+func (s *DatastoreEventStore) FeedPage(req PageRequest, c context.Context) (*EventPage, error) {
+	q := datastore.NewQuery(EVENT_KIND).
+		Order(req.Order)
+
+	return getEvents(q, req, c)
+}
+
+func (s *DatastoreEventStore) ByCreator(creatorID string, req PageRequest, c context.Context) (*EventPage, error) {
+	q := datastore.NewQuery(EVENT_KIND).
+		Filter("Creator =", creatorID).
+		Order(req.Order)
+
+	return getEvents(q, req, c)
+}
+
+func getEvents(q *datastore.Query, req PageRequest, c context.Context) (*EventPage, error) {
+	q = q.Limit(req.Limit + 1)
+	if req.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(req.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		q = q.Start(cursor)
+	}
+
+	page := &EventPage{Items: make([]Event, 0, req.Limit)}
+	var next datastore.Cursor
+
+	for it := q.Run(c); ; {
+		var event Event
+		_, err := it.Next(&event)
+		if err == datastore.Done {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// One event more than the limit is fetched only to find out whether there is a
+		// next page.
+		if len(page.Items) == req.Limit {
+			page.NextCursor = next.String()
+			return page, nil
+		}
+
+		page.Items = append(page.Items, event)
+		if len(page.Items) == req.Limit {
+			if next, err = it.Cursor(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *DatastorePostStore) Get(postID string, c context.Context) (*Post, error) {
+	post := new(Post)
+	postKey, err := getPostDSKey(postID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = datastore.Get(c, postKey, post)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return post, nil
+}
+
+func (s *DatastorePostStore) Put(post *Post, c context.Context) error {
+	postKey, err := getPostDSKey(post.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, postKey, post)
+	return err
+}
+
+func (s *DatastorePostStore) Delete(postID string, c context.Context) error {
+	postKey, err := getPostDSKey(postID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, postKey)
+}
+
+func (s *DatastorePostStore) ByUser(userID string, req PageRequest, c context.Context) (*PostPage, error) {
+	q := datastore.NewQuery(POST_KIND).
+		Filter("UserID =", userID).
+		Order(req.Order)
+
+	return getPosts(q, req, c)
+}
+
+func (s *DatastorePostStore) ByEvent(eventID string, req PageRequest, c context.Context) (*PostPage, error) {
+	q := datastore.NewQuery(POST_KIND).
+		Filter("EventID =", eventID).
+		Order(req.Order)
+
+	return getPosts(q, req, c)
+}
+
+func (s *DatastorePostStore) Imageless(before time.Time, req PageRequest, c context.Context) (*PostPage, error) {
+	q := datastore.NewQuery(POST_KIND).
+		Filter("Image =", "").
+		Filter("Created <", before).
+		Order(req.Order)
+
+	return getPosts(q, req, c)
+}
+
+func (s *DatastorePostStore) All(req PageRequest, c context.Context) (*PostPage, error) {
+	q := datastore.NewQuery(POST_KIND).
+		Order(req.Order)
+
+	return getPosts(q, req, c)
+}
+
+func getPosts(q *datastore.Query, req PageRequest, c context.Context) (*PostPage, error) {
+	q = q.Limit(req.Limit + 1)
+	if req.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(req.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		q = q.Start(cursor)
+	}
+
+	page := &PostPage{Items: make([]Post, 0, req.Limit)}
+	var next datastore.Cursor
+
+	for it := q.Run(c); ; {
+		var post Post
+		_, err := it.Next(&post)
+		if err == datastore.Done {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Items) == req.Limit {
+			page.NextCursor = next.String()
+			return page, nil
+		}
+
+		page.Items = append(page.Items, post)
+		if len(page.Items) == req.Limit {
+			if next, err = it.Cursor(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *DatastoreJobStore) Get(jobID string, c context.Context) (*Job, error) {
+	jobKey, err := getJobDSKey(jobID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	job := new(Job)
+	err = datastore.Get(c, jobKey, job)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return job, nil
+}
+
+func (s *DatastoreJobStore) Put(job *Job, c context.Context) error {
+	jobKey, err := getJobDSKey(job.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, jobKey, job)
+	return err
+}
+
+func (s *DatastoreDeadLetterStore) Get(deadLetterID string, c context.Context) (*DeadLetter, error) {
+	deadLetterKey, err := getDeadLetterDSKey(deadLetterID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetter := new(DeadLetter)
+	err = datastore.Get(c, deadLetterKey, deadLetter)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return deadLetter, nil
+}
+
+func (s *DatastoreDeadLetterStore) Put(deadLetter *DeadLetter, c context.Context) error {
+	deadLetterKey, err := getDeadLetterDSKey(deadLetter.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, deadLetterKey, deadLetter)
+	return err
+}
+
+func (s *DatastoreTrashStore) Get(kind, id string, c context.Context) (*TrashItem, error) {
+	itemKey, err := getTrashDSKey(kind, id, c)
+	if err != nil {
+		return nil, err
+	}
+
+	item := new(TrashItem)
+	err = datastore.Get(c, itemKey, item)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return item, nil
+}
+
+func (s *DatastoreTrashStore) Put(item *TrashItem, c context.Context) error {
+	itemKey, err := getTrashDSKey(item.Kind, item.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, itemKey, item)
+	return err
+}
+
+func (s *DatastoreTrashStore) Delete(kind, id string, c context.Context) error {
+	itemKey, err := getTrashDSKey(kind, id, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, itemKey)
+}
+
+func (s *DatastoreTrashStore) ByOwner(ownerID string, req PageRequest, c context.Context) (*TrashPage, error) {
+	q := datastore.NewQuery(TRASH_KIND).
+		Filter("OwnerID =", ownerID).
+		Order(req.Order)
+
+	return getTrashItems(q, req, c)
+}
+
+func (s *DatastoreTrashStore) ByParent(parentID string, req PageRequest, c context.Context) (*TrashPage, error) {
+	q := datastore.NewQuery(TRASH_KIND).
+		Filter("ParentID =", parentID).
+		Order(req.Order)
+
+	return getTrashItems(q, req, c)
+}
+
+func (s *DatastoreTrashStore) Expired(before time.Time, req PageRequest, c context.Context) (*TrashPage, error) {
+	q := datastore.NewQuery(TRASH_KIND).
+		Filter("Trashed <", before).
+		Order(req.Order)
+
+	return getTrashItems(q, req, c)
+}
+
+func getTrashItems(q *datastore.Query, req PageRequest, c context.Context) (*TrashPage, error) {
+	q = q.Limit(req.Limit + 1)
+	if req.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(req.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		q = q.Start(cursor)
+	}
+
+	page := &TrashPage{Items: make([]TrashItem, 0, req.Limit)}
+	var next datastore.Cursor
+
+	for it := q.Run(c); ; {
+		var item TrashItem
+		_, err := it.Next(&item)
+		if err == datastore.Done {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Items) == req.Limit {
+			page.NextCursor = next.String()
+			return page, nil
+		}
+
+		page.Items = append(page.Items, item)
+		if len(page.Items) == req.Limit {
+			if next, err = it.Cursor(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *DatastoreTokenStore) Get(tokenID string, c context.Context) (*AccessToken, error) {
+	tokenKey, err := getTokenDSKey(tokenID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	token := new(AccessToken)
+	err = datastore.Get(c, tokenKey, token)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return token, nil
+}
+
+func (s *DatastoreTokenStore) Put(token *AccessToken, c context.Context) error {
+	tokenKey, err := getTokenDSKey(token.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, tokenKey, token)
+	return err
+}
+
+func (s *DatastoreTokenStore) Delete(tokenID string, c context.Context) error {
+	tokenKey, err := getTokenDSKey(tokenID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, tokenKey)
+}
+
+func (s *DatastoreTokenStore) Touch(tokenID string, lastUsed time.Time, c context.Context) error {
+	tokenKey, err := getTokenDSKey(tokenID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		token := new(AccessToken)
+		if err := datastore.Get(tc, tokenKey, token); err != nil {
+			return dsError(err)
+		}
+
+		token.LastUsed = lastUsed
+		_, err := datastore.Put(tc, tokenKey, token)
+		return err
+	}, nil)
+}
+
+func (s *DatastoreTokenStore) ByUser(userID string, c context.Context) ([]AccessToken, error) {
+	q := datastore.NewQuery(TOKEN_KIND).
+		Filter("UserID =", userID).
+		Order("-Created").
+		Limit(MAX_TOKENS)
+
+	tokens := make([]AccessToken, 0)
+	if _, err := q.GetAll(c, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *DatastoreMemberStore) Get(eventID, userID string, c context.Context) (*Member, error) {
+	memberKey, err := getMemberDSKey(eventID, userID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	member := new(Member)
+	err = datastore.Get(c, memberKey, member)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return member, nil
+}
+
+// Put keeps the event's memberCount in the same transaction as new members, as members
+// are not in the event's entity group and cannot be queried in a transaction.
+func (s *DatastoreMemberStore) Put(member *Member, c context.Context) error {
+	memberKey, err := getMemberDSKey(member.EventID, member.UserID, c)
+	if err != nil {
+		return err
+	}
+	countKey := memberCountDSKey(member.EventID, c)
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		err := datastore.Get(tc, memberKey, new(Member))
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err == nil {
+			_, err := datastore.Put(tc, memberKey, member)
+			return err
+		}
+
+		var count memberCount
+		err = datastore.Get(tc, countKey, &count)
+		if err == datastore.ErrNoSuchEntity {
+			// Events whose members were added before they were counted are counted once.
+			count.Count, err = datastore.NewQuery(MEMBER_KIND).
+				Filter("EventID =", member.EventID).
+				KeysOnly().
+				Count(c)
+		}
+		if err != nil {
+			return err
+		}
+		if count.Count >= MAX_MEMBERS {
+			return ErrEventFull
+		}
+
+		count.Count++
+		if _, err := datastore.Put(tc, countKey, &count); err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(tc, memberKey, member)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+func (s *DatastoreMemberStore) Delete(eventID, userID string, c context.Context) error {
+	memberKey, err := getMemberDSKey(eventID, userID, c)
+	if err != nil {
+		return err
+	}
+	countKey := memberCountDSKey(eventID, c)
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		err := datastore.Get(tc, memberKey, new(Member))
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var count memberCount
+		err = datastore.Get(tc, countKey, &count)
+		switch {
+		case err == datastore.ErrNoSuchEntity:
+		case err != nil:
+			return err
+		case count.Count <= 1:
+			err = datastore.Delete(tc, countKey)
+		default:
+			count.Count--
+			_, err = datastore.Put(tc, countKey, &count)
+		}
+		if err != nil {
+			return err
+		}
+
+		return datastore.Delete(tc, memberKey)
+	}, &datastore.TransactionOptions{XG: true})
+}
+
+func (s *DatastoreMemberStore) ByEvent(eventID string, c context.Context) ([]Member, error) {
+	q := datastore.NewQuery(MEMBER_KIND).
+		Filter("EventID =", eventID).
+		Order("Created").
+		Limit(MAX_MEMBERS)
+
+	members := make([]Member, 0)
+	if _, err := q.GetAll(c, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *DatastoreMemberStore) ByUser(userID string, c context.Context) ([]Member, error) {
+	q := datastore.NewQuery(MEMBER_KIND).
+		Filter("UserID =", userID).
+		Order("Created")
+
+	members := make([]Member, 0)
+	if _, err := q.GetAll(c, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *DatastoreInviteLinkStore) Get(linkID string, c context.Context) (*InviteLink, error) {
+	linkKey, err := getLinkDSKey(linkID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	link := new(InviteLink)
+	err = datastore.Get(c, linkKey, link)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	return link, nil
+}
+
+func (s *DatastoreInviteLinkStore) Put(link *InviteLink, c context.Context) error {
+	linkKey, err := getLinkDSKey(link.ID, c)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, linkKey, link)
+	return err
+}
+
+func (s *DatastoreInviteLinkStore) Delete(linkID string, c context.Context) error {
+	linkKey, err := getLinkDSKey(linkID, c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, linkKey)
+}
+
+func (s *DatastoreInviteLinkStore) Use(linkID string, c context.Context) (*InviteLink, error) {
+	linkKey, err := getLinkDSKey(linkID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	link := new(InviteLink)
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := datastore.Get(tc, linkKey, link); err != nil {
+			return dsError(err)
+		}
+		if link.Uses >= link.MaxUses {
+			return ErrLinkUsedUp
+		}
+
+		link.Uses++
+		_, err := datastore.Put(tc, linkKey, link)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func (s *DatastoreInviteLinkStore) ByEvent(eventID string, c context.Context) ([]InviteLink, error) {
+	q := datastore.NewQuery(LINK_KIND).
+		Filter("EventID =", eventID).
+		Order("-Created").
+		Limit(MAX_LINKS)
+
+	links := make([]InviteLink, 0)
+	if _, err := q.GetAll(c, &links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// dsError translates Datastore errors into the errors returned by all stores.
+func dsError(err error) error {
+	if err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	}
+
+	return err
+}
@@ -1,9 +1,10 @@
 package api
 
 import (
-	"appengine"
-	"appengine/datastore"
-	"appengine/user"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
 
 	"errors"
 	"net/http"
@@ -20,6 +21,10 @@ const MAX_EVENT_LENGTH = time.Hour * 168 // 1 week
 // How far in the future an event can be scheduled to start
 const MAX_START_FUTURE = time.Hour * 672 // 4 weeks
 
+// Most pages of events read from the store to fill one page of the feed, when private
+// events the user cannot view are left out.
+const MAX_FEED_READS = 5
+
 type Event struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -30,6 +35,10 @@ type Event struct {
 	Creator     string    `json:"creator"`
 	Created     time.Time `json:"created"`
 	Modified    time.Time `json:"modified"`
+
+	// CoHosts are the IDs of users the creator added to help run the event. They may
+	// edit it, moderate its posts and manage its members, but not delete it.
+	CoHosts []string `json:"coHosts"`
 }
 
 type EventView struct {
@@ -38,9 +47,25 @@ type EventView struct {
 	Start       time.Time  `json:"start"`
 	End         time.Time  `json:"end"`
 	Creator     string     `json:"creator"`
+	CoHosts     []string   `json:"coHosts"`
 	Created     time.Time  `json:"created"`
 	Modified    time.Time  `json:"modified"`
 	Posts       []PostView `json:"posts"`
+
+	// MorePosts is the URL of the next page of posts, if there is one.
+	MorePosts string `json:"-"`
+}
+
+// FeedEvent is an event in the feed along with its creator's username.
+type FeedEvent struct {
+	Event
+	CreatorName string `json:"creatorName"`
+}
+
+// FeedEventPage is a page of the event feed, as returned by EventsFeed.
+type FeedEventPage struct {
+	Items      []FeedEvent `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
 }
 
 type CreateEventResponse struct {
@@ -113,6 +138,17 @@ func (event *Event) HasValidDuration() bool {
 	return true
 }
 
+// IsCoHost reports whether a user is one of an event's co-hosts.
+func (event *Event) IsCoHost(userID string) bool {
+	for _, id := range event.CoHosts {
+		if id == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (event *Event) IsActive() bool {
 	if !event.HasValidDuration() {
 		return false
@@ -122,83 +158,148 @@ func (event *Event) IsActive() bool {
 	return event.End.After(now) && event.Start.Before(now)
 }
 
-func (event *Event) AuthorizeView(c appengine.Context) error {
-	if !event.Private {
-		return nil
+// AuthorizeView determines if a user may view an event. p is nil if no user is signed in.
+// Private events can be viewed by the users allowed by Can, and by their members.
+func (event *Event) AuthorizeView(p *Principal, c context.Context) error {
+	if !event.canView(p, c) {
+		log.Infof(c, "User %+v is not authorized to view private event %v.", p, event.ID)
+		return new(ErrPrivateEvent)
 	}
 
-	u := user.Current(c)
-	if u == nil {
-		c.Infof("No user signed in - Cannot access private event.")
-		return new(ErrPrivateEvent)
-	} else {
-		appUser, err := FetchAppUser(u.ID, c)
+	return nil
+}
+
+// canView reports whether p may view an event, like AuthorizeView. Users are denied if
+// their membership cannot be looked up.
+func (event *Event) canView(p *Principal, c context.Context) bool {
+	if Can(p, ACTION_VIEW_EVENT, event) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	member, err := isMember(event.ID, p.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to look up membership of user %v in event %v: %v", p.ID, event.ID, err)
+	}
+
+	return member
+}
+
+// An eventViewer decides which events a user may view. The events the user has joined are
+// looked up at once, when the first private event they are not a host of is found.
+type eventViewer struct {
+	p      *Principal
+	joined map[string]bool
+}
+
+// filter returns the events which the viewer may view.
+func (v *eventViewer) filter(events []Event, c context.Context) ([]Event, error) {
+	visible := make([]Event, 0, len(events))
+	for i := range events {
+		event := &events[i]
+		if !Can(v.p, ACTION_VIEW_EVENT, event) {
+			if v.p == nil {
+				continue
+			}
+			if v.joined == nil {
+				joined, err := joinedEvents(v.p.ID, c)
+				if err != nil {
+					log.Errorf(c, "Failed to look up events joined by user %v: %v", v.p.ID, err)
+					return nil, err
+				}
+				v.joined = joined
+			}
+			if !v.joined[event.ID] {
+				continue
+			}
+		}
+		visible = append(visible, *event)
+	}
+
+	return visible, nil
+}
+
+// fetchFeedPage returns a page of the event feed holding only the events p may view. When
+// private events are left out, the page is filled from the following ones, reading at most
+// MAX_FEED_READS pages from the store.
+func fetchFeedPage(req PageRequest, p *Principal, c context.Context) (*EventPage, error) {
+	viewer := &eventViewer{p: p}
+	feed := &EventPage{Items: make([]Event, 0, req.Limit)}
+	for reads := 0; reads < MAX_FEED_READS; reads++ {
+		page, err := Events.FeedPage(req, c)
 		if err != nil {
-			c.Infof("Cannot find user with ID %v - Cannot access private event.", u.ID)
-			return new(ErrPrivateEvent)
+			return nil, err
 		}
 
-		cv := event.userCanView(appUser)
-		if !cv {
-			c.Infof("User %v is not authorized to view private event %v.", appUser.ID, event.ID)
-			return new(ErrPrivateEvent)
+		visible, err := viewer.filter(page.Items, c)
+		if err != nil {
+			return nil, err
 		}
+
+		// Each read is limited to the events still missing, so the last read's cursor
+		// follows the last event in the feed.
+		feed.Items = append(feed.Items, visible...)
+		feed.NextCursor = page.NextCursor
+		if len(visible) == req.Limit || page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+		req.Limit -= len(visible)
 	}
 
-	return nil
+	return feed, nil
 }
 
-// TODO Not implemented yet - always denies view for private events
-func (event *Event) userCanView(appUser *AppUser) bool {
-	return false
+// canModerate reports whether p may moderate the posts of an event, e.g. as one of its
+// co-hosts. Users are denied if the event cannot be fetched.
+func canModerate(p *Principal, eventID string, c context.Context) bool {
+	event, err := FetchEvent(eventID, c)
+	if err != nil {
+		if err != ErrNoSuchEntity {
+			log.Errorf(c, "Failed to fetch event %v: %v", eventID, err)
+		}
+		return false
+	}
+
+	return Can(p, ACTION_MODERATE_POSTS, event)
 }
 
 func CreateEvent(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 
 	u, err := getRequestUser(r)
 	if err != nil {
-		c.Errorf("Must be signed in to create event: %v\n", err)
+		log.Errorf(c, "Must be signed in to create event: %v\n", err)
 		http.Error(w, "Not signed in.", http.StatusForbidden)
 		return
 	}
 
 	existingUser, err := FetchAppUser(u.ID, c)
 	if err != nil {
-		c.Errorf("Not a registered user, cannot create an Event: %v", err)
+		log.Errorf(c, "Not a registered user, cannot create an Event: %v", err)
 		http.Error(w, "Must register to create an event.", http.StatusForbidden)
 		return
 	}
 
 	event := new(Event)
 	if err := readEntity(r, event); err != nil {
-		c.Errorf("Failed to read event new data from request body: %v", err)
+		log.Errorf(c, "Failed to read event new data from request body: %v", err)
 		http.Error(w, "Invalid event creation request.", http.StatusBadRequest)
 		return
 	}
 
 	if !event.IsValidRequest() {
-		c.Infof("Invalid event request object.")
+		log.Infof(c, "Invalid event request object.")
 		http.Error(w, "Invalid event data.", http.StatusBadRequest)
 		return
 	}
 
-	eID, err := NewUID(c)
-	if err != nil {
-		c.Errorf("Failed to generate event ID: %v", err)
-		http.Error(w, "Failed to create a new event.", http.StatusInternalServerError)
-		return
-	}
-
-	_, err = FetchEvent(eID, c)
-	if err != datastore.ErrNoSuchEntity {
-		c.Errorf("Duplicate event ID generated! Aborting. Error: %v", err)
-		http.Error(w, "Failed to create a event, please try again.", http.StatusInternalServerError)
-		return
-	}
-
-	event.ID = eID
+	event.ID = IDs.Next().String()
 	event.Creator = existingUser.ID
+	// Co-hosts are added with AddCoHost once the event exists.
+	event.CoHosts = nil
 	now := time.Now()
 	event.Created = now
 	event.Modified = now
@@ -209,13 +310,13 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !event.IsValid() {
-		c.Errorf("Event failed validation, aborting save.")
+		log.Errorf(c, "Event failed validation, aborting save.")
 		http.Error(w, "Failed to create a new event.", http.StatusInternalServerError)
 		return
 	}
 
-	if _, err = storeEvent(event, c); err != nil {
-		c.Errorf("Failed to store Event: %v", err)
+	if err = storeEvent(event, c); err != nil {
+		log.Errorf(c, "Failed to store Event: %v", err)
 		http.Error(w, "Failed to create a new event.", http.StatusInternalServerError)
 		return
 	}
@@ -225,30 +326,91 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, resp)
 }
 
+// EventsFeed returns a page of events as a FeedEventPage. The order, cursor and limit query
+// parameters choose the sort order, the page to start from, and the number of events.
+// Private events the signed in user cannot view are left out. Pages are filled with the
+// events which follow them, but a page may still hold fewer events than the limit, and be
+// followed by more, if many events in a row are left out.
 func EventsFeed(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
+
+	order := r.FormValue("order")
+	if !validFeedOrder(order) {
+		order = DEFAULT_FEED_ORDER
+	}
+
+	req, err := readPageRequest(r, order)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, _ := GetRequestPrincipal(r, c)
+	page, err := fetchFeedPage(req, p, c)
+	if err == ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to get event feed: %v", err)
+		http.Error(w, "Failed to fetch event feed.", http.StatusInternalServerError)
+		return
+	}
+
+	userIDs := make([]string, len(page.Items))
+	for i := range page.Items {
+		userIDs[i] = page.Items[i].Creator
+	}
+
+	usernames, err := FetchUsernames(userIDs, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch event feed.", http.StatusInternalServerError)
+		return
+	}
+
+	feed := &FeedEventPage{Items: make([]FeedEvent, len(page.Items)), NextCursor: page.NextCursor}
+	for i, event := range page.Items {
+		feed.Items[i] = FeedEvent{event, usernames[event.Creator]}
+	}
+
+	sendJsonResponse(w, feed)
+}
+
+// EventsFeedByPage returns a numbered page of events as a JSON array.
+// Deprecated: Use EventsFeed. This remains for clients of the /a/feed/e/{page} routes.
+func EventsFeedByPage(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
 	page := GetRequestVar(r, "page", c)
 	order := GetRequestVar(r, "order", c)
 
 	events, err := fetchEventFeed(page, order, c)
 	if err != nil {
 		http.Error(w, "Failed to fetch event feed.", http.StatusInternalServerError)
+		return
 	}
 
-	sendJsonResponse(w, events)
+	p, _ := GetRequestPrincipal(r, c)
+	visible, err := (&eventViewer{p: p}).filter(*events, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch event feed.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJsonResponse(w, visible)
 }
 
 func GetEvent(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	eventID := GetRequestVar(r, "id", c)
 	event, err := FetchEvent(eventID, c)
 	if err != nil {
-		c.Errorf("Failed to fetch event with ID %v: %v", eventID, err)
+		log.Errorf(c, "Failed to fetch event with ID %v: %v", eventID, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	err = event.AuthorizeView(c)
+	p, _ := GetRequestPrincipal(r, c)
+	err = event.AuthorizeView(p, c)
 	if err != nil {
 		http.Error(w, "This event is private. You are not authorized to view it.", http.StatusForbidden)
 		return
@@ -266,19 +428,25 @@ func GetEvent(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, resp)
 }
 
+// Errors that abort an update in UpdateEvent.
+var (
+	errUpdateDenied = errors.New("Not authorized to update the event.")
+	errInvalidEvent = errors.New("Invalid event.")
+)
+
 func UpdateEvent(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 
-	u, err := getRequestUser(r)
+	u, err := GetRequestPrincipal(r, c)
 	if err != nil {
-		c.Errorf("Must be signed in to create event: %v\n", err)
+		log.Errorf(c, "Must be signed in to create event: %v\n", err)
 		http.Error(w, "Not signed in.", http.StatusForbidden)
 		return
 	}
 
 	existingUser, err := FetchAppUser(u.ID, c)
 	if err != nil {
-		c.Errorf("Not a registered user, cannot update an Event: %v", err)
+		log.Errorf(c, "Not a registered user, cannot update an Event: %v", err)
 		http.Error(w, "Must register to create or update events.", http.StatusForbidden)
 		return
 	}
@@ -286,13 +454,13 @@ func UpdateEvent(w http.ResponseWriter, r *http.Request) {
 	eventID := GetRequestVar(r, "id", c)
 	event, err := FetchEvent(eventID, c)
 	if err != nil {
-		c.Errorf("Failed to fetch event with ID %v: %v", eventID, err)
+		log.Errorf(c, "Failed to fetch event with ID %v: %v", eventID, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	if event.Creator != existingUser.ID {
-		c.Errorf("User %v tried to update event created by %v - denied.", existingUser.ID, event.Creator)
+	if !Can(u, ACTION_UPDATE_EVENT, event) {
+		log.Errorf(c, "User %v tried to update event created by %v - denied.", existingUser.ID, event.Creator)
 		http.Error(w, "You are not authorized to updated this event.", http.StatusForbidden)
 		return
 	}
@@ -300,46 +468,61 @@ func UpdateEvent(w http.ResponseWriter, r *http.Request) {
 	updated := new(Event)
 	err = readEntity(r, updated)
 	if err != nil {
-		c.Errorf("Failed to read event data from request: %v", err)
+		log.Errorf(c, "Failed to read event data from request: %v", err)
 		http.Error(w, "Could not read event from request.", http.StatusBadRequest)
 		return
 	}
 
 	if !updated.IsValidRequest() {
-		c.Infof("Invalid event request object.")
+		log.Infof(c, "Invalid event request object.")
 		http.Error(w, "Invalid event data.", http.StatusBadRequest)
 		return
 	}
 
-	// Copy fields that can be modified
-	event.Name = updated.Name
-	event.Description = updated.Description
-	event.Private = updated.Private
-	// TODO Do we allow extending events that have expired?
-	event.End = updated.End
+	// The event is changed in a transaction, so that co-hosts added or removed since it
+	// was fetched are kept.
+	event, err = Events.Update(eventID, func(event *Event) error {
+		if !Can(u, ACTION_UPDATE_EVENT, event) {
+			return errUpdateDenied
+		}
 
-	now := time.Now()
-	// Only allow modifying the start time if it is still in the future.
-	if event.Start != updated.Start && event.Start.After(now) {
-		event.Start = updated.Start
-	}
+		// Copy fields that can be modified
+		event.Name = updated.Name
+		event.Description = updated.Description
+		event.Private = updated.Private
+		// TODO Do we allow extending events that have expired?
+		event.End = updated.End
+
+		now := time.Now()
+		// Only allow modifying the start time if it is still in the future.
+		if event.Start != updated.Start && event.Start.After(now) {
+			event.Start = updated.Start
+		}
 
-	// If Start is in the past, align it with current time.
-	if event.Start.Before(now) {
-		event.Start = now
-	}
+		// If Start is in the past, align it with current time.
+		if event.Start.Before(now) {
+			event.Start = now
+		}
 
-	event.Modified = time.Now()
+		event.Modified = time.Now()
 
-	if !event.IsValid() {
-		c.Errorf("Event failed validation, aborting update.")
+		if !event.IsValid() {
+			return errInvalidEvent
+		}
+		return nil
+	}, c)
+	switch err {
+	case nil:
+	case errUpdateDenied:
+		log.Errorf(c, "User %v lost the right to update event %v - denied.", existingUser.ID, eventID)
+		http.Error(w, "You are not authorized to updated this event.", http.StatusForbidden)
+		return
+	case errInvalidEvent:
+		log.Errorf(c, "Event failed validation, aborting update.")
 		http.Error(w, "Failed to update the event.", http.StatusInternalServerError)
 		return
-	}
-
-	_, err = storeEvent(event, c)
-	if err != nil {
-		c.Errorf("Failed to store updated Event with ID %v: %v", event.ID, err)
+	default:
+		log.Errorf(c, "Failed to store updated Event with ID %v: %v", eventID, err)
 		http.Error(w, "Failed to update the event.", http.StatusInternalServerError)
 		return
 	}
@@ -355,29 +538,71 @@ func UpdateEvent(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, resp)
 }
 
-// DeleteEvent permanently removes an Event, along with all Posts associated with the Event.
-// Not sure whether this will really be used during regular operation...
+// DeleteEvent moves an Event to the trash, along with all Posts associated with the Event.
+// Only the Event's creator and admins may delete it, and restore it with RestoreEvent
+// until it is purged. The Event is trashed right away, and its Posts afterwards by tasks on the
+// DELETION_QUEUE.
 func DeleteEvent(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Not implemented."))
-}
+	c := NewContext(r)
 
-func FetchEvent(eventID string, c appengine.Context) (*Event, error) {
-	eventKey, err := getEventDSKey(eventID, c)
+	u, err := GetRequestPrincipal(r, c)
 	if err != nil {
-		c.Errorf("Cannot fetch Event: %v", err)
-		return nil, err
+		log.Errorf(c, "Must be signed in to delete event: %v\n", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
 	}
 
-	event := new(Event)
-	err = datastore.Get(c, eventKey, event)
+	eventID := GetRequestVar(r, "id", c)
+	event, err := FetchEvent(eventID, c)
 	if err != nil {
-		return nil, err
+		log.Errorf(c, "Failed to fetch event with ID %v: %v", eventID, err)
+		http.NotFound(w, r)
+		return
 	}
 
-	return event, nil
+	if !Can(u, ACTION_DELETE_EVENT, event) {
+		log.Errorf(c, "User %v tried to delete event created by %v - denied.", u.ID, event.Creator)
+		http.Error(w, "You are not authorized to delete this event.", http.StatusForbidden)
+		return
+	}
+
+	err = trashEvent(event, u.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to trash event %v: %v", eventID, err)
+		http.Error(w, "Failed to delete the event.", http.StatusInternalServerError)
+		return
+	}
+
+	// The event is trashed first, so that no posts are added to it while its posts are
+	// trashed. If they cannot be, the event is put back to be deleted again.
+	err = queueEventPostsTrash(eventID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to queue trashing posts of event %v: %v", eventID, err)
+		if err := Events.Put(event, c); err != nil {
+			log.Errorf(c, "Failed to put back event %v: %v", eventID, err)
+			http.Error(w, "Failed to delete the event's posts. The event is in the trash.", http.StatusInternalServerError)
+			return
+		}
+		if err := Trash.Delete(EVENT_KIND, eventID, c); err != nil {
+			log.Errorf(c, "Put back event %v, but failed to delete its trashed copy: %v", eventID, err)
+			http.Error(w, "Failed to delete the event. It was not deleted, but a copy remains in the trash.", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Failed to delete the event. It was not deleted.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Trashed event %v, its posts will be trashed in the background.", eventID)
+
+	resp := OkResponse{true}
+	sendJsonResponse(w, resp)
+}
+
+func FetchEvent(eventID string, c context.Context) (*Event, error) {
+	return Events.Get(eventID, c)
 }
 
-func fetchEventFeed(page, order string, c appengine.Context) (*[]Event, error) {
+func fetchEventFeed(page, order string, c context.Context) (*[]Event, error) {
 	var pageNum int = 0
 	if page != "" {
 		// Ignore error and default to page 0
@@ -389,35 +614,17 @@ func fetchEventFeed(page, order string, c appengine.Context) (*[]Event, error) {
 		orderBy = order
 	}
 
-	events := make([]Event, 0, 20)
-
-	q := datastore.NewQuery(EVENT_KIND).
-		Order(orderBy).
-		Limit(20).
-		Offset(20 * pageNum)
-
-	_, err := q.GetAll(c, &events)
+	events, err := Events.Feed(orderBy, pageNum, c)
 	if err != nil {
-		c.Errorf("Failed to get event feed: %v", err)
+		log.Errorf(c, "Failed to get event feed: %v", err)
 		return nil, err
 	}
 
 	return &events, nil
 }
 
-func storeEvent(event *Event, c appengine.Context) (*datastore.Key, error) {
-	eventKey, err := getEventDSKey(event.ID, c)
-	if err != nil {
-		c.Errorf("Failed to create event entity key: %v\n", err)
-		return nil, err
-	}
-
-	if key, err := datastore.Put(c, eventKey, event); err != nil {
-		c.Errorf("Failed to store event entity: %v\n", err)
-		return nil, err
-	} else {
-		return key, nil
-	}
+func storeEvent(event *Event, c context.Context) error {
+	return Events.Put(event, c)
 }
 
 func validFeedOrder(order string) bool {
@@ -436,7 +643,7 @@ func validFeedOrder(order string) bool {
 	return false
 }
 
-func getEventDSKey(eventID string, c appengine.Context) (*datastore.Key, error) {
+func getEventDSKey(eventID string, c context.Context) (*datastore.Key, error) {
 	if eventID == "" {
 		return nil, errors.New("No eventID provided.")
 	}
@@ -446,9 +653,9 @@ func getEventDSKey(eventID string, c appengine.Context) (*datastore.Key, error)
 	return eventKey, nil
 }
 
-func createEventKeyID(eventID string, c appengine.Context) string {
+func createEventKeyID(eventID string, c context.Context) string {
 	if eventID == "" {
-		c.Errorf("Creating an event entity key with no eventID!")
+		log.Errorf(c, "Creating an event entity key with no eventID!")
 	}
 
 	return "event:" + eventID
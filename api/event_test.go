@@ -1,7 +1,8 @@
 package api
 
 import (
-	"appengine/aetest"
+	"google.golang.org/appengine/aetest"
+
 	"testing"
 	"time"
 )
@@ -257,10 +258,11 @@ func TestIsActive(t *testing.T) {
 }
 
 func TestCreateEventKeyID(t *testing.T) {
-	c, err := aetest.NewContext(nil)
+	c, done, err := aetest.NewContext()
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer done()
 
 	if createEventKeyID("123abc", c) != "event:123abc" {
 		t.Error("createEventKey(\"123abc\") failed")
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"archive/zip"
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Queue for tasks which build exports, configured in queue.yaml.
+const EXPORT_QUEUE = "exports"
+
+// Exports are stored in the imgstore under this prefix, followed by the user's ID. Objects
+// under it are private, so exports can only be read with DownloadExport. The sweeper
+// leaves them alone until they are older than EXPORT_TTL.
+const EXPORT_PREFIX = imgstore.PRIVATE_PREFIX
+
+// Time an export can be downloaded for after it is built.
+const EXPORT_TTL = 7 * 24 * time.Hour
+
+// exportProfile is the user's profile as written to an export, which unlike other
+// responses includes the user's email address.
+type exportProfile struct {
+	*AppUser
+	Email string `json:"email"`
+}
+
+// CreateExport starts a JOB_EXPORT job, which bundles the signed in user's profile,
+// posts, events and original images into a zip file. The response is the job, which has
+// a download link once it is done.
+func CreateExport(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := getRequestUser(r)
+	if err != nil {
+		log.Infof(c, "Must be signed in to export data: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	job := newJob(JOB_EXPORT, currentUser.ID)
+	job.File, err = exportFileName(job)
+	if err != nil {
+		log.Errorf(c, "Failed to name export for user %v: %v", currentUser.ID, err)
+		http.Error(w, "Failed to start export.", http.StatusInternalServerError)
+		return
+	}
+
+	err = saveJob(job, c)
+	if err != nil {
+		log.Errorf(c, "Failed to store job to export user %v: %v", currentUser.ID, err)
+		http.Error(w, "Failed to start export.", http.StatusInternalServerError)
+		return
+	}
+
+	err = tasks.Add(c, EXPORT_QUEUE, "/t/export-user", url.Values{
+		"jobID": {job.ID},
+	})
+	if err != nil {
+		log.Errorf(c, "Failed to queue export of user %v: %v", currentUser.ID, err)
+		http.Error(w, "Failed to start export.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Exporting user %v with job %v.", currentUser.ID, job.ID)
+
+	resp := JobResponse{true, *job}
+	sendJsonResponse(w, resp)
+}
+
+// DownloadExport responds with the zip file built by one of the signed in user's
+// JOB_EXPORT jobs.
+func DownloadExport(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to download an export: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	jobID := GetRequestVar(r, "id", c)
+	job, err := FetchJob(jobID, c)
+	if err == ErrNoSuchEntity || (err == nil && (job.Kind != JOB_EXPORT || !Can(currentUser, ACTION_DOWNLOAD, job))) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch job %v: %v", jobID, err)
+		http.Error(w, "Failed to fetch export.", http.StatusInternalServerError)
+		return
+	}
+
+	if job.Status != JOB_DONE {
+		http.Error(w, "The export is not ready yet.", http.StatusConflict)
+		return
+	}
+
+	rc, err := imgstore.Reader(c, job.File)
+	if err == imgstore.ErrObjectNotExist {
+		http.Error(w, "The export has expired.", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch export.", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gogram-export.zip"`)
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Errorf(c, "Failed to send export %v: %v", job.File, err)
+	}
+}
+
+// ExportUserTask runs a JOB_EXPORT job. The whole export is built by a single task, and
+// is built again from the start if the task is retried. It must be called as a task with
+// the jobID form value.
+func ExportUserTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	jobID := r.FormValue("jobID")
+	job, err := FetchJob(jobID, c)
+	if err == ErrNoSuchEntity {
+		log.Errorf(c, "Job %v to export a user does not exist.", jobID)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch job.", http.StatusInternalServerError)
+		return
+	}
+	if job.Status == JOB_DONE || job.Status == JOB_FAILED {
+		return
+	}
+
+	job.Status = JOB_RUNNING
+	job.Progress = 0
+	runErr := writeExport(job, c)
+	if runErr == nil {
+		// Exports are created private. Revoking makes sure, in case a store's defaults
+		// still made one public.
+		runErr = imgstore.Store.Revoke(c, job.File)
+	}
+	retry := false
+	if runErr != nil {
+		retry = recordJobError(job, runErr, r)
+		if retry {
+			log.Errorf(c, "Job %v failed to export user %v, it will be retried: %v", job.ID, job.UserID, runErr)
+		} else {
+			log.Errorf(c, "Job %v failed to export user %v, giving up: %v", job.ID, job.UserID, runErr)
+		}
+	} else {
+		log.Infof(c, "Job %v exported user %v to %v.", job.ID, job.UserID, job.File)
+		job.Status = JOB_DONE
+		job.Error = ""
+	}
+
+	err = saveJob(job, c)
+	if err != nil {
+		log.Errorf(c, "Failed to store job %v: %v", job.ID, err)
+	}
+
+	if retry || err != nil {
+		http.Error(w, "Failed to export user.", http.StatusInternalServerError)
+	}
+}
+
+// writeExport builds the export of a JOB_EXPORT job and stores it in job.File. The zip
+// file is streamed to the imgstore as it is built.
+func writeExport(job *Job, c context.Context) error {
+	pr, pw := io.Pipe()
+	built := make(chan error, 1)
+	go func() {
+		err := buildExport(job, pw, c)
+		pw.CloseWithError(err)
+		built <- err
+	}()
+
+	_, err := imgstore.Write(c, job.File, "application/zip", pr)
+	// Unblock the builder if the store stopped reading early, and wait for it to be done
+	// with the job.
+	pr.Close()
+	if buildErr := <-built; err == nil {
+		err = buildErr
+	}
+
+	return err
+}
+
+// buildExport writes a zip file holding the user's profile, posts and events as JSON,
+// and the original image of each post, to w. job.Progress counts the posts exported.
+func buildExport(job *Job, w io.Writer, c context.Context) error {
+	zw := zip.NewWriter(w)
+
+	appUser, err := FetchAppUser(job.UserID, c)
+	if err != nil {
+		return err
+	}
+	if err := writeExportJSON(zw, "profile.json", exportProfile{appUser, appUser.Email}); err != nil {
+		return err
+	}
+
+	// Images are written as the posts are paged through, and the posts themselves at
+	// the end, since only one file in a zip can be written at a time.
+	posts := make([]Post, 0, PAGE_SIZE)
+	req := PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}
+	for {
+		page, err := FetchUserPosts(job.UserID, req, c)
+		if err != nil {
+			return err
+		}
+
+		for i := range page.Items {
+			post := &page.Items[i]
+			if post.Image != "" {
+				if err := writeExportImage(zw, post, c); err != nil {
+					return err
+				}
+			}
+			posts = append(posts, *post)
+			job.Progress++
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	if err := writeExportJSON(zw, "posts.json", posts); err != nil {
+		return err
+	}
+
+	events := make([]Event, 0, PAGE_SIZE)
+	req = PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}
+	for {
+		page, err := Events.ByCreator(job.UserID, req, c)
+		if err != nil {
+			return err
+		}
+
+		events = append(events, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	if err := writeExportJSON(zw, "events.json", events); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeExportJSON(zw *zip.Writer, name string, v interface{}) error {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeExportImage writes the original image of a post to images/, named by the post's
+// ID and an extension for its content type. A missing image is left out.
+func writeExportImage(zw *zip.Writer, post *Post, c context.Context) error {
+	rc, err := imgstore.Reader(c, post.ImageName())
+	if err == imgstore.ErrObjectNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	sample, _ := br.Peek(512)
+
+	fw, err := zw.Create("images/" + post.ID + imageExtension(http.DetectContentType(sample)))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, br)
+	return err
+}
+
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/jpeg":
+		return ".jpg"
+	}
+
+	return ""
+}
+
+// exportFileName returns a new, hard to guess name for the export of a job, in case the
+// store serves it publicly.
+func exportFileName(job *Job) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return EXPORT_PREFIX + job.UserID + "/" + job.ID + "-" + hex.EncodeToString(b) + ".zip", nil
+}
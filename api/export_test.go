@@ -0,0 +1,124 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/tasks"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(users UserStore, events EventStore, posts PostStore, jobs JobStore, store imgstore.BlobStore, queue tasks.Queue) {
+		Users, Events, Posts, Jobs, imgstore.Store, tasks.Default = users, events, posts, jobs, store, queue
+	}(Users, Events, Posts, Jobs, imgstore.Store, tasks.Default)
+	Users, Events, Posts, Jobs = NewMemoryUserStore(), NewMemoryEventStore(), NewMemoryPostStore(), NewMemoryJobStore()
+	imgstore.Store = &imgstore.LocalStore{Dir: dir}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/exports", CreateExport).Methods("POST")
+	r.HandleFunc("/a/exports/{id}", DownloadExport).Methods("GET")
+	r.HandleFunc("/a/jobs/{id}", GetJob).Methods("GET")
+	r.HandleFunc("/t/export-user", ExportUserTask).Methods("POST")
+
+	queue := tasks.NewLocalQueue(map[string]http.Handler{"default": r})
+	tasks.Default = queue
+	defer queue.Shutdown(context.Background())
+
+	c := context.Background()
+	Users.Create(&AppUser{ID: "u", Username: "someone", Email: "someone@example.com"}, c)
+	Events.Put(&Event{ID: "mine", Creator: "u"}, c)
+	Events.Put(&Event{ID: "theirs", Creator: "other"}, c)
+
+	// More than a page of posts, so that the export has to page through them.
+	count := MAX_PAGE_SIZE + 5
+	for i := 0; i < count; i++ {
+		post := &Post{ID: strconv.Itoa(i), UserID: "u", EventID: "mine", Image: "link"}
+		Posts.Put(post, c)
+		imgstore.Write(c, post.ImageName(), "image/gif", strings.NewReader("GIF89a"))
+	}
+	Posts.Put(&Post{ID: "other", UserID: "other", EventID: "mine"}, c)
+
+	serveAs := func(userID, method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var resp JobResponse
+	if err := json.Unmarshal(serveAs("u", "POST", "/a/exports").Body.Bytes(), &resp); err != nil {
+		t.Fatalf("CreateExport() returned %v.", err)
+	}
+
+	jobURL := "/a/jobs/" + resp.Data.ID
+	deadline := time.Now().Add(5 * time.Second)
+	for resp.Data.Status != JOB_DONE {
+		if time.Now().After(deadline) {
+			t.Fatalf("Export is still %q after 5 seconds.", resp.Data.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		resp = JobResponse{}
+		json.Unmarshal(serveAs("u", "GET", jobURL).Body.Bytes(), &resp)
+	}
+	if resp.Data.Download == "" || resp.Data.Progress != count {
+		t.Fatalf("Export is done with download %q and progress %v.", resp.Data.Download, resp.Data.Progress)
+	}
+
+	if code := serveAs("other", "GET", resp.Data.Download).Code; code != http.StatusNotFound {
+		t.Errorf("DownloadExport() by another user returned status %v. Wanted %v.", code, http.StatusNotFound)
+	}
+
+	w := serveAs("u", "GET", resp.Data.Download)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("DownloadExport() returned status %v and no zip file: %v", w.Code, err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	if len(files) != count+3 || files["images/0.gif"] == nil {
+		t.Errorf("Export has %v files. Wanted %v images and 3 JSON files.", len(files), count)
+	}
+
+	var profile struct{ Email string }
+	var posts []Post
+	var events []Event
+	for name, v := range map[string]interface{}{"profile.json": &profile, "posts.json": &posts, "events.json": &events} {
+		if files[name] == nil {
+			t.Fatalf("Export has no %v.", name)
+		}
+		rc, _ := files[name].Open()
+		err := json.NewDecoder(rc).Decode(v)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode %v: %v", name, err)
+		}
+	}
+	if profile.Email != "someone@example.com" || len(posts) != count || len(events) != 1 {
+		t.Errorf("Export has email %q, %v posts and %v events.", profile.Email, len(posts), len(events))
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"errors"
+	"net/http"
+	"time"
+)
+
+const JOB_KIND = "job"
+
+// Kinds of jobs.
+const (
+	JOB_DELETE_USER = "delete-user"
+	JOB_EXPORT      = "export"
+)
+
+// Statuses of a job.
+const (
+	JOB_PENDING = "pending"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+// Number of times a failed task running a job is retried before the job fails. It must
+// match the task_retry_limit of DELETION_QUEUE and EXPORT_QUEUE, and the RetryLimit of a
+// LocalQueue.
+const MAX_JOB_RETRIES = 5
+
+// A Job tracks work done in the background by tasks on behalf of a user, so that the
+// user can check on its progress.
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	UserID string `json:"-"`
+	Status string `json:"status"`
+	// Progress is the number of items, e.g. posts, that have been handled so far.
+	Progress int `json:"progress"`
+	// Error is the last error the job ran into. The task running the job is retried until
+	// it has been retried MAX_JOB_RETRIES times, and then the job fails.
+	Error string `json:"error,omitempty"`
+
+	// DeleteContent is set on JOB_DELETE_USER jobs that delete the user's posts and images,
+	// rather than leaving them behind, anonymized.
+	DeleteContent bool `json:"deleteContent"`
+
+	// File is the name of the zip file in the imgstore built by a JOB_EXPORT job, and
+	// Download is the link to it, which is only set in responses once the job is done.
+	File     string `json:"-"`
+	Download string `json:"download,omitempty" datastore:"-"`
+
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+type JobResponse struct {
+	Ok   bool `json:"ok"`
+	Data Job  `json:"data"`
+}
+
+// GetJob responds with the status of a job. Users can see their own jobs, and admins anyone's.
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to get a job: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	jobID := GetRequestVar(r, "id", c)
+	job, err := FetchJob(jobID, c)
+	if err == ErrNoSuchEntity || (err == nil && !Can(currentUser, ACTION_VIEW_JOB, job)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch job %v: %v", jobID, err)
+		http.Error(w, "Failed to fetch job.", http.StatusInternalServerError)
+		return
+	}
+
+	if job.Kind == JOB_EXPORT && job.Status == JOB_DONE {
+		job.Download = "/a/exports/" + job.ID
+	}
+
+	resp := JobResponse{true, *job}
+	sendJsonResponse(w, resp)
+}
+
+// newJob creates a pending job of a kind for a user. It is not stored until saveJob is called.
+func newJob(kind, userID string) *Job {
+	now := time.Now()
+	return &Job{
+		ID:       IDs.Next().String(),
+		Kind:     kind,
+		UserID:   userID,
+		Status:   JOB_PENDING,
+		Created:  now,
+		Modified: now,
+	}
+}
+
+// recordJobError sets the error a job's task ran into, and marks the job JOB_FAILED if
+// the task will not be retried again. It reports whether the task will be retried.
+func recordJobError(job *Job, err error, r *http.Request) bool {
+	job.Error = err.Error()
+	if tasks.RetryCount(r) >= MAX_JOB_RETRIES {
+		job.Status = JOB_FAILED
+		return false
+	}
+
+	return true
+}
+
+func FetchJob(jobID string, c context.Context) (*Job, error) {
+	return Jobs.Get(jobID, c)
+}
+
+func saveJob(job *Job, c context.Context) error {
+	job.Modified = time.Now()
+	return Jobs.Put(job, c)
+}
+
+func getJobDSKey(jobID string, c context.Context) (*datastore.Key, error) {
+	if jobID == "" {
+		return nil, errors.New("No jobID provided.")
+	}
+
+	return datastore.NewKey(c, JOB_KIND, jobID, 0, nil), nil
+}
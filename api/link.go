@@ -0,0 +1,394 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const LINK_KIND = "inviteLink"
+
+// Most invite links an event may have at once.
+const MAX_LINKS = 20
+
+// Links expire after DEFAULT_LINK_AGE unless another time is chosen, up to MAX_LINK_AGE.
+const DEFAULT_LINK_AGE = 7 * 24 * time.Hour
+const MAX_LINK_AGE = 30 * 24 * time.Hour
+
+// InviteKey signs invite links. Links cannot be created or used while it is empty.
+var InviteKey []byte
+
+var (
+	ErrInvalidInvite = errors.New("This invite link is invalid or has expired.")
+	ErrLinkUsedUp    = errors.New("This invite link has been used up.")
+)
+
+// An InviteLink makes anyone who opens it a member of an event, until it expires, is
+// revoked or has been used MaxUses times. Links are signed with InviteKey, since their
+// IDs can be guessed.
+type InviteLink struct {
+	ID        string    `json:"id"`
+	EventID   string    `json:"event"`
+	CreatedBy string    `json:"-"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	Expires   time.Time `json:"expires"`
+	Created   time.Time `json:"created"`
+
+	// URL is the event page, which redeems the link when opened. It is not stored.
+	URL string `json:"url" datastore:"-"`
+}
+
+type InviteLinkResponse struct {
+	Ok   bool       `json:"ok"`
+	Data InviteLink `json:"data"`
+}
+
+type InviteLinkListResponse struct {
+	Ok   bool         `json:"ok"`
+	Data []InviteLink `json:"data"`
+}
+
+type linkRequest struct {
+	MaxUses int       `json:"maxUses"`
+	Expires time.Time `json:"expires"`
+}
+
+type joinRequest struct {
+	Invite string `json:"invite"`
+}
+
+// CreateInviteLink creates an invite link to an event, with the maxUses and expires given
+// in the request body, and responds with it. Links expire after DEFAULT_LINK_AGE if no
+// expiry is given.
+func CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchLinkEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	if len(InviteKey) == 0 {
+		http.Error(w, "Invite links are not enabled.", http.StatusServiceUnavailable)
+		return
+	}
+
+	req := new(linkRequest)
+	if err := readEntity(r, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if req.Expires.IsZero() {
+		req.Expires = now.Add(DEFAULT_LINK_AGE)
+	}
+	if !req.Expires.After(now) || req.Expires.Sub(now) > MAX_LINK_AGE {
+		http.Error(w, "A link must expire within 30 days.", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 1 || req.MaxUses > MAX_MEMBERS {
+		http.Error(w, fmt.Sprintf("A link can be used from 1 to %v times.", MAX_MEMBERS), http.StatusBadRequest)
+		return
+	}
+
+	links, err := InviteLinks.ByEvent(event.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invite links of event %v: %v", event.ID, err)
+		http.Error(w, "Failed to create invite link.", http.StatusInternalServerError)
+		return
+	}
+	if len(links) >= MAX_LINKS {
+		http.Error(w, "This event has too many invite links. Revoke one first.", http.StatusConflict)
+		return
+	}
+
+	link := &InviteLink{
+		ID:        IDs.Next().String(),
+		EventID:   event.ID,
+		CreatedBy: p.ID,
+		MaxUses:   req.MaxUses,
+		Expires:   req.Expires,
+		Created:   now,
+	}
+	if err := InviteLinks.Put(link, c); err != nil {
+		log.Errorf(c, "Failed to store invite link to event %v: %v", event.ID, err)
+		http.Error(w, "Failed to create invite link.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v created invite link %v to event %v.", p.ID, link.ID, event.ID)
+
+	link.URL = link.url()
+	resp := InviteLinkResponse{true, *link}
+	w.WriteHeader(http.StatusCreated)
+	sendJsonResponse(w, resp)
+}
+
+// ListInviteLinks responds with the invite links to an event, newest first, along with
+// how often each has been used.
+func ListInviteLinks(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, _, ok := fetchLinkEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	links, err := InviteLinks.ByEvent(event.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invite links of event %v: %v", event.ID, err)
+		http.Error(w, "Failed to fetch invite links.", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range links {
+		links[i].URL = links[i].url()
+	}
+
+	resp := InviteLinkListResponse{true, links}
+	sendJsonResponse(w, resp)
+}
+
+// RevokeInviteLink deletes an invite link, which stops working at once. Users who have
+// joined with it stay members.
+func RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchLinkEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	linkID := GetRequestVar(r, "linkID", c)
+	link, err := InviteLinks.Get(linkID, c)
+	if err == ErrNoSuchEntity || (err == nil && link.EventID != event.ID) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invite link %v: %v", linkID, err)
+		http.Error(w, "Failed to revoke invite link.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := InviteLinks.Delete(linkID, c); err != nil {
+		log.Errorf(c, "Failed to delete invite link %v: %v", linkID, err)
+		http.Error(w, "Failed to revoke invite link.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v revoked invite link %v to event %v.", p.ID, linkID, event.ID)
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// JoinEvent makes the signed in user a member of an event with the invite link token in
+// the request body, like opening the link does.
+func JoinEvent(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	p, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to join an event: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	eventID := GetRequestVar(r, "id", c)
+	event, err := FetchEvent(eventID, c)
+	if err != nil {
+		log.Infof(c, "Could not fetch event %v: %v", eventID, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	req := new(joinRequest)
+	if err := readEntity(r, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := RedeemInviteLink(event, p, req.Invite, c); err != nil {
+		http.Error(w, err.Error(), InviteErrorStatus(err))
+		return
+	}
+
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// RedeemInviteLink makes p a member of an event with the token of one of its invite links,
+// counting a use of the link. Users who can already view the event as its creator, a
+// co-host or a member do not use up the link.
+func RedeemInviteLink(event *Event, p *Principal, token string, c context.Context) error {
+	if p == nil {
+		return ErrInvalidInvite
+	}
+	if p.ID == event.Creator || event.IsCoHost(p.ID) {
+		return nil
+	}
+
+	joined, err := isMember(event.ID, p.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to look up membership of user %v in event %v: %v", p.ID, event.ID, err)
+		return err
+	}
+	if joined {
+		return nil
+	}
+
+	linkID, err := parseInviteToken(event.ID, token, time.Now())
+	if err != nil {
+		log.Infof(c, "User %v opened an invalid invite link to event %v: %v", p.ID, event.ID, err)
+		return err
+	}
+
+	link, err := InviteLinks.Get(linkID, c)
+	if err == ErrNoSuchEntity {
+		return ErrInvalidInvite
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invite link %v: %v", linkID, err)
+		return err
+	}
+	if link.Uses >= link.MaxUses {
+		return ErrLinkUsedUp
+	}
+
+	// The member is added before the link is used, so that a failed write does not
+	// spend a use. If the link is used up meanwhile, the member is removed again.
+	now := time.Now()
+	member := &Member{
+		EventID:   event.ID,
+		UserID:    p.ID,
+		Status:    MEMBER_JOINED,
+		InvitedBy: link.CreatedBy,
+		Created:   now,
+		Modified:  now,
+	}
+	if err := Members.Put(member, c); err != nil {
+		if err != ErrEventFull {
+			log.Errorf(c, "Failed to add user %v to event %v: %v", p.ID, event.ID, err)
+		}
+		return err
+	}
+
+	if _, err := InviteLinks.Use(linkID, c); err != nil {
+		if err == ErrNoSuchEntity {
+			err = ErrInvalidInvite
+		} else if err != ErrLinkUsedUp {
+			log.Errorf(c, "Failed to use invite link %v: %v", linkID, err)
+		}
+		if err := Members.Delete(event.ID, p.ID, c); err != nil {
+			log.Errorf(c, "Failed to remove user %v from event %v after failing to use invite link %v: %v",
+				p.ID, event.ID, linkID, err)
+		}
+		return err
+	}
+
+	log.Infof(c, "User %v joined event %v with invite link %v.", p.ID, event.ID, linkID)
+	return nil
+}
+
+// InviteErrorStatus returns the HTTP status of an error returned by RedeemInviteLink.
+func InviteErrorStatus(err error) int {
+	switch err {
+	case ErrInvalidInvite, ErrLinkUsedUp, ErrEventFull:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// fetchLinkEvent returns the event named in the request URL and the signed in user,
+// responding with an error unless the user may manage the event's invite links.
+func fetchLinkEvent(w http.ResponseWriter, r *http.Request, c context.Context) (*Event, *Principal, bool) {
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if !Can(p, ACTION_MANAGE_MEMBERS, event) {
+		log.Infof(c, "User %v cannot manage invite links of event %v.", p.ID, event.ID)
+		http.Error(w, "You cannot manage invite links of this event.", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	return event, p, true
+}
+
+// url returns the link to the event page which redeems an invite link.
+func (link *InviteLink) url() string {
+	return "/e/" + link.EventID + "?" + url.Values{"invite": {link.token()}}.Encode()
+}
+
+// token returns the credential of an invite link: its ID and expiry time, and a signature
+// of them and of the event, separated by dots.
+func (link *InviteLink) token() string {
+	expires := strconv.FormatInt(link.Expires.Unix(), 10)
+	return link.ID + "." + expires + "." + signInvite(link.EventID, link.ID, expires)
+}
+
+// parseInviteToken returns the ID of the invite link to an event with token, failing with
+// ErrInvalidInvite if the token is not signed with InviteKey, is for another event, or
+// has expired.
+func parseInviteToken(eventID, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(InviteKey) == 0 || len(parts) != 3 {
+		return "", ErrInvalidInvite
+	}
+
+	linkID, expires, sig := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(sig), []byte(signInvite(eventID, linkID, expires))) {
+		return "", ErrInvalidInvite
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return "", ErrInvalidInvite
+	}
+
+	return linkID, nil
+}
+
+func signInvite(eventID, linkID, expires string) string {
+	mac := hmac.New(sha256.New, InviteKey)
+	mac.Write([]byte("invite\x00" + eventID + "\x00" + linkID + "\x00" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// deleteInviteLinks revokes all invite links to an event.
+func deleteInviteLinks(eventID string, c context.Context) error {
+	links, err := InviteLinks.ByEvent(eventID, c)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err := InviteLinks.Delete(link.ID, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getLinkDSKey(linkID string, c context.Context) (*datastore.Key, error) {
+	if linkID == "" {
+		return nil, errors.New("No linkID provided.")
+	}
+
+	return datastore.NewKey(c, LINK_KIND, linkID, 0, nil), nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+)
+
+func TestInviteLinks(t *testing.T) {
+	defer func(users UserStore, events EventStore, members MemberStore, links InviteLinkStore, key []byte) {
+		Users, Events, Members, InviteLinks, InviteKey = users, events, members, links, key
+	}(Users, Events, Members, InviteLinks, InviteKey)
+	Users, Events, Members, InviteLinks = NewMemoryUserStore(), NewMemoryEventStore(), NewMemoryMemberStore(), NewMemoryInviteLinkStore()
+	InviteKey = nil
+
+	c := context.Background()
+	Users.Create(&AppUser{ID: "creator", Username: "creator"}, c)
+	Events.Put(&Event{ID: "e", Creator: "creator", Private: true}, c)
+	Events.Put(&Event{ID: "other", Creator: "creator", Private: true}, c)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/e/{id}/links", ListInviteLinks).Methods("GET")
+	r.HandleFunc("/a/e/{id}/links", CreateInviteLink).Methods("POST")
+	r.HandleFunc("/a/e/{id}/links/{linkID}", RevokeInviteLink).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/join", JoinEvent).Methods("POST")
+
+	serveAs := func(userID, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	join := func(userID, eventID, token string) int {
+		body, _ := json.Marshal(joinRequest{token})
+		return serveAs(userID, "POST", "/a/e/"+eventID+"/join", string(body)).Code
+	}
+
+	if w := serveAs("creator", "POST", "/a/e/e/links", `{"maxUses": 2}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("CreateInviteLink() without a key returned %v. Wanted %v.", w.Code, http.StatusServiceUnavailable)
+	}
+	InviteKey = []byte("invite key")
+
+	for _, body := range []string{`{"maxUses": 0}`, `{"maxUses": 1, "expires": "2000-01-01T00:00:00Z"}`} {
+		if w := serveAs("creator", "POST", "/a/e/e/links", body); w.Code != http.StatusBadRequest {
+			t.Errorf("CreateInviteLink() with %v returned %v. Wanted %v.", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := serveAs("guest", "POST", "/a/e/e/links", `{"maxUses": 2}`); w.Code != http.StatusForbidden {
+		t.Errorf("CreateInviteLink() by another user returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+
+	w := serveAs("creator", "POST", "/a/e/e/links", `{"maxUses": 2}`)
+	var created InviteLinkResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("CreateInviteLink() returned %v %q.", w.Code, w.Body.String())
+	}
+	link := created.Data
+	if !strings.HasPrefix(link.URL, "/e/e?invite=") {
+		t.Errorf("CreateInviteLink() returned URL %q.", link.URL)
+	}
+	token := link.token()
+
+	if code := join("guest", "other", token); code != http.StatusForbidden {
+		t.Errorf("JoinEvent() of another event returned %v. Wanted %v.", code, http.StatusForbidden)
+	}
+	if code := join("guest", "e", token[:len(token)-1]); code != http.StatusForbidden {
+		t.Errorf("JoinEvent() with a bad signature returned %v. Wanted %v.", code, http.StatusForbidden)
+	}
+	if _, err := parseInviteToken("e", token, link.Expires.Add(time.Second)); err != ErrInvalidInvite {
+		t.Errorf("parseInviteToken() of an expired link returned %v. Wanted ErrInvalidInvite.", err)
+	}
+
+	// A failed join must not use up the link.
+	Members = failingMemberStore{Members}
+	if code := join("guest", "e", token); code != http.StatusInternalServerError {
+		t.Errorf("JoinEvent() with a failing member store returned %v. Wanted %v.", code, http.StatusInternalServerError)
+	}
+	Members = Members.(failingMemberStore).MemberStore
+
+	// The creator and members do not use up the link.
+	for _, userID := range []string{"creator", "guest", "guest", "second"} {
+		if code := join(userID, "e", token); code != http.StatusOK {
+			t.Fatalf("JoinEvent() by %v returned %v.", userID, code)
+		}
+	}
+	if joined, _ := isMember("e", "guest", c); !joined {
+		t.Error("JoinEvent() did not make the user a member.")
+	}
+	if code := join("third", "e", token); code != http.StatusForbidden {
+		t.Errorf("JoinEvent() with a used up link returned %v. Wanted %v.", code, http.StatusForbidden)
+	}
+	if joined, _ := isMember("e", "third", c); joined {
+		t.Error("JoinEvent() with a used up link made the user a member.")
+	}
+
+	var list InviteLinkListResponse
+	json.Unmarshal(serveAs("creator", "GET", "/a/e/e/links", "").Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Uses != 2 || list.Data[0].URL != link.URL {
+		t.Errorf("ListInviteLinks() returned %+v.", list.Data)
+	}
+
+	if w := serveAs("creator", "DELETE", "/a/e/other/links/"+link.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("RevokeInviteLink() under another event returned %v. Wanted %v.", w.Code, http.StatusNotFound)
+	}
+	if w := serveAs("creator", "DELETE", "/a/e/e/links/"+link.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("RevokeInviteLink() returned %v %q.", w.Code, w.Body.String())
+	}
+	Members.Delete("e", "guest", c)
+	if code := join("guest", "e", token); code != http.StatusForbidden {
+		t.Errorf("JoinEvent() with a revoked link returned %v. Wanted %v.", code, http.StatusForbidden)
+	}
+}
+
+// failingMemberStore fails to store members.
+type failingMemberStore struct {
+	MemberStore
+}
+
+func (s failingMemberStore) Put(member *Member, c context.Context) error {
+	return errors.New("storing members failed")
+}
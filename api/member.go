@@ -0,0 +1,475 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const MEMBER_KIND = "eventMember"
+const MEMBER_COUNT_KIND = "eventMemberCount"
+
+// Statuses of a Member. Invited users cannot view a private event until they accept.
+const (
+	MEMBER_INVITED = "invited"
+	MEMBER_JOINED  = "member"
+)
+
+// Most members and pending invites an event may have at once.
+const MAX_MEMBERS = 500
+
+// ErrEventFull is returned when adding a member to an event with MAX_MEMBERS members.
+var ErrEventFull = errors.New("This event has too many members.")
+
+// A memberCount counts the members and invites of an event in Datastore, so that
+// MAX_MEMBERS can be enforced in the transaction adding a member. Counts are keyed by the
+// event's ID.
+type memberCount struct {
+	Count int
+}
+
+// A Member is a user who has joined, or been invited to, an event. Members can view a
+// private event and post to it. An event's creator is not stored as a member.
+type Member struct {
+	EventID   string    `json:"event"`
+	UserID    string    `json:"-"`
+	Status    string    `json:"status"`
+	InvitedBy string    `json:"-"`
+	Created   time.Time `json:"created"`
+	Modified  time.Time `json:"modified"`
+}
+
+// MemberView shows a member of an event by their username.
+type MemberView struct {
+	Username string    `json:"username"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+}
+
+// InviteView shows a pending invite to the invited user.
+type InviteView struct {
+	EventID   string    `json:"event"`
+	EventName string    `json:"eventName"`
+	InvitedBy string    `json:"invitedBy"`
+	Created   time.Time `json:"created"`
+}
+
+type MemberResponse struct {
+	Ok   bool       `json:"ok"`
+	Data MemberView `json:"data"`
+}
+
+type MemberListResponse struct {
+	Ok   bool         `json:"ok"`
+	Data []MemberView `json:"data"`
+}
+
+type InviteListResponse struct {
+	Ok   bool         `json:"ok"`
+	Data []InviteView `json:"data"`
+}
+
+type inviteRequest struct {
+	Username string `json:"username"`
+}
+
+// ListMembers responds with the members of an event, oldest first, to users who can view
+// it. Pending invites are only listed for users who can manage the event's members.
+func ListMembers(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	if err := event.AuthorizeView(p, c); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	members, err := Members.ByEvent(event.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch members of event %v: %v", event.ID, err)
+		http.Error(w, "Failed to fetch members.", http.StatusInternalServerError)
+		return
+	}
+
+	if !Can(p, ACTION_MANAGE_MEMBERS, event) {
+		joined := members[:0]
+		for _, member := range members {
+			if member.Status == MEMBER_JOINED {
+				joined = append(joined, member)
+			}
+		}
+		members = joined
+	}
+
+	views, err := fetchMemberViews(members, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch members.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := MemberListResponse{true, views}
+	sendJsonResponse(w, resp)
+}
+
+// InviteMember invites the user named in the request body to an event. The invite lets
+// them join the event with AcceptInvite.
+func InviteMember(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	if !Can(p, ACTION_MANAGE_MEMBERS, event) {
+		log.Infof(c, "User %v cannot invite members to event %v.", p.ID, event.ID)
+		http.Error(w, "You cannot invite members to this event.", http.StatusForbidden)
+		return
+	}
+
+	req := new(inviteRequest)
+	if err := readEntity(r, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	username := strings.ToLower(req.Username)
+	appUser, err := FetchAppUserByName(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.Error(w, fmt.Sprintf("There is no user named '%v'.", username), http.StatusBadRequest)
+		return
+	}
+
+	if appUser.ID == event.Creator {
+		http.Error(w, "The creator of an event is always a member.", http.StatusBadRequest)
+		return
+	}
+
+	_, err = Members.Get(event.ID, appUser.ID, c)
+	if err == nil {
+		http.Error(w, fmt.Sprintf("'%v' is already a member or invited.", username), http.StatusConflict)
+		return
+	}
+	if err != ErrNoSuchEntity {
+		log.Errorf(c, "Failed to fetch member %v of event %v: %v", appUser.ID, event.ID, err)
+		http.Error(w, "Failed to invite member.", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	member := &Member{
+		EventID:   event.ID,
+		UserID:    appUser.ID,
+		Status:    MEMBER_INVITED,
+		InvitedBy: p.ID,
+		Created:   now,
+		Modified:  now,
+	}
+	err = Members.Put(member, c)
+	if err == ErrEventFull {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to store invite of user %v to event %v: %v", appUser.ID, event.ID, err)
+		http.Error(w, "Failed to invite member.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v invited user %v to event %v.", p.ID, appUser.ID, event.ID)
+
+	resp := MemberResponse{true, MemberView{appUser.Username, member.Status, member.Created}}
+	w.WriteHeader(http.StatusCreated)
+	sendJsonResponse(w, resp)
+}
+
+// AcceptInvite makes the signed in user a member of an event they have been invited to.
+func AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	member, ok := fetchInvite(w, r, c)
+	if !ok {
+		return
+	}
+
+	member.Status = MEMBER_JOINED
+	member.Modified = time.Now()
+	if err := Members.Put(member, c); err != nil {
+		log.Errorf(c, "Failed to accept invite of user %v to event %v: %v", member.UserID, member.EventID, err)
+		http.Error(w, "Failed to accept invite.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v joined event %v.", member.UserID, member.EventID)
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// DeclineInvite deletes the signed in user's invite to an event.
+func DeclineInvite(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	member, ok := fetchInvite(w, r, c)
+	if !ok {
+		return
+	}
+
+	if err := Members.Delete(member.EventID, member.UserID, c); err != nil {
+		log.Errorf(c, "Failed to decline invite of user %v to event %v: %v", member.UserID, member.EventID, err)
+		http.Error(w, "Failed to decline invite.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v declined the invite to event %v.", member.UserID, member.EventID)
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// RemoveMember removes the member or pending invite of the user named in the request URL
+// from an event. Users who manage the event's members can remove anyone, and members can
+// remove themselves.
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	event, p, ok := fetchMemberEvent(w, r, c)
+	if !ok {
+		return
+	}
+
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+	appUser, err := FetchAppUserByName(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if appUser.ID != p.ID && !Can(p, ACTION_MANAGE_MEMBERS, event) {
+		log.Infof(c, "User %v cannot remove members of event %v.", p.ID, event.ID)
+		http.Error(w, "You cannot remove members of this event.", http.StatusForbidden)
+		return
+	}
+
+	_, err = Members.Get(event.ID, appUser.ID, c)
+	if err == ErrNoSuchEntity {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch member %v of event %v: %v", appUser.ID, event.ID, err)
+		http.Error(w, "Failed to remove member.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := Members.Delete(event.ID, appUser.ID, c); err != nil {
+		log.Errorf(c, "Failed to remove member %v of event %v: %v", appUser.ID, event.ID, err)
+		http.Error(w, "Failed to remove member.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v removed user %v from event %v.", p.ID, appUser.ID, event.ID)
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// ListInvites responds with the signed in user's pending invites, oldest first.
+func ListInvites(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := getRequestUser(r)
+	if err != nil {
+		log.Infof(c, "Must be signed in to list invites: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	members, err := Members.ByUser(currentUser.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invites of user %v: %v", currentUser.ID, err)
+		http.Error(w, "Failed to fetch invites.", http.StatusInternalServerError)
+		return
+	}
+
+	var invites []Member
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Status == MEMBER_INVITED {
+			invites = append(invites, member)
+			userIDs = append(userIDs, member.InvitedBy)
+		}
+	}
+
+	usernames, err := FetchUsernames(userIDs, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch invites.", http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]InviteView, 0, len(invites))
+	for _, invite := range invites {
+		// Invites to events which have been deleted are left out.
+		event, err := FetchEvent(invite.EventID, c)
+		if err == ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			log.Errorf(c, "Failed to fetch event %v: %v", invite.EventID, err)
+			http.Error(w, "Failed to fetch invites.", http.StatusInternalServerError)
+			return
+		}
+
+		views = append(views, InviteView{event.ID, event.Name, usernames[invite.InvitedBy], invite.Created})
+	}
+
+	resp := InviteListResponse{true, views}
+	sendJsonResponse(w, resp)
+}
+
+// fetchMemberEvent returns the event named in the request URL and the signed in user,
+// responding with an error if either cannot be found.
+func fetchMemberEvent(w http.ResponseWriter, r *http.Request, c context.Context) (*Event, *Principal, bool) {
+	p, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to manage members: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	eventID := GetRequestVar(r, "id", c)
+	event, err := FetchEvent(eventID, c)
+	if err != nil {
+		log.Infof(c, "Could not fetch event %v: %v", eventID, err)
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	return event, p, true
+}
+
+// fetchInvite returns the signed in user's pending invite to the event named in the
+// request URL, responding with an error if there is none.
+func fetchInvite(w http.ResponseWriter, r *http.Request, c context.Context) (*Member, bool) {
+	currentUser, err := getRequestUser(r)
+	if err != nil {
+		log.Infof(c, "Must be signed in to answer an invite: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return nil, false
+	}
+
+	eventID := GetRequestVar(r, "id", c)
+	member, err := Members.Get(eventID, currentUser.ID, c)
+	if err == ErrNoSuchEntity || (err == nil && member.Status != MEMBER_INVITED) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch invite of user %v to event %v: %v", currentUser.ID, eventID, err)
+		http.Error(w, "Failed to fetch invite.", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return member, true
+}
+
+// fetchMemberViews returns the views of members, looking up all of their usernames at once.
+func fetchMemberViews(members []Member, c context.Context) ([]MemberView, error) {
+	userIDs := make([]string, len(members))
+	for i := range members {
+		userIDs[i] = members[i].UserID
+	}
+
+	usernames, err := FetchUsernames(userIDs, c)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]MemberView, len(members))
+	for i, member := range members {
+		views[i] = MemberView{usernames[member.UserID], member.Status, member.Created}
+	}
+
+	return views, nil
+}
+
+// joinedEvents returns the IDs of the events a user has joined. Pending invites do not count.
+func joinedEvents(userID string, c context.Context) (map[string]bool, error) {
+	members, err := Members.ByUser(userID, c)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := make(map[string]bool, len(members))
+	for _, member := range members {
+		if member.Status == MEMBER_JOINED {
+			joined[member.EventID] = true
+		}
+	}
+
+	return joined, nil
+}
+
+// isMember reports whether a user has joined an event. Pending invites do not count.
+func isMember(eventID, userID string, c context.Context) (bool, error) {
+	member, err := Members.Get(eventID, userID, c)
+	if err == ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return member.Status == MEMBER_JOINED, nil
+}
+
+// deleteEventMembers removes all members of and invites to an event.
+func deleteEventMembers(eventID string, c context.Context) error {
+	members, err := Members.ByEvent(eventID, c)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if err := Members.Delete(eventID, member.UserID, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteMemberships removes a user from all events they have joined or been invited to.
+func deleteMemberships(userID string, c context.Context) error {
+	members, err := Members.ByUser(userID, c)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if err := Members.Delete(member.EventID, userID, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getMemberDSKey(eventID, userID string, c context.Context) (*datastore.Key, error) {
+	if eventID == "" || userID == "" {
+		return nil, errors.New("No eventID or userID provided.")
+	}
+
+	return datastore.NewKey(c, MEMBER_KIND, eventID+"/"+userID, 0, nil), nil
+}
+
+func memberCountDSKey(eventID string, c context.Context) *datastore.Key {
+	return datastore.NewKey(c, MEMBER_COUNT_KIND, eventID, 0, nil)
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+)
+
+func TestMembers(t *testing.T) {
+	defer func(users UserStore, events EventStore, posts PostStore, members MemberStore) {
+		Users, Events, Posts, Members = users, events, posts, members
+	}(Users, Events, Posts, Members)
+	Users, Events, Posts, Members = NewMemoryUserStore(), NewMemoryEventStore(), NewMemoryPostStore(), NewMemoryMemberStore()
+
+	c := context.Background()
+	Users.Create(&AppUser{ID: "creator", Username: "creator"}, c)
+	Users.Create(&AppUser{ID: "guest", Username: "guest"}, c)
+	Users.Create(&AppUser{ID: "other", Username: "other"}, c)
+	now := time.Now()
+	Events.Put(&Event{ID: "e", Name: "Party", Creator: "creator", Private: true, Start: now, End: now.Add(time.Hour), Created: now}, c)
+	Events.Put(&Event{ID: "public", Name: "Picnic", Creator: "creator", Created: now.Add(-time.Hour)}, c)
+	Posts.Put(&Post{ID: "p", UserID: "creator", EventID: "e", Created: now}, c)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/p/{id}", GetPost).Methods("GET")
+	r.HandleFunc("/a/e/{id}", GetEvent).Methods("GET")
+	r.HandleFunc("/a/e/{id}/posts", EventPosts).Methods("GET")
+	r.HandleFunc("/a/e/{id}/members", ListMembers).Methods("GET")
+	r.HandleFunc("/a/e/{id}/members", InviteMember).Methods("POST")
+	r.HandleFunc("/a/e/{id}/members/{username}", RemoveMember).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/invite/accept", AcceptInvite).Methods("POST")
+	r.HandleFunc("/a/e/{id}/invite/decline", DeclineInvite).Methods("POST")
+	r.HandleFunc("/a/invites", ListInvites).Methods("GET")
+	r.HandleFunc("/a/feed/e", EventsFeed).Methods("GET")
+
+	serveAs := func(userID, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	canRead := func(userID string) bool {
+		allowed := 0
+		for _, url := range []string{"/a/e/e", "/a/e/e/posts", "/a/p/p", "/a/e/e/members"} {
+			if w := serveAs(userID, "GET", url, ""); w.Code == http.StatusOK {
+				allowed++
+			} else if w.Code != http.StatusForbidden {
+				t.Errorf("GET %v as %v returned %v %q.", url, userID, w.Code, w.Body.String())
+			}
+		}
+		if allowed != 0 && allowed != 4 {
+			t.Errorf("User %v can read %v of the 4 views of a private event.", userID, allowed)
+		}
+
+		var feed FeedEventPage
+		json.Unmarshal(serveAs(userID, "GET", "/a/feed/e", "").Body.Bytes(), &feed)
+		if inFeed := len(feed.Items) == 2; inFeed != (allowed != 0) {
+			t.Errorf("The feed of user %v holds %v events, while they can read %v views of the private event.", userID, len(feed.Items), allowed)
+		}
+
+		return allowed != 0
+	}
+
+	if !canRead("creator") {
+		t.Error("The creator cannot read their private event.")
+	}
+	if canRead("guest") {
+		t.Error("A user who is not invited can read a private event.")
+	}
+
+	if w := serveAs("other", "POST", "/a/e/e/members", `{"username": "guest"}`); w.Code != http.StatusForbidden {
+		t.Errorf("InviteMember() by another user returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+	if w := serveAs("creator", "POST", "/a/e/e/members", `{"username": "Guest"}`); w.Code != http.StatusCreated {
+		t.Fatalf("InviteMember() returned %v %q.", w.Code, w.Body.String())
+	}
+	if w := serveAs("creator", "POST", "/a/e/e/members", `{"username": "guest"}`); w.Code != http.StatusConflict {
+		t.Errorf("InviteMember() of an invited user returned %v. Wanted %v.", w.Code, http.StatusConflict)
+	}
+	if canRead("guest") {
+		t.Error("An invited user can read a private event before accepting.")
+	}
+
+	var invites InviteListResponse
+	json.Unmarshal(serveAs("guest", "GET", "/a/invites", "").Body.Bytes(), &invites)
+	if len(invites.Data) != 1 || invites.Data[0].EventName != "Party" || invites.Data[0].InvitedBy != "creator" {
+		t.Errorf("ListInvites() returned %+v.", invites.Data)
+	}
+
+	if w := serveAs("other", "POST", "/a/e/e/invite/accept", ""); w.Code != http.StatusNotFound {
+		t.Errorf("AcceptInvite() without an invite returned %v. Wanted %v.", w.Code, http.StatusNotFound)
+	}
+	if w := serveAs("guest", "POST", "/a/e/e/invite/accept", ""); w.Code != http.StatusOK {
+		t.Fatalf("AcceptInvite() returned %v %q.", w.Code, w.Body.String())
+	}
+	if !canRead("guest") {
+		t.Error("A member cannot read a private event.")
+	}
+
+	// Members see each other, while only the creator sees pending invites.
+	serveAs("creator", "POST", "/a/e/e/members", `{"username": "other"}`)
+	var list MemberListResponse
+	json.Unmarshal(serveAs("guest", "GET", "/a/e/e/members", "").Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Username != "guest" {
+		t.Errorf("ListMembers() by a member returned %+v.", list.Data)
+	}
+	json.Unmarshal(serveAs("creator", "GET", "/a/e/e/members", "").Body.Bytes(), &list)
+	if len(list.Data) != 2 || list.Data[1].Status != MEMBER_INVITED {
+		t.Errorf("ListMembers() by the creator returned %+v.", list.Data)
+	}
+
+	if w := serveAs("other", "POST", "/a/e/e/invite/decline", ""); w.Code != http.StatusOK {
+		t.Errorf("DeclineInvite() returned %v %q.", w.Code, w.Body.String())
+	}
+	if _, err := Members.Get("e", "other", c); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a declined invite returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	if w := serveAs("other", "DELETE", "/a/e/e/members/guest", ""); w.Code != http.StatusForbidden {
+		t.Errorf("RemoveMember() by another user returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+	if w := serveAs("creator", "DELETE", "/a/e/e/members/guest", ""); w.Code != http.StatusOK {
+		t.Errorf("RemoveMember() returned %v %q.", w.Code, w.Body.String())
+	}
+	if canRead("guest") {
+		t.Error("A removed member can read a private event.")
+	}
+}
+
+// countingMemberStore counts lookups of single memberships.
+type countingMemberStore struct {
+	*MemoryMemberStore
+	gets int
+}
+
+func (s *countingMemberStore) Get(eventID, userID string, c context.Context) (*Member, error) {
+	s.gets++
+	return s.MemoryMemberStore.Get(eventID, userID, c)
+}
+
+func TestEventsFeedPrivate(t *testing.T) {
+	defer func(users UserStore, events EventStore, members MemberStore) {
+		Users, Events, Members = users, events, members
+	}(Users, Events, Members)
+	members := &countingMemberStore{MemoryMemberStore: NewMemoryMemberStore()}
+	Users, Events, Members = NewMemoryUserStore(), NewMemoryEventStore(), members
+
+	c := context.Background()
+	Users.Create(&AppUser{ID: "creator", Username: "creator"}, c)
+	Users.Create(&AppUser{ID: "guest", Username: "guest"}, c)
+	now := time.Now()
+	// Newest first, the feed holds a public event followed by 3 private ones, of which
+	// the guest has joined the last, and then 2 more public events.
+	for i, private := range []bool{false, true, true, true, false, false} {
+		id := string('a' + rune(i))
+		Events.Put(&Event{ID: id, Name: id, Creator: "creator", Private: private, Created: now.Add(-time.Duration(i) * time.Minute)}, c)
+	}
+	Members.Put(&Member{EventID: "d", UserID: "guest", Status: MEMBER_JOINED}, c)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/feed/e", EventsFeed).Methods("GET")
+
+	feedOf := func(userID string) []string {
+		var ids []string
+		cursor := ""
+		for pages := 0; pages < 10; pages++ {
+			req := httptest.NewRequest("GET", "/a/feed/e?limit=2&cursor="+cursor, nil)
+			if userID != "" {
+				req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var feed FeedEventPage
+			json.Unmarshal(w.Body.Bytes(), &feed)
+			if feed.NextCursor != "" && len(feed.Items) != 2 {
+				t.Errorf("Page %v of the feed of %q holds %v events and is followed by more.", pages, userID, len(feed.Items))
+			}
+			for _, event := range feed.Items {
+				ids = append(ids, event.ID)
+			}
+			if cursor = feed.NextCursor; cursor == "" {
+				break
+			}
+		}
+		return ids
+	}
+
+	if ids := strings.Join(feedOf(""), ","); ids != "a,e,f" {
+		t.Errorf("The feed of a signed out user holds %v. Wanted a,e,f.", ids)
+	}
+	if ids := strings.Join(feedOf("guest"), ","); ids != "a,d,e,f" {
+		t.Errorf("The feed of a member holds %v. Wanted a,d,e,f.", ids)
+	}
+	if ids := strings.Join(feedOf("creator"), ","); ids != "a,b,c,d,e,f" {
+		t.Errorf("The feed of the creator holds %v.", ids)
+	}
+	if members.gets != 0 {
+		t.Errorf("The feed looked up %v single memberships. Wanted none.", members.gets)
+	}
+}
@@ -0,0 +1,882 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The stores in this file keep entities in maps guarded by a mutex. They are meant for
+// tests and local development.
+
+// MemoryUserStore keeps AppUsers in memory.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]AppUser
+}
+
+// MemoryEventStore keeps Events in memory.
+type MemoryEventStore struct {
+	mu     sync.RWMutex
+	events map[string]Event
+}
+
+// MemoryPostStore keeps Posts in memory.
+type MemoryPostStore struct {
+	mu    sync.RWMutex
+	posts map[string]Post
+}
+
+// MemoryJobStore keeps Jobs in memory.
+type MemoryJobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]Job
+}
+
+// MemoryDeadLetterStore keeps DeadLetters in memory.
+type MemoryDeadLetterStore struct {
+	mu          sync.RWMutex
+	deadLetters map[string]DeadLetter
+}
+
+// MemoryTrashStore keeps TrashItems in memory.
+type MemoryTrashStore struct {
+	mu    sync.RWMutex
+	items map[string]TrashItem
+}
+
+// MemoryTokenStore keeps AccessTokens in memory.
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]AccessToken
+}
+
+// MemoryMemberStore keeps Members in memory.
+type MemoryMemberStore struct {
+	mu      sync.RWMutex
+	members map[string]Member
+}
+
+// MemoryInviteLinkStore keeps InviteLinks in memory.
+type MemoryInviteLinkStore struct {
+	mu    sync.RWMutex
+	links map[string]InviteLink
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]AppUser)}
+}
+
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{events: make(map[string]Event)}
+}
+
+func NewMemoryPostStore() *MemoryPostStore {
+	return &MemoryPostStore{posts: make(map[string]Post)}
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: make(map[string]Job)}
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{deadLetters: make(map[string]DeadLetter)}
+}
+
+func (s *MemoryUserStore) Get(userID string, c context.Context) (*AppUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	appUser, ok := s.users[userID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &appUser, nil
+}
+
+func (s *MemoryUserStore) GetMulti(userIDs []string, c context.Context) (map[string]*AppUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := make(map[string]*AppUser, len(userIDs))
+	for _, userID := range userIDs {
+		if appUser, ok := s.users[userID]; ok {
+			found[userID] = &appUser
+		}
+	}
+
+	return found, nil
+}
+
+func (s *MemoryUserStore) GetByName(username string, c context.Context) (*AppUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, appUser := range s.users {
+		if appUser.Username == username {
+			return &appUser, nil
+		}
+	}
+
+	return nil, ErrNoSuchEntity
+}
+
+func (s *MemoryUserStore) Create(appUser *AppUser, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[appUser.ID]; ok {
+		return ErrUserExists
+	}
+	if s.usernameTaken(appUser) {
+		return ErrUsernameTaken
+	}
+
+	s.users[appUser.ID] = *appUser
+	return nil
+}
+
+func (s *MemoryUserStore) Update(appUser *AppUser, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[appUser.ID]; !ok {
+		return ErrNoSuchEntity
+	}
+	if s.usernameTaken(appUser) {
+		return ErrUsernameTaken
+	}
+
+	s.users[appUser.ID] = *appUser
+	return nil
+}
+
+func (s *MemoryUserStore) All(req PageRequest, c context.Context) (*UserPage, error) {
+	s.mu.RLock()
+	users := make([]AppUser, 0, len(s.users))
+	for _, appUser := range s.users {
+		users = append(users, appUser)
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(&userSorter{users, req.Order})
+
+	if req.Cursor != "" {
+		created, id, err := DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		last := &AppUser{ID: id, Created: created}
+		users = users[sort.Search(len(users), func(i int) bool {
+			return userLess(last, &users[i], req.Order)
+		}):]
+	}
+
+	page := &UserPage{Items: users}
+	if len(users) > req.Limit {
+		page.Items = users[:req.Limit]
+		page.NextCursor = UserCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+// usernameTaken reports whether another user has appUser's username. The caller must
+// hold s.mu.
+func (s *MemoryUserStore) usernameTaken(appUser *AppUser) bool {
+	for _, other := range s.users {
+		if other.ID != appUser.ID && sameUsername(other.Username, appUser.Username) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *MemoryUserStore) Delete(userID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, userID)
+	return nil
+}
+
+func (s *MemoryEventStore) Get(eventID string, c context.Context) (*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.events[eventID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &event, nil
+}
+
+func (s *MemoryEventStore) Put(event *Event, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[event.ID] = *event
+	return nil
+}
+
+func (s *MemoryEventStore) Update(eventID string, update func(*Event) error, c context.Context) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[eventID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	// The slice is copied so that a failed update cannot change the stored event.
+	event.CoHosts = append([]string(nil), event.CoHosts...)
+	if err := update(&event); err != nil {
+		return nil, err
+	}
+
+	s.events[eventID] = event
+	return &event, nil
+}
+
+func (s *MemoryEventStore) Delete(eventID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.events, eventID)
+	return nil
+}
+
+func (s *MemoryEventStore) Feed(order string, page int, c context.Context) ([]Event, error) {
+	s.mu.RLock()
+	events := make([]Event, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, event)
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(&eventSorter{events, order})
+
+	return pageOf(events, page), nil
+}
+
+func (s *MemoryEventStore) FeedPage(req PageRequest, c context.Context) (*EventPage, error) {
+	return s.filter(req, func(event *Event) bool {
+		return true
+	})
+}
+
+func (s *MemoryEventStore) ByCreator(creatorID string, req PageRequest, c context.Context) (*EventPage, error) {
+	return s.filter(req, func(event *Event) bool {
+		return event.Creator == creatorID
+	})
+}
+
+// filter returns a page of matching events.
+func (s *MemoryEventStore) filter(req PageRequest, match func(*Event) bool) (*EventPage, error) {
+	s.mu.RLock()
+	events := make([]Event, 0, len(s.events))
+	for _, event := range s.events {
+		if match(&event) {
+			events = append(events, event)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(&eventSorter{events, req.Order})
+
+	if req.Cursor != "" {
+		value, id, err := DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		// A stand-in for the last event of the previous page, which may have been deleted.
+		last := &Event{ID: id, Created: value, End: value}
+		events = events[sort.Search(len(events), func(i int) bool {
+			return eventLess(last, &events[i], req.Order)
+		}):]
+	}
+
+	page := &EventPage{Items: events}
+	if len(events) > req.Limit {
+		page.Items = events[:req.Limit]
+		page.NextCursor = EventCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+func (s *MemoryPostStore) Get(postID string, c context.Context) (*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	post, ok := s.posts[postID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &post, nil
+}
+
+func (s *MemoryPostStore) Put(post *Post, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.posts[post.ID] = *post
+	return nil
+}
+
+func (s *MemoryPostStore) Delete(postID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.posts, postID)
+	return nil
+}
+
+func (s *MemoryPostStore) ByUser(userID string, req PageRequest, c context.Context) (*PostPage, error) {
+	return s.filter(req, func(post *Post) bool {
+		return post.UserID == userID
+	})
+}
+
+func (s *MemoryPostStore) ByEvent(eventID string, req PageRequest, c context.Context) (*PostPage, error) {
+	return s.filter(req, func(post *Post) bool {
+		return post.EventID == eventID
+	})
+}
+
+func (s *MemoryPostStore) Imageless(before time.Time, req PageRequest, c context.Context) (*PostPage, error) {
+	return s.filter(req, func(post *Post) bool {
+		return post.Image == "" && post.Created.Before(before)
+	})
+}
+
+func (s *MemoryPostStore) All(req PageRequest, c context.Context) (*PostPage, error) {
+	return s.filter(req, func(post *Post) bool {
+		return true
+	})
+}
+
+// filter returns a page of matching posts.
+func (s *MemoryPostStore) filter(req PageRequest, match func(*Post) bool) (*PostPage, error) {
+	s.mu.RLock()
+	posts := make([]Post, 0, PAGE_SIZE)
+	for _, post := range s.posts {
+		if match(&post) {
+			posts = append(posts, post)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(&postSorter{posts, req.Order})
+
+	if req.Cursor != "" {
+		created, id, err := DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		last := &Post{ID: id, Created: created}
+		posts = posts[sort.Search(len(posts), func(i int) bool {
+			return postLess(last, &posts[i], req.Order)
+		}):]
+	}
+
+	page := &PostPage{Items: posts}
+	if len(posts) > req.Limit {
+		page.Items = posts[:req.Limit]
+		page.NextCursor = PostCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+func (s *MemoryJobStore) Get(jobID string, c context.Context) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &job, nil
+}
+
+func (s *MemoryJobStore) Put(job *Job, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) Get(deadLetterID string, c context.Context) (*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deadLetter, ok := s.deadLetters[deadLetterID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &deadLetter, nil
+}
+
+func (s *MemoryDeadLetterStore) Put(deadLetter *DeadLetter, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters[deadLetter.ID] = *deadLetter
+	return nil
+}
+
+func NewMemoryTrashStore() *MemoryTrashStore {
+	return &MemoryTrashStore{items: make(map[string]TrashItem)}
+}
+
+func (s *MemoryTrashStore) Get(kind, id string, c context.Context) (*TrashItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[kind+":"+id]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &item, nil
+}
+
+func (s *MemoryTrashStore) Put(item *TrashItem, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[item.Key()] = *item
+	return nil
+}
+
+func (s *MemoryTrashStore) Delete(kind, id string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, kind+":"+id)
+	return nil
+}
+
+func (s *MemoryTrashStore) ByOwner(ownerID string, req PageRequest, c context.Context) (*TrashPage, error) {
+	return s.filter(req, func(item *TrashItem) bool {
+		return item.OwnerID == ownerID
+	})
+}
+
+func (s *MemoryTrashStore) ByParent(parentID string, req PageRequest, c context.Context) (*TrashPage, error) {
+	return s.filter(req, func(item *TrashItem) bool {
+		return item.ParentID == parentID
+	})
+}
+
+func (s *MemoryTrashStore) Expired(before time.Time, req PageRequest, c context.Context) (*TrashPage, error) {
+	return s.filter(req, func(item *TrashItem) bool {
+		return item.Trashed.Before(before)
+	})
+}
+
+// filter returns a page of matching items.
+func (s *MemoryTrashStore) filter(req PageRequest, match func(*TrashItem) bool) (*TrashPage, error) {
+	s.mu.RLock()
+	items := make([]TrashItem, 0, PAGE_SIZE)
+	for _, item := range s.items {
+		if match(&item) {
+			items = append(items, item)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(&trashSorter{items, req.Order})
+
+	if req.Cursor != "" {
+		trashed, key, err := DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		last := &TrashItem{Trashed: trashed}
+		last.Kind, last.ID = splitTrashKey(key)
+		items = items[sort.Search(len(items), func(i int) bool {
+			return trashLess(last, &items[i], req.Order)
+		}):]
+	}
+
+	page := &TrashPage{Items: items}
+	if len(items) > req.Limit {
+		page.Items = items[:req.Limit]
+		page.NextCursor = TrashCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]AccessToken)}
+}
+
+func (s *MemoryTokenStore) Get(tokenID string, c context.Context) (*AccessToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[tokenID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) Put(token *AccessToken, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = *token
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(tokenID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, tokenID)
+	return nil
+}
+
+func (s *MemoryTokenStore) Touch(tokenID string, lastUsed time.Time, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenID]
+	if !ok {
+		return ErrNoSuchEntity
+	}
+
+	token.LastUsed = lastUsed
+	s.tokens[tokenID] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) ByUser(userID string, c context.Context) ([]AccessToken, error) {
+	s.mu.RLock()
+	tokens := make([]AccessToken, 0)
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(tokensByCreated(tokens))
+	return tokens, nil
+}
+
+// tokensByCreated sorts tokens newest first.
+type tokensByCreated []AccessToken
+
+func (t tokensByCreated) Len() int      { return len(t) }
+func (t tokensByCreated) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+
+func (t tokensByCreated) Less(i, j int) bool {
+	if t[i].Created.Equal(t[j].Created) {
+		return t[i].ID > t[j].ID
+	}
+	return t[i].Created.After(t[j].Created)
+}
+
+func NewMemoryMemberStore() *MemoryMemberStore {
+	return &MemoryMemberStore{members: make(map[string]Member)}
+}
+
+func (s *MemoryMemberStore) Get(eventID, userID string, c context.Context) (*Member, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	member, ok := s.members[eventID+"/"+userID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &member, nil
+}
+
+func (s *MemoryMemberStore) Put(member *Member, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := member.EventID + "/" + member.UserID
+	if _, ok := s.members[key]; !ok {
+		count := 0
+		for _, other := range s.members {
+			if other.EventID == member.EventID {
+				count++
+			}
+		}
+		if count >= MAX_MEMBERS {
+			return ErrEventFull
+		}
+	}
+
+	s.members[key] = *member
+	return nil
+}
+
+func (s *MemoryMemberStore) Delete(eventID, userID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.members, eventID+"/"+userID)
+	return nil
+}
+
+func (s *MemoryMemberStore) ByEvent(eventID string, c context.Context) ([]Member, error) {
+	return s.filter(func(member *Member) bool { return member.EventID == eventID }), nil
+}
+
+func (s *MemoryMemberStore) ByUser(userID string, c context.Context) ([]Member, error) {
+	return s.filter(func(member *Member) bool { return member.UserID == userID }), nil
+}
+
+// filter returns the members matching keep, oldest first.
+func (s *MemoryMemberStore) filter(keep func(*Member) bool) []Member {
+	s.mu.RLock()
+	members := make([]Member, 0)
+	for _, member := range s.members {
+		if keep(&member) {
+			members = append(members, member)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(membersByCreated(members))
+	return members
+}
+
+// membersByCreated sorts members oldest first.
+type membersByCreated []Member
+
+func (m membersByCreated) Len() int      { return len(m) }
+func (m membersByCreated) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m membersByCreated) Less(i, j int) bool {
+	if m[i].Created.Equal(m[j].Created) {
+		return m[i].EventID+"/"+m[i].UserID < m[j].EventID+"/"+m[j].UserID
+	}
+	return m[i].Created.Before(m[j].Created)
+}
+
+func NewMemoryInviteLinkStore() *MemoryInviteLinkStore {
+	return &MemoryInviteLinkStore{links: make(map[string]InviteLink)}
+}
+
+func (s *MemoryInviteLinkStore) Get(linkID string, c context.Context) (*InviteLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.links[linkID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+
+	return &link, nil
+}
+
+func (s *MemoryInviteLinkStore) Put(link *InviteLink, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[link.ID] = *link
+	return nil
+}
+
+func (s *MemoryInviteLinkStore) Delete(linkID string, c context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.links, linkID)
+	return nil
+}
+
+func (s *MemoryInviteLinkStore) Use(linkID string, c context.Context) (*InviteLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[linkID]
+	if !ok {
+		return nil, ErrNoSuchEntity
+	}
+	if link.Uses >= link.MaxUses {
+		return nil, ErrLinkUsedUp
+	}
+
+	link.Uses++
+	s.links[linkID] = link
+	return &link, nil
+}
+
+func (s *MemoryInviteLinkStore) ByEvent(eventID string, c context.Context) ([]InviteLink, error) {
+	s.mu.RLock()
+	links := make([]InviteLink, 0)
+	for _, link := range s.links {
+		if link.EventID == eventID {
+			links = append(links, link)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(linksByCreated(links))
+	return links, nil
+}
+
+// linksByCreated sorts invite links newest first.
+type linksByCreated []InviteLink
+
+func (l linksByCreated) Len() int      { return len(l) }
+func (l linksByCreated) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l linksByCreated) Less(i, j int) bool {
+	if l[i].Created.Equal(l[j].Created) {
+		return l[i].ID > l[j].ID
+	}
+	return l[i].Created.After(l[j].Created)
+}
+
+// splitTrashKey splits a TrashItem.Key into its Kind and ID.
+func splitTrashKey(key string) (string, string) {
+	i := strings.Index(key, ":")
+	if i < 0 {
+		return "", key
+	}
+
+	return key[:i], key[i+1:]
+}
+
+// eventSorter sorts events by a feed order such as "-Created" or "End".
+type eventSorter struct {
+	events []Event
+	order  string
+}
+
+func (s *eventSorter) Len() int      { return len(s.events) }
+func (s *eventSorter) Swap(i, j int) { s.events[i], s.events[j] = s.events[j], s.events[i] }
+
+func (s *eventSorter) Less(i, j int) bool {
+	return eventLess(&s.events[i], &s.events[j], s.order)
+}
+
+// eventLess reports whether a comes before b in a feed sorted by order. Events with equal
+// sort values are sorted by ID, in the same direction.
+func eventLess(a, b *Event, order string) bool {
+	if strings.HasPrefix(order, "-") {
+		a, b = b, a
+	}
+
+	av, bv := feedValue(a, order), feedValue(b, order)
+	if av.Equal(bv) {
+		return a.ID < b.ID
+	}
+
+	return av.Before(bv)
+}
+
+// userSorter sorts users by "Created" or "-Created".
+type userSorter struct {
+	users []AppUser
+	order string
+}
+
+func (s *userSorter) Len() int      { return len(s.users) }
+func (s *userSorter) Swap(i, j int) { s.users[i], s.users[j] = s.users[j], s.users[i] }
+
+func (s *userSorter) Less(i, j int) bool {
+	return userLess(&s.users[i], &s.users[j], s.order)
+}
+
+func userLess(a, b *AppUser, order string) bool {
+	if strings.HasPrefix(order, "-") {
+		a, b = b, a
+	}
+
+	if a.Created.Equal(b.Created) {
+		return a.ID < b.ID
+	}
+
+	return a.Created.Before(b.Created)
+}
+
+// postSorter sorts posts by "Created" or "-Created".
+type postSorter struct {
+	posts []Post
+	order string
+}
+
+func (s *postSorter) Len() int      { return len(s.posts) }
+func (s *postSorter) Swap(i, j int) { s.posts[i], s.posts[j] = s.posts[j], s.posts[i] }
+
+func (s *postSorter) Less(i, j int) bool {
+	return postLess(&s.posts[i], &s.posts[j], s.order)
+}
+
+func postLess(a, b *Post, order string) bool {
+	if strings.HasPrefix(order, "-") {
+		a, b = b, a
+	}
+
+	if a.Created.Equal(b.Created) {
+		return a.ID < b.ID
+	}
+
+	return a.Created.Before(b.Created)
+}
+
+// trashSorter sorts trashed items by "Trashed" or "-Trashed".
+type trashSorter struct {
+	items []TrashItem
+	order string
+}
+
+func (s *trashSorter) Len() int      { return len(s.items) }
+func (s *trashSorter) Swap(i, j int) { s.items[i], s.items[j] = s.items[j], s.items[i] }
+
+func (s *trashSorter) Less(i, j int) bool {
+	return trashLess(&s.items[i], &s.items[j], s.order)
+}
+
+func trashLess(a, b *TrashItem, order string) bool {
+	if strings.HasPrefix(order, "-") {
+		a, b = b, a
+	}
+
+	if a.Trashed.Equal(b.Trashed) {
+		return a.Key() < b.Key()
+	}
+
+	return a.Trashed.Before(b.Trashed)
+}
+
+// pageOf slices page number page out of a sorted list of events.
+func pageOf(events []Event, page int) []Event {
+	start := page * PAGE_SIZE
+	if page < 0 || start >= len(events) {
+		return []Event{}
+	}
+
+	end := start + PAGE_SIZE
+	if end > len(events) {
+		end = len(events)
+	}
+
+	return events[start:end]
+}
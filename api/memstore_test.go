@@ -0,0 +1,228 @@
+package api
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	s := NewMemoryUserStore()
+
+	if _, err := s.Get("123", nil); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a missing user returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	appUser := &AppUser{ID: "123", Username: "someone"}
+	if err := s.Create(appUser, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored users must not share memory with the caller
+	appUser.Username = "changed"
+
+	got, err := s.GetByName("someone", nil)
+	if err != nil {
+		t.Fatalf("GetByName(\"someone\") failed: %v", err)
+	}
+	if got.ID != "123" {
+		t.Errorf("GetByName(\"someone\") returned user %v. Wanted 123.", got.ID)
+	}
+
+	if err := s.Create(&AppUser{ID: "123", Username: "other"}, nil); err != ErrUserExists {
+		t.Errorf("Create() of an existing user returned %v. Wanted ErrUserExists.", err)
+	}
+	if err := s.Create(&AppUser{ID: "456", Username: "Someone"}, nil); err != ErrUsernameTaken {
+		t.Errorf("Create() with a taken username returned %v. Wanted ErrUsernameTaken.", err)
+	}
+
+	s.Create(&AppUser{ID: "456", Username: "another"}, nil)
+	if err := s.Update(&AppUser{ID: "456", Username: "someone"}, nil); err != ErrUsernameTaken {
+		t.Errorf("Update() to a taken username returned %v. Wanted ErrUsernameTaken.", err)
+	}
+	if err := s.Update(&AppUser{ID: "123", Username: "renamed"}, nil); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	if err := s.Update(&AppUser{ID: "456", Username: "someone"}, nil); err != nil {
+		t.Errorf("Update() to a released username failed: %v", err)
+	}
+
+	if err := s.Delete("123", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("123", nil); err != ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted user returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestMemoryEventStoreFeed(t *testing.T) {
+	s := NewMemoryEventStore()
+	now := time.Now()
+
+	for i := 0; i < PAGE_SIZE+5; i++ {
+		s.Put(&Event{
+			ID:      strconv.Itoa(i),
+			Created: now.Add(time.Duration(i) * time.Minute),
+			End:     now.Add(-time.Duration(i) * time.Minute),
+		}, nil)
+	}
+
+	feedTests := []struct {
+		order  string
+		page   int
+		length int
+		first  string
+	}{
+		{"-Created", 0, PAGE_SIZE, "24"},
+		{"-Created", 1, 5, "4"},
+		{"-Created", 2, 0, ""},
+		{"Created", 0, PAGE_SIZE, "0"},
+		{"End", 0, PAGE_SIZE, "24"},
+		{"-End", 1, 5, "20"},
+	}
+
+	for _, test := range feedTests {
+		events, err := s.Feed(test.order, test.page, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(events) != test.length {
+			t.Errorf("Feed(%v, %v) returned %v events. Wanted %v.", test.order, test.page, len(events), test.length)
+			continue
+		}
+
+		if test.length > 0 && events[0].ID != test.first {
+			t.Errorf("Feed(%v, %v) started with event %v. Wanted %v.", test.order, test.page, events[0].ID, test.first)
+		}
+	}
+}
+
+func TestMemoryEventStoreFeedPage(t *testing.T) {
+	s := NewMemoryEventStore()
+	now := time.Now()
+
+	// Pairs of events share a creation time, so pages must break ties by ID.
+	for i := 0; i < 25; i++ {
+		s.Put(&Event{ID: strconv.Itoa(100 + i), Created: now.Add(time.Duration(i/2) * time.Minute)}, nil)
+	}
+
+	seen := make(map[string]bool)
+	req := PageRequest{Order: "-Created", Limit: 10}
+	for pages := 1; ; pages++ {
+		page, err := s.FeedPage(req, nil)
+		if err != nil {
+			t.Fatalf("FeedPage() failed on page %v: %v", pages, err)
+		}
+
+		for _, event := range page.Items {
+			if seen[event.ID] {
+				t.Errorf("FeedPage() returned event %v twice.", event.ID)
+			}
+			seen[event.ID] = true
+		}
+
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("FeedPage() returned %v pages. Wanted 3.", pages)
+			}
+			break
+		}
+
+		// Newer events added while paging must not shift the following pages.
+		s.Put(&Event{ID: "new" + strconv.Itoa(pages), Created: now.Add(time.Hour)}, nil)
+		req.Cursor = page.NextCursor
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("FeedPage() returned %v distinct events. Wanted 25.", len(seen))
+	}
+
+	if _, err := s.FeedPage(PageRequest{Order: "End", Cursor: req.Cursor, Limit: 10}, nil); err != ErrInvalidCursor {
+		t.Errorf("FeedPage() with a cursor for another order returned %v. Wanted ErrInvalidCursor.", err)
+	}
+}
+
+func TestMemoryEventStoreUpdate(t *testing.T) {
+	s := NewMemoryEventStore()
+	s.Put(&Event{ID: "e", Name: "Party", CoHosts: []string{"a"}}, nil)
+
+	addCoHost := func(event *Event) error {
+		event.CoHosts = append(event.CoHosts, "b")
+		return nil
+	}
+	if event, err := s.Update("e", addCoHost, nil); err != nil || len(event.CoHosts) != 2 {
+		t.Fatalf("Update() returned %+v, %v.", event, err)
+	}
+
+	// A failed update must leave the stored event as it was.
+	_, err := s.Update("e", func(event *Event) error {
+		event.CoHosts[0] = "c"
+		event.Name = "Changed"
+		return ErrNotCoHost
+	}, nil)
+	if err != ErrNotCoHost {
+		t.Errorf("Update() returned %v. Wanted ErrNotCoHost.", err)
+	}
+	if event, _ := s.Get("e", nil); event.Name != "Party" || event.CoHosts[0] != "a" || event.CoHosts[1] != "b" {
+		t.Errorf("Get() after a failed Update() returned %+v.", event)
+	}
+
+	if _, err := s.Update("missing", addCoHost, nil); err != ErrNoSuchEntity {
+		t.Errorf("Update() of a missing event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestMemoryMemberStoreFull(t *testing.T) {
+	s := NewMemoryMemberStore()
+	for i := 0; i < MAX_MEMBERS; i++ {
+		s.Put(&Member{EventID: "e", UserID: strconv.Itoa(i)}, nil)
+	}
+
+	if err := s.Put(&Member{EventID: "e", UserID: "late"}, nil); err != ErrEventFull {
+		t.Errorf("Put() of a new member of a full event returned %v. Wanted ErrEventFull.", err)
+	}
+	if err := s.Put(&Member{EventID: "e", UserID: "0", Status: MEMBER_JOINED}, nil); err != nil {
+		t.Errorf("Put() of an existing member of a full event failed: %v", err)
+	}
+	if err := s.Put(&Member{EventID: "other", UserID: "late"}, nil); err != nil {
+		t.Errorf("Put() of a member of another event failed: %v", err)
+	}
+}
+
+func TestMemoryPostStore(t *testing.T) {
+	s := NewMemoryPostStore()
+	now := time.Now()
+
+	for i := 0; i < PAGE_SIZE+1; i++ {
+		s.Put(&Post{
+			ID:      strconv.Itoa(i),
+			UserID:  "u1",
+			EventID: "e" + strconv.Itoa(i%2),
+			Created: now.Add(time.Duration(i) * time.Second),
+		}, nil)
+	}
+
+	first := PageRequest{Order: "-Created", Limit: PAGE_SIZE}
+	posts, _ := s.ByUser("u1", first, nil)
+	if len(posts.Items) != PAGE_SIZE || posts.NextCursor == "" {
+		t.Errorf("ByUser() returned %v posts. Wanted %v and a cursor.", len(posts.Items), PAGE_SIZE)
+	} else if posts.Items[0].ID != strconv.Itoa(PAGE_SIZE) {
+		t.Errorf("ByUser() did not return the newest post first: got %v.", posts.Items[0].ID)
+	}
+
+	rest, _ := s.ByUser("u1", PageRequest{Order: "-Created", Cursor: posts.NextCursor, Limit: PAGE_SIZE}, nil)
+	if len(rest.Items) != 1 || rest.Items[0].ID != "0" || rest.NextCursor != "" {
+		t.Errorf("ByUser() of the second page returned %+v. Wanted only the oldest post.", rest)
+	}
+
+	posts, _ = s.ByEvent("e1", PageRequest{Order: "Created", Limit: 3}, nil)
+	if len(posts.Items) != 3 || posts.Items[0].ID != "1" {
+		t.Errorf("ByEvent() returned %+v. Wanted 3 posts, oldest first.", posts.Items)
+	}
+
+	posts, _ = s.ByUser("nobody", first, nil)
+	if len(posts.Items) != 0 {
+		t.Errorf("ByUser() returned %v posts for an unknown user.", len(posts.Items))
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Largest page size a client may request.
+const MAX_PAGE_SIZE = 100
+
+// ErrInvalidCursor is returned when a cursor is malformed, or was returned for a
+// listing with a different order.
+var ErrInvalidCursor = errors.New("Invalid cursor.")
+
+// PageRequest asks for one page of a listing. The first page has an empty Cursor, and
+// each following page is requested with the cursor returned with the page before it.
+type PageRequest struct {
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+// EventPage is a page of events. NextCursor is empty on the last page.
+type EventPage struct {
+	Items      []Event `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// PostPage is a page of posts. NextCursor is empty on the last page.
+type PostPage struct {
+	Items      []Post `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// UserPage is a page of users. NextCursor is empty on the last page.
+type UserPage struct {
+	Items      []AppUser `json:"items"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// TrashPage is a page of trashed items. NextCursor is empty on the last page.
+type TrashPage struct {
+	Items      []TrashItem `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// keysetCursor marks a position in a listing sorted by a time value and then by ID, so
+// that the next page starts right after the last item returned, even if items were added
+// or removed in between.
+type keysetCursor struct {
+	Order string    `json:"o"`
+	Value time.Time `json:"v"`
+	ID    string    `json:"id"`
+}
+
+// EncodeCursor returns an opaque cursor for the position after an item with the given
+// sort value and ID, in a listing sorted by order.
+func EncodeCursor(order string, value time.Time, id string) string {
+	b, _ := json.Marshal(&keysetCursor{order, value.UTC(), id})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor returns the sort value and ID held by a cursor from EncodeCursor. It
+// returns ErrInvalidCursor if the cursor was not made for a listing sorted by order.
+func DecodeCursor(cursor, order string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	var kc keysetCursor
+	if err := json.Unmarshal(b, &kc); err != nil || kc.Order != order || kc.ID == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return kc.Value, kc.ID, nil
+}
+
+// EventCursor returns the cursor for the position after event in a feed sorted by order.
+func EventCursor(event *Event, order string) string {
+	return EncodeCursor(order, feedValue(event, order), event.ID)
+}
+
+// PostCursor returns the cursor for the position after post in a listing sorted by order.
+func PostCursor(post *Post, order string) string {
+	return EncodeCursor(order, post.Created, post.ID)
+}
+
+// UserCursor returns the cursor for the position after appUser in a listing sorted by order.
+func UserCursor(appUser *AppUser, order string) string {
+	return EncodeCursor(order, appUser.Created, appUser.ID)
+}
+
+// TrashCursor returns the cursor for the position after item in a listing sorted by order.
+func TrashCursor(item *TrashItem, order string) string {
+	return EncodeCursor(order, item.Trashed, item.Key())
+}
+
+// feedValue returns the value of the property an event feed is sorted by.
+func feedValue(event *Event, order string) time.Time {
+	if order == "End" || order == "-End" {
+		return event.End
+	}
+
+	return event.Created
+}
+
+// readPageRequest reads the cursor and limit query parameters of a listing request. The
+// limit defaults to PAGE_SIZE, and must be between 1 and MAX_PAGE_SIZE.
+func readPageRequest(r *http.Request, order string) (PageRequest, error) {
+	req := PageRequest{Order: order, Cursor: r.FormValue("cursor"), Limit: PAGE_SIZE}
+
+	if limit := r.FormValue("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MAX_PAGE_SIZE {
+			return req, errors.New("Limit must be a number from 1 to " + strconv.Itoa(MAX_PAGE_SIZE) + ".")
+		}
+		req.Limit = n
+	}
+
+	return req, nil
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/log"
+
+	"net/http"
+)
+
+// Roles of users. Users who have not been given a role have ROLE_USER.
+const (
+	ROLE_USER      = "user"
+	ROLE_MODERATOR = "moderator"
+	ROLE_ADMIN     = "admin"
+)
+
+// An Action is something a user may do to a resource, which Can decides whether to allow.
+// Each action is taken on one type of resource.
+type Action string
+
+const (
+	ACTION_UPDATE_USER    Action = "update-user"    // *AppUser
+	ACTION_DELETE_USER    Action = "delete-user"    // *AppUser
+	ACTION_SET_ROLE       Action = "set-role"       // *AppUser
+	ACTION_MANAGE_TOKENS  Action = "manage-tokens"  // *AppUser
+	ACTION_VIEW_EVENT     Action = "view-event"     // *Event
+	ACTION_UPDATE_EVENT   Action = "update-event"   // *Event
+	ACTION_DELETE_EVENT   Action = "delete-event"   // *Event
+	ACTION_MANAGE_MEMBERS Action = "manage-members" // *Event
+	ACTION_MANAGE_HOSTS   Action = "manage-hosts"   // *Event
+	ACTION_MODERATE_POSTS Action = "moderate-posts" // *Event whose posts are moderated
+	ACTION_UPDATE_POST    Action = "update-post"    // *Post
+	ACTION_DELETE_POST    Action = "delete-post"    // *Post
+	ACTION_RESTORE        Action = "restore"        // *TrashItem
+	ACTION_VIEW_JOB       Action = "view-job"       // *Job
+	ACTION_DOWNLOAD       Action = "download"       // *Job exporting a user's data
+	ACTION_VIEW_STATS     Action = "view-stats"     // nil, for stats of the whole app
+)
+
+// A Principal is the signed in user making a request, along with their role.
+type Principal struct {
+	ID   string
+	Role string
+}
+
+// Can reports whether p may take an action on a resource. p is nil if no user is signed in.
+// Members of a private event are not known to Can, and are let in by Event.AuthorizeView.
+//
+// Users may act on what they own: themselves, the events they created and their posts,
+// jobs and trash. Co-hosts of an event may also view, update and moderate it, and manage
+// its members, but not delete it or change its co-hosts. Moderators may also view private
+// events and delete and restore any post. Admins may do anything else, except act as
+// other users by managing their tokens or downloading their data.
+func Can(p *Principal, action Action, resource interface{}) bool {
+	if event, ok := resource.(*Event); ok && action == ACTION_VIEW_EVENT && !event.Private {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+
+	owner := ownerID(resource) == p.ID
+	host := owner
+	if event, ok := resource.(*Event); ok && event.IsCoHost(p.ID) {
+		host = true
+	}
+
+	switch action {
+	case ACTION_MANAGE_TOKENS, ACTION_DOWNLOAD:
+		return owner
+	case ACTION_SET_ROLE:
+		return p.Role == ROLE_ADMIN
+	}
+
+	if p.Role == ROLE_ADMIN {
+		return true
+	}
+
+	switch action {
+	case ACTION_VIEW_EVENT, ACTION_MODERATE_POSTS:
+		return host || p.Role == ROLE_MODERATOR
+	case ACTION_UPDATE_EVENT, ACTION_MANAGE_MEMBERS:
+		return host
+	case ACTION_DELETE_POST:
+		return owner || p.Role == ROLE_MODERATOR
+	case ACTION_RESTORE:
+		// Items trashed by someone else, e.g. a post removed by a moderator, can only be
+		// restored by another moderator.
+		item := resource.(*TrashItem)
+		if p.Role == ROLE_MODERATOR && item.Kind == POST_KIND {
+			return true
+		}
+		return owner && (item.TrashedBy == "" || item.TrashedBy == item.OwnerID)
+	}
+
+	return owner
+}
+
+// ownerID returns the ID of the user who owns a resource.
+func ownerID(resource interface{}) string {
+	switch r := resource.(type) {
+	case *AppUser:
+		return r.ID
+	case *Event:
+		return r.Creator
+	case *Post:
+		return r.UserID
+	case *TrashItem:
+		return r.OwnerID
+	case *Job:
+		return r.UserID
+	}
+
+	return ""
+}
+
+// ValidRole reports whether role is one of the roles a user can be given.
+func ValidRole(role string) bool {
+	return role == ROLE_USER || role == ROLE_MODERATOR || role == ROLE_ADMIN
+}
+
+// GetRequestPrincipal returns the signed in user making a request, with their role. An
+// error is returned if no user is signed in. Users who have not registered yet have
+// ROLE_USER, and administrators of the app, as told by the sign in scheme, ROLE_ADMIN.
+func GetRequestPrincipal(r *http.Request, c context.Context) (*Principal, error) {
+	u, err := getRequestUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{ID: u.ID, Role: ROLE_USER}
+	if u.Admin {
+		p.Role = ROLE_ADMIN
+		return p, nil
+	}
+
+	appUser, err := FetchAppUser(u.ID, c)
+	if err != nil && err != ErrNoSuchEntity {
+		// Failing to find the role only takes away privileges, so the request can go on.
+		log.Warningf(c, "Failed to fetch the role of user %v: %v", u.ID, err)
+	}
+	if err == nil && ValidRole(appUser.Role) {
+		p.Role = appUser.Role
+	}
+
+	return p, nil
+}
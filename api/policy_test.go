@@ -0,0 +1,72 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	user := &Principal{ID: "u", Role: ROLE_USER}
+	other := &Principal{ID: "o", Role: ROLE_USER}
+	moderator := &Principal{ID: "m", Role: ROLE_MODERATOR}
+	admin := &Principal{ID: "a", Role: ROLE_ADMIN}
+
+	appUser := &AppUser{ID: "u"}
+	private := &Event{ID: "e", Creator: "u", Private: true}
+	hosted := &Event{ID: "h", Creator: "u", Private: true, CoHosts: []string{"o"}}
+	post := &Post{ID: "p", UserID: "u"}
+	trashedPost := &TrashItem{Kind: POST_KIND, ID: "p", OwnerID: "u", TrashedBy: "u"}
+	removedPost := &TrashItem{Kind: POST_KIND, ID: "p", OwnerID: "u", TrashedBy: "m"}
+	trashedEvent := &TrashItem{Kind: EVENT_KIND, ID: "e", OwnerID: "u", TrashedBy: "a"}
+	export := &Job{ID: "j", Kind: JOB_EXPORT, UserID: "u"}
+
+	tests := []struct {
+		p        *Principal
+		action   Action
+		resource interface{}
+		want     bool
+	}{
+		{nil, ACTION_VIEW_EVENT, &Event{Creator: "u"}, true},
+		{nil, ACTION_VIEW_EVENT, private, false},
+		{other, ACTION_VIEW_EVENT, private, false},
+		{moderator, ACTION_VIEW_EVENT, private, true},
+		{user, ACTION_UPDATE_EVENT, private, true},
+		{moderator, ACTION_UPDATE_EVENT, private, false},
+		{admin, ACTION_DELETE_EVENT, private, true},
+		{other, ACTION_VIEW_EVENT, hosted, true},
+		{other, ACTION_UPDATE_EVENT, hosted, true},
+		{other, ACTION_MANAGE_MEMBERS, hosted, true},
+		{other, ACTION_MODERATE_POSTS, hosted, true},
+		{other, ACTION_MODERATE_POSTS, private, false},
+		{moderator, ACTION_MODERATE_POSTS, private, true},
+		{other, ACTION_DELETE_EVENT, hosted, false},
+		{other, ACTION_MANAGE_HOSTS, hosted, false},
+		{user, ACTION_MANAGE_HOSTS, hosted, true},
+
+		{other, ACTION_DELETE_POST, post, false},
+		{moderator, ACTION_DELETE_POST, post, true},
+		{moderator, ACTION_UPDATE_POST, post, false},
+		{user, ACTION_RESTORE, trashedPost, true},
+		{user, ACTION_RESTORE, removedPost, false},
+		{moderator, ACTION_RESTORE, removedPost, true},
+		{user, ACTION_RESTORE, trashedEvent, false},
+		{moderator, ACTION_RESTORE, trashedEvent, false},
+		{admin, ACTION_RESTORE, trashedEvent, true},
+
+		{user, ACTION_UPDATE_USER, appUser, true},
+		{other, ACTION_DELETE_USER, appUser, false},
+		{admin, ACTION_DELETE_USER, appUser, true},
+		{user, ACTION_SET_ROLE, appUser, false},
+		{admin, ACTION_SET_ROLE, appUser, true},
+		{admin, ACTION_MANAGE_TOKENS, appUser, false},
+		{admin, ACTION_VIEW_JOB, export, true},
+		{admin, ACTION_DOWNLOAD, export, false},
+		{user, ACTION_DOWNLOAD, export, true},
+		{moderator, ACTION_VIEW_STATS, nil, false},
+		{admin, ACTION_VIEW_STATS, nil, true},
+	}
+	for _, test := range tests {
+		if got := Can(test.p, test.action, test.resource); got != test.want {
+			t.Errorf("Can(%+v, %v, %+v) = %v. Wanted %v.", test.p, test.action, test.resource, got, test.want)
+		}
+	}
+}
@@ -1,20 +1,23 @@
 package api
 
 import (
-	"appengine"
-	"appengine/datastore"
-	"appengine/taskqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 
 	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
 
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
 const POST_KIND = "post"
+const DEFAULT_POST_ORDER = "-Created"
 
 type Post struct {
 	UserID   string    `json:"user"`
@@ -52,7 +55,9 @@ func (post *Post) IsValidRequest() bool {
 	return true
 }
 
-func (post *Post) createFileName() string {
+// ImageName returns the name the post's image is stored under. Its resized copies are
+// named by imgstore.VariantNames.
+func (post *Post) ImageName() string {
 	return fmt.Sprintf("%v/%v", post.UserID, post.ID)
 }
 
@@ -68,22 +73,24 @@ type Comment struct {
 	Date   time.Time `json:"date"`
 }
 
+// CreatePost creates a post without an image, which is attached with AttachImage. Users
+// can post to the active events they can view.
 func CreatePost(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	currentUser, err := getRequestUser(r)
+	c := NewContext(r)
+	currentUser, err := GetRequestPrincipal(r, c)
 	if err != nil {
-		c.Errorf("Must be signed in to create post: %v\n", err)
+		log.Errorf(c, "Must be signed in to create post: %v\n", err)
 		http.Error(w, "Not signed in.", http.StatusForbidden)
 		return
 	}
 
 	reqPost := new(Post)
 	if err := readEntity(r, reqPost); err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
 	}
 
 	if !reqPost.IsValidRequest() {
-		c.Infof("Invalid Post request object.")
+		log.Infof(c, "Invalid Post request object.")
 		http.Error(w, "Invalid post data.", http.StatusBadRequest)
 		return
 	}
@@ -91,35 +98,26 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	// Validate that the event ID matches an existing, active event
 	event, err := FetchEvent(reqPost.EventID, c)
 	if err != nil {
-		c.Infof("Could not find event %v referenced by post: %v", reqPost.EventID, err)
+		log.Infof(c, "Could not find event %v referenced by post: %v", reqPost.EventID, err)
 		http.Error(w, "Post does not match an existing event.", http.StatusBadRequest)
 		return
 	}
 
-	if !event.IsActive() {
-		c.Infof("Cannot post to inactive event %v.", reqPost.EventID)
-		http.Error(w, "This event is not currently active.", http.StatusForbidden)
-		return
-	}
-
-	id, err := NewUID(c)
-	if err != nil {
-		c.Errorf("Failed to generate post ID: %v", err)
-		http.Error(w, "Failed to create a post.", http.StatusInternalServerError)
+	if err := event.AuthorizeView(currentUser, c); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
-	_, err = FetchPost(id, c)
-	if err != datastore.ErrNoSuchEntity {
-		c.Errorf("Duplicate post ID generated! Aborting. Error: %v", err)
-		http.Error(w, "Failed to create a post, please try again.", http.StatusInternalServerError)
+	if !event.IsActive() {
+		log.Infof(c, "Cannot post to inactive event %v.", reqPost.EventID)
+		http.Error(w, "This event is not currently active.", http.StatusForbidden)
 		return
 	}
 
 	now := time.Now()
 	post := &Post{
 		UserID:   currentUser.ID,
-		ID:       id,
+		ID:       IDs.Next().String(),
 		EventID:  reqPost.EventID,
 		Image:    "",
 		Text:     reqPost.Text,
@@ -128,17 +126,17 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !post.IsValid() {
-		c.Errorf("Invalid Post object, cannot store.")
+		log.Errorf(c, "Invalid Post object, cannot store.")
 		http.Error(w, "Failed to create post.", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = savePost(post, c)
+	err = savePost(post, c)
 	if err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
 	}
 
-	resp := CreatePostResponse{true, id}
+	resp := CreatePostResponse{true, post.ID}
 	w.WriteHeader(http.StatusCreated)
 	sendJsonResponse(w, resp)
 }
@@ -146,62 +144,68 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 // AttachImage stores and associates an image file with a Post.
 // This function should be called immediately after a successfull call to CreatePost.
 func AttachImage(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	postID := GetRequestVar(r, "id", c)
 
-	currentUser, err := getRequestUser(r)
+	currentUser, err := GetRequestPrincipal(r, c)
 	if err != nil {
-		c.Errorf("Must be signed in to create post: %v\n", err)
+		log.Errorf(c, "Must be signed in to create post: %v\n", err)
 		http.Error(w, "Not signed in.", http.StatusForbidden)
 		return
 	}
 
 	post, err := FetchPost(postID, c)
 	if err != nil {
-		c.Errorf("Cannot attach image - no post found with ID %v.", postID)
+		log.Errorf(c, "Cannot attach image - no post found with ID %v.", postID)
 		http.NotFound(w, r)
 		return
 	}
 
 	postUser, err := FetchAppUser(post.UserID, c)
 	if err != nil {
-		c.Errorf("Could not find AppUser with ID %v: %v", post.UserID, err)
+		log.Errorf(c, "Could not find AppUser with ID %v: %v", post.UserID, err)
 		http.Error(w, "Failed to post image: user not found.", http.StatusInternalServerError)
 		return
-	} else if postUser.ID != currentUser.ID {
-		c.Errorf("User with ID %v cannot attach an image to a post by user ID %v", currentUser.ID, postUser.ID)
+	} else if !Can(currentUser, ACTION_UPDATE_POST, post) {
+		log.Errorf(c, "User with ID %v cannot attach an image to a post by user ID %v", currentUser.ID, postUser.ID)
 		http.Error(w, "Cannot post for a different user.", http.StatusForbidden)
 		return
 	}
 
 	if post.Image != "" {
-		c.Errorf("Cannot attach image - Post %v by user %v already has an image attached.", postUser.ID, postID)
+		log.Errorf(c, "Cannot attach image - Post %v by user %v already has an image attached.", postUser.ID, postID)
 		http.Error(w, "Cannot overwrite the image in a post.", http.StatusForbidden)
 		return
 	}
 
 	// TODO Validate size, anything else about request data if necessary...
 
-	filename := post.createFileName()
-	obj, err := imgstore.Create(filename, r)
+	filename := post.ImageName()
+	_, err = imgstore.Create(c, filename, r)
 	if err != nil {
-		c.Errorf("Failed to store image for user %v: %v", post.UserID, err)
+		log.Errorf(c, "Failed to store image for user %v: %v", post.UserID, err)
 		http.Error(w, "An error occurred while attempting to save the file.", http.StatusInternalServerError)
 		return
 	}
 
-	c.Infof("Stored file %v for user %v.", filename, post.UserID)
+	log.Infof(c, "Stored file %v for user %v.", filename, post.UserID)
 
 	if err = queueProcessing(filename, c); err != nil {
-		c.Errorf("Failed to add file %v for post %v to image processing queue.", filename, post.ID)
+		log.Errorf(c, "Failed to add file %v for post %v to image processing queue.", filename, post.ID)
+	}
+
+	post.Image, err = imgstore.Link(c, filename)
+	if err != nil {
+		log.Errorf(c, "Failed to get link to file %v for post %v: %v", filename, post.ID, err)
+		http.Error(w, "An error occurred while attempting to save the file.", http.StatusInternalServerError)
+		return
 	}
 
-	post.Image = imgstore.ObjectLink(obj)
 	post.Modified = time.Now()
 
-	_, err = savePost(post, c)
+	err = savePost(post, c)
 	if err != nil {
-		c.Errorf("Failed to store updated Post (ID=%v) by user %v: %v", post.ID, postUser.ID, err)
+		log.Errorf(c, "Failed to store updated Post (ID=%v) by user %v: %v", post.ID, postUser.ID, err)
 		http.Error(w, "Failed to update the post.", http.StatusInternalServerError)
 		return
 	}
@@ -209,26 +213,92 @@ func AttachImage(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, post)
 }
 
-func GetPost(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	postID := GetRequestVar(r, "id", c)
+// EventPosts returns a page of the posts made to an event as a PostPage. See
+// ReadPostPageRequest for the query parameters.
+func EventPosts(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	eventID := GetRequestVar(r, "id", c)
 
-	post, err := FetchPost(postID, c)
+	event, err := FetchEvent(eventID, c)
 	if err != nil {
-		c.Infof("Could not fetch post %v: %v", postID, err.Error())
+		log.Infof(c, "Could not fetch event %v: %v", eventID, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	postUser, err := FetchAppUser(post.UserID, c)
+	req, err := ReadPostPageRequest(r)
 	if err != nil {
-		c.Infof("User %v who created post %v could not be found: %v", post.UserID, postID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, _ := GetRequestPrincipal(r, c)
+	page, err := FetchEventPosts(event, p, req, c)
+	sendPostPage(w, page, err, c)
+}
+
+// UserPosts returns a page of the posts made by a user as a PostPage. See
+// ReadPostPageRequest for the query parameters. Posts made to private events the signed
+// in user cannot view are left out.
+func UserPosts(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+
+	userID, err := getUserID(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	postView := &PostView{
-		Username: postUser.Username,
+	req, err := ReadPostPageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := FetchUserPosts(userID, req, c)
+	if err == nil {
+		p, _ := GetRequestPrincipal(r, c)
+		page.Items, err = VisiblePosts(page.Items, p, c)
+	}
+	sendPostPage(w, page, err, c)
+}
+
+// PostViewPage is a page of posts with their authors' usernames, as returned by the
+// EventPosts and UserPosts handlers.
+type PostViewPage struct {
+	Items      []PostView `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+func sendPostPage(w http.ResponseWriter, page *PostPage, err error, c context.Context) {
+	if _, ok := err.(*ErrPrivateEvent); ok {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err == ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch posts.", http.StatusInternalServerError)
+		return
+	}
+
+	postViews, err := FetchPostViews(page.Items, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch posts.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJsonResponse(w, &PostViewPage{postViews, page.NextCursor})
+}
+
+// NewPostView returns the view of a post made by the user with username.
+func NewPostView(post *Post, username string) PostView {
+	return PostView{
+		Username: username,
 		ID:       post.ID,
 		EventID:  post.EventID,
 		Image:    post.Image,
@@ -236,30 +306,99 @@ func GetPost(w http.ResponseWriter, r *http.Request) {
 		Created:  post.Created,
 		Modified: post.Modified,
 	}
+}
+
+// FetchPostViews returns the views of posts, looking up all of their authors at once.
+func FetchPostViews(posts []Post, c context.Context) ([]PostView, error) {
+	userIDs := make([]string, len(posts))
+	for i := range posts {
+		userIDs[i] = posts[i].UserID
+	}
+
+	usernames, err := FetchUsernames(userIDs, c)
+	if err != nil {
+		return nil, err
+	}
+
+	postViews := make([]PostView, len(posts))
+	for i := range posts {
+		postViews[i] = NewPostView(&posts[i], usernames[posts[i].UserID])
+	}
+
+	return postViews, nil
+}
+
+// ReadPostPageRequest reads the order, cursor and limit query parameters of a request for
+// a page of posts. Posts are sorted newest first unless order is "Created".
+func ReadPostPageRequest(r *http.Request) (PageRequest, error) {
+	order := r.FormValue("order")
+	if !validPostOrder(order) {
+		order = DEFAULT_POST_ORDER
+	}
 
-	sendJsonResponse(w, postView)
+	return readPageRequest(r, order)
+}
+
+func validPostOrder(order string) bool {
+	return order == "Created" || order == "-Created"
+}
+
+func GetPost(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	postID := GetRequestVar(r, "id", c)
+
+	post, err := FetchPost(postID, c)
+	if err != nil {
+		log.Infof(c, "Could not fetch post %v: %v", postID, err.Error())
+		http.NotFound(w, r)
+		return
+	}
+
+	p, _ := GetRequestPrincipal(r, c)
+	posts, err := VisiblePosts([]Post{*post}, p, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch post.", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) == 0 {
+		http.Error(w, new(ErrPrivateEvent).Error(), http.StatusForbidden)
+		return
+	}
+
+	postViews, err := FetchPostViews(posts, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch post.", http.StatusInternalServerError)
+		return
+	}
+
+	sendJsonResponse(w, &postViews[0])
 }
 
 func UpdatePost(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	postID := GetRequestVar(r, "id", c)
 
 	post, err := FetchPost(postID, c)
 	if err != nil {
-		c.Errorf("Cannot update - post ID %v not found.", postID)
+		log.Errorf(c, "Cannot update - post ID %v not found.", postID)
 		http.NotFound(w, r)
 		return
 	}
 
 	postUser, err := FetchAppUser(post.UserID, c)
 	if err != nil {
-		c.Infof("User %v who created post %v could not be found: %v", post.UserID, postID, err)
+		log.Infof(c, "User %v who created post %v could not be found: %v", post.UserID, postID, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	currentUser, err := getRequestUser(r)
-	if currentUser.ID != postUser.ID {
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to update a post: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+	if !Can(currentUser, ACTION_UPDATE_POST, post) {
 		http.Error(w, "You can only update your own posts.", http.StatusForbidden)
 		return
 	}
@@ -267,19 +406,19 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 	updatedPost := new(Post)
 	err = readEntity(r, updatedPost)
 	if err != nil {
-		c.Errorf("Failed to read post data from request: %v", err)
+		log.Errorf(c, "Failed to read post data from request: %v", err)
 		http.Error(w, "Invalid post data in request.", http.StatusBadRequest)
 		return
 	}
 
 	if !updatedPost.IsValidRequest() {
-		c.Infof("Invalid Post request object.")
+		log.Infof(c, "Invalid Post request object.")
 		http.Error(w, "Invalid post data.", http.StatusBadRequest)
 		return
 	}
 
 	if updatedPost.EventID != post.EventID {
-		c.Infof("Cannot move post from event %v to event %v!", post.EventID, updatedPost.EventID)
+		log.Infof(c, "Cannot move post from event %v to event %v!", post.EventID, updatedPost.EventID)
 		http.Error(w, "Cannot move this post to a different event.", http.StatusBadRequest)
 		return
 	}
@@ -287,13 +426,13 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 	// Validate that the event ID matches an existing, active event
 	event, err := FetchEvent(updatedPost.EventID, c)
 	if err != nil {
-		c.Infof("Could not find event %v referenced by post: %v", updatedPost.EventID, err)
+		log.Infof(c, "Could not find event %v referenced by post: %v", updatedPost.EventID, err)
 		http.Error(w, "Post does not match an existing event.", http.StatusBadRequest)
 		return
 	}
 
 	if !event.IsActive() {
-		c.Infof("Cannot update a post for inactive event %v.", updatedPost.EventID)
+		log.Infof(c, "Cannot update a post for inactive event %v.", updatedPost.EventID)
 		http.Error(w, "This event is not currently active.", http.StatusForbidden)
 		return
 	}
@@ -302,9 +441,9 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 	post.Text = updatedPost.Text
 	post.Modified = time.Now()
 
-	_, err = savePost(post, c)
+	err = savePost(post, c)
 	if err != nil {
-		c.Errorf("Failed to store updated Post (ID=%v) by user %v: %v", post.ID, postUser.ID, err)
+		log.Errorf(c, "Failed to store updated Post (ID=%v) by user %v: %v", post.ID, postUser.ID, err)
 		http.Error(w, "Failed to update the post.", http.StatusInternalServerError)
 		return
 	}
@@ -312,136 +451,124 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, post)
 }
 
+// DeletePost moves a post to the trash. Posts may be deleted by their author, by
+// moderators, and by the creator and co-hosts of their event.
 func DeletePost(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	postID := GetRequestVar(r, "id", c)
 
 	post, err := FetchPost(postID, c)
 	if err != nil {
-		c.Errorf("Cannot delete - post ID %v not found.", postID)
+		log.Errorf(c, "Cannot delete - post ID %v not found.", postID)
 		http.NotFound(w, r)
 		return
 	}
 
 	postUser, err := FetchAppUser(post.UserID, c)
 	if err != nil {
-		c.Infof("User %v who created post %v could not be found: %v", post.UserID, postID, err)
+		log.Infof(c, "User %v who created post %v could not be found: %v", post.UserID, postID, err)
 		http.NotFound(w, r)
 		return
 	}
 
-	currentUser, err := getRequestUser(r)
-	if currentUser.ID != postUser.ID {
-		http.Error(w, "You can only update your own posts.", http.StatusForbidden)
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to delete a post: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
 		return
 	}
-
-	err = deletePost(postID, c)
-	if err != nil {
-		c.Errorf("Failed to delete post %v from user %v.", postID, postUser.ID)
-		http.Error(w, "Failed to delete post.", http.StatusInternalServerError)
+	if !Can(currentUser, ACTION_DELETE_POST, post) && !canModerate(currentUser, post.EventID, c) {
+		http.Error(w, "You can only delete your own posts.", http.StatusForbidden)
 		return
 	}
 
-	filename := post.createFileName()
-	err = imgstore.Delete(filename, r)
+	// The image is kept until the post is purged from the trash, so it can be restored.
+	err = trashPost(post, "", currentUser.ID, c)
 	if err != nil {
-		// TODO Add a retry to task queue if we can?
-		c.Errorf("Failed to delete file %v for user %v: %v", filename, post.UserID, err)
+		log.Errorf(c, "Failed to trash post %v from user %v: %v", postID, postUser.ID, err)
+		http.Error(w, "Failed to delete post.", http.StatusInternalServerError)
+		return
 	}
 
-	c.Infof("Deleted post %v from user %v.", postID, postUser.ID)
+	log.Infof(c, "User %v trashed post %v from user %v.", currentUser.ID, postID, postUser.ID)
 
 	resp := OkResponse{true}
 	sendJsonResponse(w, resp)
 }
 
-func queueProcessing(filename string, c appengine.Context) error {
-	t := taskqueue.NewPOSTTask("/", url.Values{
+func queueProcessing(filename string, c context.Context) error {
+	return tasks.Add(c, "image-processor", "/", url.Values{
 		"filename": {filename},
 	})
+}
 
-	_, err := taskqueue.Add(c, t, "image-processor")
-
-	return err
+func FetchPost(postID string, c context.Context) (*Post, error) {
+	return Posts.Get(postID, c)
 }
 
-func FetchPost(postID string, c appengine.Context) (*Post, error) {
-	post := new(Post)
-	postKey, err := getPostDSKey(postID, c)
-	if err != nil {
-		return nil, err
+func FetchUserPosts(userID string, req PageRequest, c context.Context) (*PostPage, error) {
+	page, err := Posts.ByUser(userID, req, c)
+	if err != nil && err != ErrInvalidCursor {
+		log.Errorf(c, "Failed to get posts for user %v: %v", userID, err)
 	}
 
-	err = datastore.Get(c, postKey, post)
-	if err != nil {
-		return nil, err
-	} else {
-		return post, nil
-	}
+	return page, err
 }
 
-// TODO Need to control offset/limit/sort
-func FetchUserPosts(userID string, c appengine.Context) (*[]Post, error) {
-	q := datastore.NewQuery(POST_KIND).
-		Filter("UserID =", userID).
-		Limit(20).
-		Order("-Created")
-
-	posts := make([]Post, 0, 20)
-
-	_, err := q.GetAll(c, &posts)
-	if err != nil {
-		c.Errorf("Failed to get posts for user %v: %v", userID, err)
+// FetchEventPosts returns a page of the posts made to an event, failing with
+// ErrPrivateEvent if p cannot view the event.
+func FetchEventPosts(event *Event, p *Principal, req PageRequest, c context.Context) (*PostPage, error) {
+	if err := event.AuthorizeView(p, c); err != nil {
 		return nil, err
 	}
 
-	return &posts, nil
+	return fetchEventPosts(event.ID, req, c)
 }
 
-// TODO Need to control offset/limit/sort
-func FetchEventPosts(eventID string, c appengine.Context) (*[]Post, error) {
-	q := datastore.NewQuery(POST_KIND).
-		Filter("EventID =", eventID).
-		Limit(20).
-		Order("-Created")
-
-	posts := make([]Post, 0, 20)
-
-	_, err := q.GetAll(c, &posts)
-	if err != nil {
-		c.Errorf("Failed to get posts for event %v: %v", eventID, err)
-		return nil, err
-	}
-
-	return &posts, nil
+// VisiblePosts returns the posts which were made to events p can view. Posts of events
+// which no longer exist are left out.
+func VisiblePosts(posts []Post, p *Principal, c context.Context) ([]Post, error) {
+	canView := make(map[string]bool)
+	visible := make([]Post, 0, len(posts))
+	for i := range posts {
+		eventID := posts[i].EventID
+		ok, seen := canView[eventID]
+		if !seen {
+			event, err := FetchEvent(eventID, c)
+			if err != nil && err != ErrNoSuchEntity {
+				log.Errorf(c, "Failed to fetch event %v: %v", eventID, err)
+				return nil, err
+			}
+			ok = err == nil && event.canView(p, c)
+			canView[eventID] = ok
+		}
+
+		if ok {
+			visible = append(visible, posts[i])
+		}
+	}
+
+	return visible, nil
 }
 
-func savePost(post *Post, c appengine.Context) (*datastore.Key, error) {
-	postKey, err := getPostDSKey(post.ID, c)
-	if err != nil {
-		return nil, err
+func fetchEventPosts(eventID string, req PageRequest, c context.Context) (*PostPage, error) {
+	page, err := Posts.ByEvent(eventID, req, c)
+	if err != nil && err != ErrInvalidCursor {
+		log.Errorf(c, "Failed to get posts for event %v: %v", eventID, err)
 	}
 
-	key, err := datastore.Put(c, postKey, post)
-	if err != nil {
-		return nil, err
-	} else {
-		return key, nil
-	}
+	return page, err
 }
 
-func deletePost(postID string, c appengine.Context) error {
-	postKey, err := getPostDSKey(postID, c)
-	if err != nil {
-		return err
-	}
+func savePost(post *Post, c context.Context) error {
+	return Posts.Put(post, c)
+}
 
-	err = datastore.Delete(c, postKey)
-	return err
+func deletePost(postID string, c context.Context) error {
+	return Posts.Delete(postID, c)
 }
 
-func getPostDSKey(postID string, c appengine.Context) (*datastore.Key, error) {
+func getPostDSKey(postID string, c context.Context) (*datastore.Key, error) {
 	if postID == "" {
 		return nil, errors.New("No postID provided.")
 	}
@@ -451,9 +578,9 @@ func getPostDSKey(postID string, c appengine.Context) (*datastore.Key, error) {
 	return postKey, nil
 }
 
-func createPostKeyID(postID string, c appengine.Context) string {
+func createPostKeyID(postID string, c context.Context) string {
 	if postID == "" {
-		c.Errorf("Creating a post entity key with no postID!")
+		log.Errorf(c, "Creating a post entity key with no postID!")
 	}
 
 	return "post:" + postID
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"errors"
+	"time"
+)
+
+// ErrNoSuchEntity is returned by a store when the requested entity does not exist.
+var ErrNoSuchEntity = errors.New("No such entity.")
+
+// ErrUsernameTaken is returned when saving a user with a username that belongs to another user.
+var ErrUsernameTaken = errors.New("Username is already taken.")
+
+// ErrUserExists is returned when creating a user with the ID of an existing user.
+var ErrUserExists = errors.New("User already exists.")
+
+// UserStore persists AppUser entities. Usernames are unique, ignoring case, and a
+// username is claimed atomically with creating or updating the user who owns it.
+type UserStore interface {
+	Get(userID string, c context.Context) (*AppUser, error)
+	GetByName(username string, c context.Context) (*AppUser, error)
+	Delete(userID string, c context.Context) error
+
+	// GetMulti looks up many users in a single call, returning them by ID. Users that
+	// do not exist are left out of the result.
+	GetMulti(userIDs []string, c context.Context) (map[string]*AppUser, error)
+
+	// Create stores a new user, failing with ErrUserExists or ErrUsernameTaken.
+	Create(appUser *AppUser, c context.Context) error
+	// Update replaces an existing user, failing with ErrNoSuchEntity or ErrUsernameTaken.
+	// A changed username is claimed, and the old username released, in one step.
+	Update(appUser *AppUser, c context.Context) error
+
+	// All returns a page of all users, sorted by req.Order, which must be "Created" or
+	// "-Created". Paging works like EventStore.FeedPage.
+	All(req PageRequest, c context.Context) (*UserPage, error)
+}
+
+// EventStore persists Event entities.
+type EventStore interface {
+	Get(eventID string, c context.Context) (*Event, error)
+	Put(event *Event, c context.Context) error
+	Delete(eventID string, c context.Context) error
+
+	// Update reads an event, changes it with update and stores it, all in one transaction,
+	// so that concurrent updates are not lost. If update fails, the event is left as it was
+	// and the error is returned. update may be called more than once.
+	Update(eventID string, update func(*Event) error, c context.Context) (*Event, error)
+
+	// Feed returns a page of events sorted by order, which must be accepted by validFeedOrder.
+	// Deprecated: Use FeedPage, which does not skip or repeat events added between pages.
+	Feed(order string, page int, c context.Context) ([]Event, error)
+
+	// FeedPage returns up to req.Limit events sorted by req.Order, starting after
+	// req.Cursor. Events with equal sort values are always returned in the same order. A
+	// cursor which was not returned by the same store for the same order fails with
+	// ErrInvalidCursor.
+	FeedPage(req PageRequest, c context.Context) (*EventPage, error)
+
+	// ByCreator returns a page of the events created by a user. Paging works like FeedPage.
+	ByCreator(creatorID string, req PageRequest, c context.Context) (*EventPage, error)
+}
+
+// PostStore persists Post entities.
+type PostStore interface {
+	Get(postID string, c context.Context) (*Post, error)
+	Put(post *Post, c context.Context) error
+	Delete(postID string, c context.Context) error
+
+	// ByUser and ByEvent return a page of the posts made by a user or to an event,
+	// sorted by req.Order, which must be accepted by validPostOrder. Paging works like
+	// EventStore.FeedPage.
+	ByUser(userID string, req PageRequest, c context.Context) (*PostPage, error)
+	ByEvent(eventID string, req PageRequest, c context.Context) (*PostPage, error)
+
+	// Imageless returns a page of the posts created before the given time which still
+	// have no image. Paging works like ByUser and ByEvent.
+	Imageless(before time.Time, req PageRequest, c context.Context) (*PostPage, error)
+
+	// All returns a page of all posts. Paging works like ByUser and ByEvent.
+	All(req PageRequest, c context.Context) (*PostPage, error)
+}
+
+// JobStore persists Job entities.
+type JobStore interface {
+	Get(jobID string, c context.Context) (*Job, error)
+	Put(job *Job, c context.Context) error
+}
+
+// DeadLetterStore persists DeadLetter entities.
+type DeadLetterStore interface {
+	Get(deadLetterID string, c context.Context) (*DeadLetter, error)
+	Put(deadLetter *DeadLetter, c context.Context) error
+}
+
+// TrashStore persists TrashItems, which are keyed by their Kind and ID together.
+type TrashStore interface {
+	Get(kind, id string, c context.Context) (*TrashItem, error)
+	Put(item *TrashItem, c context.Context) error
+	Delete(kind, id string, c context.Context) error
+
+	// ByOwner and ByParent return a page of the items trashed by a user or along with an
+	// event, sorted by req.Order, which must be "Trashed" or "-Trashed". Paging works like
+	// EventStore.FeedPage.
+	ByOwner(ownerID string, req PageRequest, c context.Context) (*TrashPage, error)
+	ByParent(parentID string, req PageRequest, c context.Context) (*TrashPage, error)
+
+	// Expired returns a page of the items trashed before the given time. Paging works like
+	// ByOwner and ByParent.
+	Expired(before time.Time, req PageRequest, c context.Context) (*TrashPage, error)
+}
+
+// MemberStore persists the Members of events, which are keyed by their EventID and UserID
+// together.
+type MemberStore interface {
+	Get(eventID, userID string, c context.Context) (*Member, error)
+	// Put adds or updates a member. It returns ErrEventFull instead of adding a member to
+	// an event which already has MAX_MEMBERS, checking the count in the same transaction.
+	Put(member *Member, c context.Context) error
+	Delete(eventID, userID string, c context.Context) error
+
+	// ByEvent returns all members of and invites to an event, oldest first. Events have
+	// at most MAX_MEMBERS.
+	ByEvent(eventID string, c context.Context) ([]Member, error)
+	// ByUser returns all of a user's memberships and invites, oldest first.
+	ByUser(userID string, c context.Context) ([]Member, error)
+}
+
+// InviteLinkStore persists InviteLinks.
+type InviteLinkStore interface {
+	Get(linkID string, c context.Context) (*InviteLink, error)
+	Put(link *InviteLink, c context.Context) error
+	Delete(linkID string, c context.Context) error
+
+	// Use counts a use of a link and returns it, failing with ErrLinkUsedUp if it has
+	// already been used MaxUses times.
+	Use(linkID string, c context.Context) (*InviteLink, error)
+
+	// ByEvent returns all links to an event, newest first. Events have at most MAX_LINKS.
+	ByEvent(eventID string, c context.Context) ([]InviteLink, error)
+}
+
+// TokenStore persists AccessTokens.
+type TokenStore interface {
+	Get(tokenID string, c context.Context) (*AccessToken, error)
+	Put(token *AccessToken, c context.Context) error
+	Delete(tokenID string, c context.Context) error
+
+	// Touch sets the LastUsed time of a token, failing with ErrNoSuchEntity rather than
+	// storing it again if it has been deleted.
+	Touch(tokenID string, lastUsed time.Time, c context.Context) error
+
+	// ByUser returns all of a user's tokens, newest first. Users have at most MAX_TOKENS.
+	ByUser(userID string, c context.Context) ([]AccessToken, error)
+}
+
+// The stores used by all handlers. They default to Datastore, and can be replaced
+// before serving any requests, e.g. with in-memory stores for tests.
+var (
+	Users  UserStore  = &DatastoreUserStore{}
+	Events EventStore = &DatastoreEventStore{}
+	Posts  PostStore  = &DatastorePostStore{}
+	Jobs   JobStore   = &DatastoreJobStore{}
+
+	DeadLetters DeadLetterStore = &DatastoreDeadLetterStore{}
+	Trash       TrashStore      = &DatastoreTrashStore{}
+	Tokens      TokenStore      = &DatastoreTokenStore{}
+	Members     MemberStore     = &DatastoreMemberStore{}
+	InviteLinks InviteLinkStore = &DatastoreInviteLinkStore{}
+)
+
+// Number of items returned in a single page of a feed or listing.
+const PAGE_SIZE = 20
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time an image may go without a post that links to it, or a post without an image,
+// before the sweeper cleans it up. Images are uploaded before they are attached to
+// their posts, so anything newer may still be in the middle of being created.
+const ORPHAN_AGE = 24 * time.Hour
+
+// SweepTask cleans up stored images which no post links to, along with posts which never
+// had an image attached, once they are older than ORPHAN_AGE. Exports are cleaned up once
+// they are older than EXPORT_TTL. Everything it finds is
+// logged, and when the dryRun form value is "true" nothing is deleted, so that the log
+// is a report of what would be.
+//
+// The sweep is started by cron, or by adding a task with no other form values. Each task
+// handles one page of images, then of posts, and queues the next one with the phase,
+// cursor, before, images, exports and posts form values.
+func SweepTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	s := sweep{
+		dryRun: r.FormValue("dryRun") == "true",
+		phase:  r.FormValue("phase"),
+		cursor: r.FormValue("cursor"),
+		before: time.Now().Add(-ORPHAN_AGE),
+	}
+	if before := r.FormValue("before"); before != "" {
+		var err error
+		s.before, err = time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			log.Errorf(c, "Cannot continue sweep with invalid time %q.", before)
+			return
+		}
+	}
+	s.images, _ = strconv.Atoi(r.FormValue("images"))
+	s.exports, _ = strconv.Atoi(r.FormValue("exports"))
+	s.posts, _ = strconv.Atoi(r.FormValue("posts"))
+
+	var err error
+	switch s.phase {
+	case "", "images":
+		s.phase = "images"
+		err = s.sweepImages(c)
+	case "posts":
+		err = s.sweepPosts(c)
+	default:
+		log.Errorf(c, "Cannot continue sweep in unknown phase %q.", s.phase)
+		return
+	}
+
+	if err == ErrInvalidCursor {
+		log.Errorf(c, "Cannot continue sweep with invalid cursor %q.", s.cursor)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Sweep of %v failed, it will be retried: %v", s.phase, err)
+		http.Error(w, "Sweep failed.", http.StatusInternalServerError)
+		return
+	}
+
+	if s.cursor == "" && s.phase == "images" {
+		s.phase = "posts"
+	} else if s.cursor == "" {
+		s.report(c)
+		return
+	}
+
+	if err := s.queue(c); err != nil {
+		log.Errorf(c, "Failed to queue next step of sweep: %v", err)
+		http.Error(w, "Sweep failed.", http.StatusInternalServerError)
+	}
+}
+
+// sweep is the state of a sweep, which is passed from each task to the next.
+type sweep struct {
+	dryRun bool
+	// phase is "images" or "posts".
+	phase  string
+	cursor string
+	// before is the time that images and posts must be older than to be cleaned up. It is
+	// set when the sweep starts, so that it does not move while paging.
+	before time.Time
+
+	// Number of orphaned images, expired exports and imageless posts found so far.
+	images  int
+	exports int
+	posts   int
+}
+
+// sweepImages cleans up a page of images, and moves the cursor to the next one.
+func (s *sweep) sweepImages(c context.Context) error {
+	objs, next, err := imgstore.List(c, "", s.cursor, MAX_PAGE_SIZE)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		if strings.HasPrefix(obj.Name, EXPORT_PREFIX) {
+			if err := s.sweepExport(obj, c); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if obj.Updated.After(s.before) {
+			continue
+		}
+
+		orphaned, err := isOrphanedImage(obj.Name, c)
+		if err != nil {
+			return err
+		}
+		if !orphaned {
+			continue
+		}
+
+		s.images++
+		if s.dryRun {
+			log.Infof(c, "Sweep would delete orphaned image %v, last updated %v.", obj.Name, obj.Updated)
+			continue
+		}
+
+		// Each resized copy is listed, and deleted, separately.
+		if err := imgstore.Store.Delete(c, obj.Name); err != nil {
+			return err
+		}
+		log.Infof(c, "Sweep deleted orphaned image %v, last updated %v.", obj.Name, obj.Updated)
+	}
+
+	s.cursor = next
+	return nil
+}
+
+// sweepExport deletes an export once it is older than EXPORT_TTL.
+func (s *sweep) sweepExport(obj *imgstore.Object, c context.Context) error {
+	if obj.Updated.After(time.Now().Add(-EXPORT_TTL)) {
+		return nil
+	}
+
+	s.exports++
+	if s.dryRun {
+		log.Infof(c, "Sweep would delete expired export %v, last updated %v.", obj.Name, obj.Updated)
+		return nil
+	}
+
+	if err := imgstore.Store.Delete(c, obj.Name); err != nil {
+		return err
+	}
+	log.Infof(c, "Sweep deleted expired export %v, last updated %v.", obj.Name, obj.Updated)
+	return nil
+}
+
+// sweepPosts cleans up a page of posts without images, and moves the cursor to the next one.
+func (s *sweep) sweepPosts(c context.Context) error {
+	page, err := Posts.Imageless(s.before, PageRequest{Order: "Created", Cursor: s.cursor, Limit: MAX_PAGE_SIZE}, c)
+	if err != nil {
+		return err
+	}
+
+	for i := range page.Items {
+		post := &page.Items[i]
+		s.posts++
+		if s.dryRun {
+			log.Infof(c, "Sweep would delete post %v without an image, created %v.", post.ID, post.Created)
+			continue
+		}
+
+		if err := deletePostContent(post, c); err != nil {
+			return err
+		}
+		log.Infof(c, "Sweep deleted post %v without an image, created %v.", post.ID, post.Created)
+	}
+
+	s.cursor = page.NextCursor
+	return nil
+}
+
+func (s *sweep) report(c context.Context) {
+	if s.dryRun {
+		log.Infof(c, "Sweep dry run done. Would delete %v orphaned images, %v expired exports and %v posts without images.",
+			s.images, s.exports, s.posts)
+	} else {
+		log.Infof(c, "Sweep done. Deleted %v orphaned images, %v expired exports and %v posts without images.",
+			s.images, s.exports, s.posts)
+	}
+}
+
+func (s *sweep) queue(c context.Context) error {
+	return tasks.Add(c, DELETION_QUEUE, "/t/sweep", url.Values{
+		"dryRun":  {strconv.FormatBool(s.dryRun)},
+		"phase":   {s.phase},
+		"cursor":  {s.cursor},
+		"before":  {s.before.Format(time.RFC3339Nano)},
+		"images":  {strconv.Itoa(s.images)},
+		"exports": {strconv.Itoa(s.exports)},
+		"posts":   {strconv.Itoa(s.posts)},
+	})
+}
+
+// isOrphanedImage reports whether no post links to a stored image, or one of its resized
+// copies. Images are named by post.ImageName, and objects with other names are
+// never reported, since they are not images of posts. Images of trashed posts are kept
+// until the posts are purged.
+func isOrphanedImage(name string, c context.Context) (bool, error) {
+	filename := strings.TrimSuffix(strings.TrimSuffix(name, imgstore.THUMB_SUFFIX), imgstore.VIEW_SUFFIX)
+	i := strings.LastIndex(filename, "/")
+	if i <= 0 || i == len(filename)-1 {
+		return false, nil
+	}
+
+	postID := filename[i+1:]
+	post, err := FetchPost(postID, c)
+	if err == ErrNoSuchEntity {
+		_, err = Trash.Get(POST_KIND, postID, c)
+		if err == ErrNoSuchEntity {
+			return true, nil
+		}
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return post.Image == "" || post.ImageName() != filename, nil
+}
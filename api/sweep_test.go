@@ -0,0 +1,108 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/tasks"
+)
+
+func TestSweepTask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(posts PostStore, trash TrashStore, store imgstore.BlobStore, queue tasks.Queue) {
+		Posts, Trash, imgstore.Store, tasks.Default = posts, trash, store, queue
+	}(Posts, Trash, imgstore.Store, tasks.Default)
+	Posts, Trash = NewMemoryPostStore(), NewMemoryTrashStore()
+	imgstore.Store = &imgstore.LocalStore{Dir: dir}
+
+	c := context.Background()
+	old := time.Now().Add(-2 * ORPHAN_AGE)
+	Posts.Put(&Post{ID: "kept", UserID: "u", Image: "link", Created: old}, c)
+	Posts.Put(&Post{ID: "imageless", UserID: "u", Created: old}, c)
+	Posts.Put(&Post{ID: "new", UserID: "u", Created: time.Now()}, c)
+	trashPost(&Post{ID: "trashed", UserID: "u", Image: "link", Created: old}, "", "u", c)
+
+	for _, name := range []string{"u/kept", "u/kept_thumb", "u/gone", "u/gone_view", "u/imageless", "u/trashed", "notapost"} {
+		imgstore.Write(c, name, "image/gif", strings.NewReader("GIF89a"))
+		os.Chtimes(dir+"/"+name, old, old)
+	}
+	// Too new to be swept, even though no post links to it yet.
+	imgstore.Write(c, "u/new", "image/gif", strings.NewReader("GIF89a"))
+
+	// Exports are only swept once they expire.
+	expired := time.Now().Add(-2 * EXPORT_TTL)
+	for _, name := range []string{"exports/u/old.zip", "exports/u/recent.zip"} {
+		imgstore.Write(c, name, "application/zip", strings.NewReader("PK"))
+	}
+	os.Chtimes(dir+"/exports/u/old.zip", expired, expired)
+
+	for _, dryRun := range []bool{true, false} {
+		queue := &waitingQueue{}
+		queue.LocalQueue = tasks.NewLocalQueue(map[string]http.Handler{"default": queue.handler(SweepTask)})
+		queue.RetryLimit = 0
+		tasks.Default = queue
+
+		queue.Add(c, DELETION_QUEUE, "/t/sweep", url.Values{"dryRun": {strconv.FormatBool(dryRun)}})
+		queue.wg.Wait()
+
+		var remaining []string
+		objs, _, _ := imgstore.List(c, "", "", 100)
+		for _, obj := range objs {
+			remaining = append(remaining, obj.Name)
+		}
+
+		want := "exports/u/old.zip exports/u/recent.zip notapost u/gone u/gone_view u/imageless u/kept u/kept_thumb u/new u/trashed"
+		if !dryRun {
+			want = "exports/u/recent.zip notapost u/kept u/kept_thumb u/new u/trashed"
+		}
+		if got := strings.Join(remaining, " "); got != want {
+			t.Errorf("With dry run %v, %q remain. Wanted %q.", dryRun, got, want)
+		}
+
+		_, err := Posts.Get("imageless", c)
+		if dryRun != (err == nil) {
+			t.Errorf("With dry run %v, Get() of the post without an image returned %v.", dryRun, err)
+		}
+		if _, err := Posts.Get("new", c); err != nil {
+			t.Errorf("With dry run %v, a new post without an image was deleted.", dryRun)
+		}
+	}
+}
+
+// waitingQueue is a LocalQueue which can wait for all of the tasks added to it, including
+// tasks added by other tasks, to be done. Failed tasks must not be retried.
+type waitingQueue struct {
+	*tasks.LocalQueue
+	wg sync.WaitGroup
+}
+
+func (q *waitingQueue) Add(c context.Context, queueName, path string, params url.Values) error {
+	q.wg.Add(1)
+	err := q.LocalQueue.Add(c, queueName, path, params)
+	if err != nil {
+		q.wg.Done()
+	}
+	return err
+}
+
+func (q *waitingQueue) handler(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer q.wg.Done()
+		h(w, r)
+	})
+}
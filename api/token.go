@@ -0,0 +1,275 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/log"
+
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const TOKEN_KIND = "accessToken"
+
+// TOKEN_PREFIX starts every personal access token, so that tokens can be told apart from
+// other bearer credentials, and found by secret scanners.
+const TOKEN_PREFIX = "ggp_"
+
+// Scopes of a token. Read tokens can only make GET and HEAD requests.
+const (
+	TOKEN_READ  = "read"
+	TOKEN_WRITE = "write"
+)
+
+// Most tokens a user may have at once.
+const MAX_TOKENS = 50
+const MAX_TOKEN_NAME_LENGTH = 100
+
+// LastUsed is only updated when it is older than this, so that a busy client does not
+// store its token on every request.
+const TOKEN_USE_PRECISION = time.Minute
+
+// An AccessToken lets API clients act as a user without signing in, by sending the token
+// as an "Authorization: Bearer" credential. Only the SHA-256 hash of its secret is stored.
+type AccessToken struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	Name   string `json:"name"`
+	Scope  string `json:"scope"`
+	Hash   string `json:"-"`
+	// Token is the credential itself, which is only set in the response to its creation.
+	Token string `json:"token,omitempty" datastore:"-"`
+
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+type TokenResponse struct {
+	Ok   bool        `json:"ok"`
+	Data AccessToken `json:"data"`
+}
+
+type TokenListResponse struct {
+	Ok   bool          `json:"ok"`
+	Data []AccessToken `json:"data"`
+}
+
+// ListTokens responds with the signed in user's tokens, newest first.
+func ListTokens(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	userID, ok := tokenOwner(w, r, c)
+	if !ok {
+		return
+	}
+
+	tokens, err := Tokens.ByUser(userID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch tokens of user %v: %v", userID, err)
+		http.Error(w, "Failed to fetch tokens.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := TokenListResponse{true, tokens}
+	sendJsonResponse(w, resp)
+}
+
+// CreateToken creates a token for the signed in user, with the name and scope in the
+// request body, and responds with it. The response is the only time the token is shown.
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	userID, ok := tokenOwner(w, r, c)
+	if !ok {
+		return
+	}
+
+	token := new(AccessToken)
+	if err := readEntity(r, token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" || len(token.Name) > MAX_TOKEN_NAME_LENGTH {
+		http.Error(w, "A token needs a name of up to 100 characters.", http.StatusBadRequest)
+		return
+	}
+	if token.Scope != TOKEN_READ && token.Scope != TOKEN_WRITE {
+		http.Error(w, `Scope must be "read" or "write".`, http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := Tokens.ByUser(userID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch tokens of user %v: %v", userID, err)
+		http.Error(w, "Failed to create token.", http.StatusInternalServerError)
+		return
+	}
+	if len(tokens) >= MAX_TOKENS {
+		http.Error(w, "You have too many tokens. Revoke one first.", http.StatusConflict)
+		return
+	}
+
+	token.ID = IDs.Next().String()
+	token.UserID = userID
+	token.Created = time.Now()
+	token.LastUsed = time.Time{}
+	secret := newTokenSecret()
+	token.Hash = hashTokenSecret(secret)
+
+	if err := Tokens.Put(token, c); err != nil {
+		log.Errorf(c, "Failed to store token for user %v: %v", userID, err)
+		http.Error(w, "Failed to create token.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Created %v token %v for user %v.", token.Scope, token.ID, userID)
+
+	token.Token = TOKEN_PREFIX + token.ID + "_" + secret
+	resp := TokenResponse{true, *token}
+	w.WriteHeader(http.StatusCreated)
+	sendJsonResponse(w, resp)
+}
+
+// RevokeToken deletes one of the signed in user's tokens, which stops working at once.
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	userID, ok := tokenOwner(w, r, c)
+	if !ok {
+		return
+	}
+
+	tokenID := GetRequestVar(r, "id", c)
+	token, err := Tokens.Get(tokenID, c)
+	if err == ErrNoSuchEntity || (err == nil && token.UserID != userID) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch token %v: %v", tokenID, err)
+		http.Error(w, "Failed to revoke token.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := Tokens.Delete(tokenID, c); err != nil {
+		log.Errorf(c, "Failed to delete token %v: %v", tokenID, err)
+		http.Error(w, "Failed to revoke token.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Revoked token %v of user %v.", tokenID, userID)
+	sendJsonResponse(w, OkResponse{true})
+}
+
+// tokenOwner returns the ID of the user named in the request URL, responding with an
+// error if it is not the signed in user, whose tokens are the only ones they can manage.
+func tokenOwner(w http.ResponseWriter, r *http.Request, c context.Context) (string, bool) {
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to manage tokens: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return "", false
+	}
+
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+	appUser, err := FetchAppUserByName(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.NotFound(w, r)
+		return "", false
+	}
+
+	if !Can(currentUser, ACTION_MANAGE_TOKENS, appUser) {
+		log.Infof(c, "%v cannot manage the tokens of user %v.", currentUser.ID, appUser.ID)
+		http.Error(w, "You cannot manage another user's tokens.", http.StatusForbidden)
+		return "", false
+	}
+
+	return appUser.ID, true
+}
+
+// AccessTokens authenticates requests made with a personal access token. Bearer
+// credentials which do not start with TOKEN_PREFIX are left for other authenticators.
+type AccessTokens struct{}
+
+func (AccessTokens) Authenticate(r *http.Request) (*auth.Identity, error) {
+	credential := auth.BearerToken(r)
+	if !strings.HasPrefix(credential, TOKEN_PREFIX) {
+		return nil, nil
+	}
+
+	// Tokens are the token's ID and secret, separated by an underscore. IDs are hex, so
+	// the first underscore ends the ID.
+	credential = strings.TrimPrefix(credential, TOKEN_PREFIX)
+	i := strings.Index(credential, "_")
+	if i <= 0 {
+		return nil, auth.ErrInvalidCredentials
+	}
+	tokenID, secret := credential[:i], credential[i+1:]
+
+	c := NewContext(r)
+	token, err := Tokens.Get(tokenID, c)
+	if err == ErrNoSuchEntity {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashTokenSecret(secret))) != 1 {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	if now := time.Now(); now.Sub(token.LastUsed) >= TOKEN_USE_PRECISION {
+		if err := Tokens.Touch(token.ID, now, c); err != nil && err != ErrNoSuchEntity {
+			log.Warningf(c, "Failed to record use of token %v: %v", token.ID, err)
+		}
+	}
+
+	return &auth.Identity{ID: token.UserID, ReadOnly: token.Scope != TOKEN_WRITE}, nil
+}
+
+// deleteTokens revokes all of a user's tokens.
+func deleteTokens(userID string, c context.Context) error {
+	tokens, err := Tokens.ByUser(userID, c)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if err := Tokens.Delete(token.ID, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTokenSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func getTokenDSKey(tokenID string, c context.Context) (*datastore.Key, error) {
+	if tokenID == "" {
+		return nil, errors.New("No tokenID provided.")
+	}
+
+	return datastore.NewKey(c, TOKEN_KIND, tokenID, 0, nil), nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/auth"
+)
+
+func TestAccessTokens(t *testing.T) {
+	defer func(users UserStore, tokens TokenStore) {
+		Users, Tokens = users, tokens
+	}(Users, Tokens)
+	Users, Tokens = NewMemoryUserStore(), NewMemoryTokenStore()
+
+	c := context.Background()
+	Users.Create(&AppUser{ID: "u", Username: "someone"}, c)
+	Users.Create(&AppUser{ID: "other", Username: "someoneelse"}, c)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/a/u/{username}/tokens", ListTokens).Methods("GET")
+	r.HandleFunc("/a/u/{username}/tokens", CreateToken).Methods("POST")
+	r.HandleFunc("/a/u/{username}/tokens/{id}", RevokeToken).Methods("DELETE")
+
+	serveAs := func(userID, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(auth.NewContext(req.Context(), &auth.Identity{ID: userID}))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	authenticate := func(token string) (*auth.Identity, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return AccessTokens{}.Authenticate(req)
+	}
+
+	w := serveAs("u", "POST", "/a/u/someone/tokens", `{"name": "Script", "scope": "read"}`)
+	var created TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("CreateToken() returned %v %q.", w.Code, w.Body.String())
+	}
+	token := created.Data
+	if !strings.HasPrefix(token.Token, TOKEN_PREFIX) {
+		t.Errorf("CreateToken() returned token %q.", token.Token)
+	}
+
+	stored, _ := Tokens.Get(token.ID, c)
+	if stored.Hash == "" || strings.Contains(token.Token, stored.Hash) || stored.Token != "" {
+		t.Errorf("Stored token %+v. Wanted only the hash of its secret.", stored)
+	}
+
+	for _, body := range []string{`{"name": "", "scope": "read"}`, `{"name": "Script", "scope": "admin"}`} {
+		if w := serveAs("u", "POST", "/a/u/someone/tokens", body); w.Code != http.StatusBadRequest {
+			t.Errorf("CreateToken() with %v returned %v. Wanted %v.", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := serveAs("other", "POST", "/a/u/someone/tokens", `{"name": "Script", "scope": "write"}`); w.Code != http.StatusForbidden {
+		t.Errorf("CreateToken() for another user returned %v. Wanted %v.", w.Code, http.StatusForbidden)
+	}
+
+	id, err := authenticate(token.Token)
+	if err != nil || id == nil || id.ID != "u" || !id.ReadOnly {
+		t.Errorf("Authenticate() with a read token returned %+v, %v.", id, err)
+	}
+	if stored, _ := Tokens.Get(token.ID, c); stored.LastUsed.IsZero() {
+		t.Error("Authenticate() did not record the token's use.")
+	}
+	if _, err := authenticate(token.Token + "x"); err != auth.ErrInvalidCredentials {
+		t.Errorf("Authenticate() with a wrong secret returned %v. Wanted ErrInvalidCredentials.", err)
+	}
+	if id, err := authenticate("not-a-token"); id != nil || err != nil {
+		t.Errorf("Authenticate() with another kind of bearer token returned %+v, %v. Wanted neither.", id, err)
+	}
+
+	var list TokenListResponse
+	json.Unmarshal(serveAs("u", "GET", "/a/u/someone/tokens", "").Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Name != "Script" || list.Data[0].Token != "" || list.Data[0].LastUsed.IsZero() {
+		t.Errorf("ListTokens() returned %+v.", list.Data)
+	}
+
+	if w := serveAs("other", "DELETE", "/a/u/someoneelse/tokens/"+token.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("RevokeToken() of another user's token returned %v. Wanted %v.", w.Code, http.StatusNotFound)
+	}
+	if w := serveAs("u", "DELETE", "/a/u/someone/tokens/"+token.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("RevokeToken() returned %v.", w.Code)
+	}
+	if _, err := authenticate(token.Token); err != auth.ErrInvalidCredentials {
+		t.Errorf("Authenticate() with a revoked token returned %v. Wanted ErrInvalidCredentials.", err)
+	}
+}
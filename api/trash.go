@@ -0,0 +1,491 @@
+package api
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const TRASH_KIND = "trash"
+
+// Time a deleted post or event stays in the trash, where its owner can restore it, before
+// it is purged for good.
+const TRASH_TTL = 30 * 24 * time.Hour
+
+// A TrashItem holds a deleted Post or Event. Trashed entities are removed from their own
+// stores, so they are left out of every feed and listing without any filtering.
+type TrashItem struct {
+	// Kind is POST_KIND or EVENT_KIND, and ID is the ID of the trashed entity.
+	Kind    string
+	ID      string
+	OwnerID string
+	// TrashedBy is the ID of the user who deleted the entity, who may not be its owner.
+	TrashedBy string
+	// ParentID is the ID of the event a post was trashed along with. Such posts are
+	// restored along with the event, and cannot be restored alone.
+	ParentID string
+	Trashed  time.Time
+	// Data is the trashed entity, encoded as JSON.
+	Data []byte `datastore:",noindex"`
+}
+
+// TrashItemView shows a trashed entity to its owner.
+type TrashItemView struct {
+	Kind     string    `json:"kind"`
+	ID       string    `json:"id"`
+	ParentID string    `json:"parentId,omitempty"`
+	Trashed  time.Time `json:"trashed"`
+	Expires  time.Time `json:"expires"`
+	// Restorable is false for items which were removed by a moderator, or by a host of
+	// their event.
+	Restorable bool   `json:"restorable"`
+	Post       *Post  `json:"post,omitempty"`
+	Event      *Event `json:"event,omitempty"`
+}
+
+type TrashViewPage struct {
+	Items      []TrashItemView `json:"items"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// newTrashItem creates a TrashItem holding entity, which must be a *Post or an *Event.
+func newTrashItem(kind, id, ownerID, parentID, trashedBy string, entity interface{}) (*TrashItem, error) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrashItem{
+		Kind:      kind,
+		ID:        id,
+		OwnerID:   ownerID,
+		TrashedBy: trashedBy,
+		ParentID:  parentID,
+		Trashed:   time.Now(),
+		Data:      data,
+	}, nil
+}
+
+// Key identifies a trashed item among items of both kinds.
+func (item *TrashItem) Key() string {
+	return item.Kind + ":" + item.ID
+}
+
+// Expires returns the time after which the item can no longer be restored.
+func (item *TrashItem) Expires() time.Time {
+	return item.Trashed.Add(TRASH_TTL)
+}
+
+func (item *TrashItem) Post() (*Post, error) {
+	post := new(Post)
+	return post, json.Unmarshal(item.Data, post)
+}
+
+func (item *TrashItem) Event() (*Event, error) {
+	event := new(Event)
+	return event, json.Unmarshal(item.Data, event)
+}
+
+// GetTrash responds with a page of the signed in user's trashed posts and events, most
+// recently trashed first.
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to see the trash: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	req, err := readPageRequest(r, "-Trashed")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := Trash.ByOwner(currentUser.ID, req, c)
+	if err == ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch trash of user %v: %v", currentUser.ID, err)
+		http.Error(w, "Failed to fetch trash.", http.StatusInternalServerError)
+		return
+	}
+
+	resp := TrashViewPage{Items: make([]TrashItemView, 0, len(page.Items)), NextCursor: page.NextCursor}
+	for i := range page.Items {
+		item := &page.Items[i]
+		view := TrashItemView{
+			Kind:     item.Kind,
+			ID:       item.ID,
+			ParentID: item.ParentID,
+			Trashed:  item.Trashed,
+			Expires:  item.Expires(),
+
+			Restorable: canRestore(currentUser, item, c),
+		}
+		if item.Kind == POST_KIND {
+			view.Post, err = item.Post()
+		} else {
+			view.Event, err = item.Event()
+		}
+		if err != nil {
+			log.Errorf(c, "Failed to decode trashed %v %v: %v", item.Kind, item.ID, err)
+			continue
+		}
+		resp.Items = append(resp.Items, view)
+	}
+
+	sendJsonResponse(w, resp)
+}
+
+// RestorePost restores one of the signed in user's trashed posts.
+func RestorePost(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	item, ok := fetchRestorableItem(w, r, POST_KIND, c)
+	if !ok {
+		return
+	}
+
+	if item.ParentID != "" {
+		http.Error(w, "This post was deleted along with its event. Restore the event instead.", http.StatusConflict)
+		return
+	}
+
+	if err := restoreTrashItem(item, c); err != nil {
+		log.Errorf(c, "Failed to restore post %v: %v", item.ID, err)
+		http.Error(w, "Failed to restore post.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Restored post %v.", item.ID)
+
+	resp := OkResponse{true}
+	sendJsonResponse(w, resp)
+}
+
+// RestoreEvent restores one of the signed in user's trashed events. The posts trashed
+// along with it are restored afterwards by tasks on the DELETION_QUEUE.
+func RestoreEvent(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	item, ok := fetchRestorableItem(w, r, EVENT_KIND, c)
+	if !ok {
+		return
+	}
+
+	if err := restoreTrashItem(item, c); err != nil {
+		log.Errorf(c, "Failed to restore event %v: %v", item.ID, err)
+		http.Error(w, "Failed to restore event.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := queueEventPostsRestore(item.ID, c); err != nil {
+		log.Errorf(c, "Failed to queue restore of posts of event %v: %v", item.ID, err)
+		http.Error(w, "Failed to restore the event's posts.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "Restored event %v, its posts will be restored in the background.", item.ID)
+
+	resp := OkResponse{true}
+	sendJsonResponse(w, resp)
+}
+
+// fetchRestorableItem fetches the trashed item named by the request's id var, and checks
+// that the signed in user may restore it and that it has not expired. If not, an error response
+// is sent and false is returned.
+func fetchRestorableItem(w http.ResponseWriter, r *http.Request, kind string, c context.Context) (*TrashItem, bool) {
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to restore a %v: %v", kind, err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return nil, false
+	}
+
+	id := GetRequestVar(r, "id", c)
+	item, err := Trash.Get(kind, id, c)
+	if err == ErrNoSuchEntity || (err == nil && item.OwnerID != currentUser.ID && !canRestore(currentUser, item, c)) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf(c, "Failed to fetch trashed %v %v: %v", kind, id, err)
+		http.Error(w, "Failed to fetch trash.", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !canRestore(currentUser, item, c) {
+		log.Infof(c, "User %v cannot restore %v %v trashed by %v.", currentUser.ID, kind, id, item.TrashedBy)
+		http.Error(w, "This was removed by a moderator or a host, and cannot be restored.", http.StatusForbidden)
+		return nil, false
+	}
+
+	if time.Now().After(item.Expires()) {
+		http.Error(w, "This has been in the trash for too long to restore.", http.StatusGone)
+		return nil, false
+	}
+
+	return item, true
+}
+
+// canRestore reports whether p may restore a trashed item. Besides the users allowed by
+// Can, the creator and co-hosts of an event may restore posts removed from it.
+func canRestore(p *Principal, item *TrashItem, c context.Context) bool {
+	if Can(p, ACTION_RESTORE, item) {
+		return true
+	}
+	if item.Kind != POST_KIND {
+		return false
+	}
+
+	post, err := item.Post()
+	if err != nil {
+		log.Errorf(c, "Failed to decode trashed post %v: %v", item.ID, err)
+		return false
+	}
+
+	return canModerate(p, post.EventID, c)
+}
+
+// TrashEventPostsTask moves a page of a trashed event's posts to the trash, and queues
+// itself again until none are left. It must be called as a task with the eventID form value.
+func TrashEventPostsTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	// Trashed posts leave the listing, so the first page always holds the next posts.
+	eventID := r.FormValue("eventID")
+	page, err := fetchEventPosts(eventID, PageRequest{Order: "Created", Limit: MAX_PAGE_SIZE}, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch posts of event %v: %v", eventID, err)
+		http.Error(w, "Failed to fetch posts.", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range page.Items {
+		if err := trashPost(&page.Items[i], eventID, "", c); err != nil {
+			log.Errorf(c, "Failed to trash post %v of event %v: %v", page.Items[i].ID, eventID, err)
+			http.Error(w, "Failed to trash post.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if page.NextCursor != "" {
+		if err := queueEventPostsTrash(eventID, c); err != nil {
+			log.Errorf(c, "Failed to queue trashing more posts of event %v: %v", eventID, err)
+			http.Error(w, "Failed to queue trashing posts.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Infof(c, "Trashed %v posts of event %v.", len(page.Items), eventID)
+}
+
+// RestoreEventPostsTask restores a page of the posts trashed along with an event, and
+// queues itself again until none are left. It must be called as a task with the eventID
+// form value.
+func RestoreEventPostsTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	eventID := r.FormValue("eventID")
+	page, err := Trash.ByParent(eventID, PageRequest{Order: "Trashed", Limit: MAX_PAGE_SIZE}, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch trashed posts of event %v: %v", eventID, err)
+		http.Error(w, "Failed to fetch trash.", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range page.Items {
+		if err := restoreTrashItem(&page.Items[i], c); err != nil {
+			log.Errorf(c, "Failed to restore post %v of event %v: %v", page.Items[i].ID, eventID, err)
+			http.Error(w, "Failed to restore post.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if page.NextCursor != "" {
+		if err := queueEventPostsRestore(eventID, c); err != nil {
+			log.Errorf(c, "Failed to queue restoring more posts of event %v: %v", eventID, err)
+			http.Error(w, "Failed to queue restoring posts.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Infof(c, "Restored %v posts of event %v.", len(page.Items), eventID)
+}
+
+// PurgeTrashTask permanently deletes a page of the items which have been in the trash
+// for longer than TRASH_TTL, along with the images of trashed posts, and queues itself
+// again until none are left. It is started by cron.
+func PurgeTrashTask(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+	if !tasks.IsTaskRequest(r) {
+		http.Error(w, "Not a task request.", http.StatusForbidden)
+		return
+	}
+
+	before := time.Now().Add(-TRASH_TTL)
+	page, err := Trash.Expired(before, PageRequest{Order: "Trashed", Limit: MAX_PAGE_SIZE}, c)
+	if err != nil {
+		log.Errorf(c, "Failed to fetch expired trash: %v", err)
+		http.Error(w, "Failed to fetch trash.", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range page.Items {
+		if err := purgeTrashItem(&page.Items[i], c); err != nil {
+			log.Errorf(c, "Failed to purge trashed %v %v: %v", page.Items[i].Kind, page.Items[i].ID, err)
+			http.Error(w, "Failed to purge trash.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if page.NextCursor != "" {
+		if err := tasks.Add(c, DELETION_QUEUE, "/t/purge-trash", nil); err != nil {
+			log.Errorf(c, "Failed to queue purging more trash: %v", err)
+			http.Error(w, "Failed to queue purging trash.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Infof(c, "Purged %v items from the trash.", len(page.Items))
+}
+
+// trashPost moves a post to the trash. parentID is the ID of the event being trashed
+// along with it, if any, and trashedBy the ID of the user deleting it. The post's image
+// is kept until the post is purged.
+func trashPost(post *Post, parentID, trashedBy string, c context.Context) error {
+	item, err := newTrashItem(POST_KIND, post.ID, post.UserID, parentID, trashedBy, post)
+	if err != nil {
+		return err
+	}
+
+	if err := Trash.Put(item, c); err != nil {
+		return err
+	}
+
+	return deletePost(post.ID, c)
+}
+
+// trashEvent moves an event to the trash. Its posts must be trashed separately.
+func trashEvent(event *Event, trashedBy string, c context.Context) error {
+	item, err := newTrashItem(EVENT_KIND, event.ID, event.Creator, "", trashedBy, event)
+	if err != nil {
+		return err
+	}
+
+	if err := Trash.Put(item, c); err != nil {
+		return err
+	}
+
+	return Events.Delete(event.ID, c)
+}
+
+// restoreTrashItem moves a trashed post or event back to its store.
+func restoreTrashItem(item *TrashItem, c context.Context) error {
+	var err error
+	if item.Kind == POST_KIND {
+		var post *Post
+		if post, err = item.Post(); err == nil {
+			err = savePost(post, c)
+		}
+	} else {
+		// An event which exists again is not overwritten with its older trashed copy.
+		var live bool
+		if live, err = eventExists(item.ID, c); err == nil && !live {
+			var event *Event
+			if event, err = item.Event(); err == nil {
+				err = Events.Put(event, c)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return Trash.Delete(item.Kind, item.ID, c)
+}
+
+// purgeTrashItem permanently deletes a trashed item, along with the images of a post or
+// the members and invite links of an event. An event which exists again, because
+// DeleteEvent put it back, only has its trashed copy deleted.
+func purgeTrashItem(item *TrashItem, c context.Context) error {
+	if item.Kind == EVENT_KIND {
+		live, err := eventExists(item.ID, c)
+		if err != nil {
+			return err
+		}
+		if live {
+			log.Infof(c, "Event %v exists again, deleting only its trashed copy.", item.ID)
+			return Trash.Delete(item.Kind, item.ID, c)
+		}
+
+		if err := deleteEventMembers(item.ID, c); err != nil {
+			return err
+		}
+		if err := deleteInviteLinks(item.ID, c); err != nil {
+			return err
+		}
+	}
+	if item.Kind == POST_KIND {
+		post, err := item.Post()
+		if err != nil {
+			return err
+		}
+		if post.Image != "" {
+			if err := deleteImage(post.ImageName(), c); err != nil {
+				return err
+			}
+		}
+	}
+
+	return Trash.Delete(item.Kind, item.ID, c)
+}
+
+// eventExists reports whether the event is in the event store.
+func eventExists(eventID string, c context.Context) (bool, error) {
+	_, err := Events.Get(eventID, c)
+	if err == ErrNoSuchEntity {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func queueEventPostsTrash(eventID string, c context.Context) error {
+	return tasks.Add(c, DELETION_QUEUE, "/t/trash-event-posts", url.Values{
+		"eventID": {eventID},
+	})
+}
+
+func queueEventPostsRestore(eventID string, c context.Context) error {
+	return tasks.Add(c, DELETION_QUEUE, "/t/restore-event-posts", url.Values{
+		"eventID": {eventID},
+	})
+}
+
+func getTrashDSKey(kind, id string, c context.Context) (*datastore.Key, error) {
+	if id == "" {
+		return nil, errors.New("No trashed entity ID provided.")
+	}
+
+	return datastore.NewKey(c, TRASH_KIND, kind+":"+id, 0, nil), nil
+}
@@ -1,11 +1,11 @@
 package api
 
 import (
-	"appengine"
-	"appengine/datastore"
-	"appengine/user"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 
-	"github.com/gorilla/context"
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/log"
 
 	"errors"
 	"fmt"
@@ -15,7 +15,10 @@ import (
 )
 
 const USER_KIND = "appUser"
-const UserCtxKey ctxKey = 0
+const USERNAME_KIND = "username"
+
+// Shown in place of the username of a user who no longer exists.
+const DELETED_USERNAME = "[deleted]"
 
 type UserResponse struct {
 	Ok   bool    `json:"ok"`
@@ -30,6 +33,16 @@ type AppUser struct {
 	LastName  string    `json:"lastName"`
 	Created   time.Time `json:"created"`
 	Modified  time.Time `json:"modified"`
+
+	// Role is one of ROLE_USER, ROLE_MODERATOR and ROLE_ADMIN. Users registered before
+	// roles were added have none, and are treated as ROLE_USER.
+	Role string `json:"role"`
+}
+
+// A usernameClaim reserves a username for one user. Claims are keyed by the lowercased
+// username, so that a username can be checked and claimed inside a transaction.
+type usernameClaim struct {
+	UserID string
 }
 
 type AppUserView struct {
@@ -38,6 +51,9 @@ type AppUserView struct {
 	LastName  string    `json:"lastName"`
 	Created   time.Time `json:"created"`
 	Posts     []Post    `json:"posts"`
+
+	// MorePosts is the URL of the next page of posts, if there is one.
+	MorePosts string `json:"-"`
 }
 
 func (appUser *AppUser) IsValid() bool {
@@ -49,7 +65,7 @@ func (appUser *AppUser) IsValidRequest() bool {
 	return appUser.Username != ""
 }
 
-func (appUser *AppUser) DSKey(c appengine.Context) (*datastore.Key, error) {
+func (appUser *AppUser) DSKey(c context.Context) (*datastore.Key, error) {
 	userKeyID, err := appUser.DSKeyID(c)
 	if err != nil {
 		return nil, err
@@ -58,68 +74,94 @@ func (appUser *AppUser) DSKey(c appengine.Context) (*datastore.Key, error) {
 	return userKey, nil
 }
 
-func (appUser *AppUser) DSKeyID(c appengine.Context) (string, error) {
+func (appUser *AppUser) DSKeyID(c context.Context) (string, error) {
 	if appUser.ID == "" {
-		c.Warningf("Attempted to create an AppUser entity key with no ID!")
+		log.Warningf(c, "Attempted to create an AppUser entity key with no ID!")
 		return "", errors.New("AppUser has no ID!")
 	}
 
 	return "user:" + appUser.ID, nil
 }
 
+// DeleteUser deletes a user's account in the background, and responds with the Job doing
+// it. Users can delete themselves, and admins anyone. The content form value chooses what
+// happens to the user's posts and images: "delete" removes them, and "anonymize", the
+// default, leaves them behind, shown as posted by DELETED_USERNAME. Events created by the
+// user are kept either way.
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	username := GetRequestVar(r, "username", c)
 	username = strings.ToLower(username)
 
-	userID, err := getUserID(username, c)
+	appUser, err := FetchAppUserByName(username, c)
 	if err != nil {
-		c.Infof("Could not find user with username '%v': %v", username, err.Error())
+		log.Infof(c, "Could not find user with username '%v': %v", username, err.Error())
 		http.NotFound(w, r)
 		return
 	}
+	userID := appUser.ID
 
-	currentUser := user.Current(c)
+	currentUser, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to delete a user: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
 
-	if !canDeleteAppUser(userID, currentUser) {
-		c.Errorf("%v cannot delete user %v.", currentUser.ID, userID)
+	if !Can(currentUser, ACTION_DELETE_USER, appUser) {
+		log.Errorf(c, "%v cannot delete user %v.", currentUser.ID, userID)
 		http.Error(w, "You cannot delete another user.", http.StatusForbidden)
 		return
 	}
 
-	c.Infof("Deleting user %v...", userID)
+	job := newJob(JOB_DELETE_USER, userID)
+	switch r.FormValue("content") {
+	case "", "anonymize":
+	case "delete":
+		job.DeleteContent = true
+	default:
+		http.Error(w, `Content must be "delete" or "anonymize".`, http.StatusBadRequest)
+		return
+	}
+
+	log.Infof(c, "Deleting user %v with job %v, deleting content: %v", userID, job.ID, job.DeleteContent)
 
-	err = deleteAppUser(userID, c)
+	err = saveJob(job, c)
 	if err != nil {
-		c.Errorf("Failed to delete user %v: %v", userID, err)
+		log.Errorf(c, "Failed to store job to delete user %v: %v", userID, err)
 		http.Error(w, "Failed to delete user.", http.StatusInternalServerError)
 		return
 	}
 
-	c.Infof("Deleted user %v.", userID)
+	err = queueUserDeletion(job.ID, c)
+	if err != nil {
+		log.Errorf(c, "Failed to queue deletion of user %v: %v", userID, err)
+		http.Error(w, "Failed to delete user.", http.StatusInternalServerError)
+		return
+	}
 
-	resp := OkResponse{true}
+	resp := JobResponse{true, *job}
 	sendJsonResponse(w, resp)
 }
 
 func GetUser(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	username := GetRequestVar(r, "username", c)
 	username = strings.ToLower(username)
 
-	c.Infof("Getting user %v", username)
+	log.Infof(c, "Getting user %v", username)
 	userID, err := getUserID(username, c)
 	if err != nil {
-		c.Infof("Could not find user with username '%v': %v", username, err.Error())
+		log.Infof(c, "Could not find user with username '%v': %v", username, err.Error())
 		http.NotFound(w, r)
 		return
 	}
 
-	c.Infof("User ID is %v", userID)
+	log.Infof(c, "User ID is %v", userID)
 
 	appUser, err := FetchAppUser(userID, c)
 	if err != nil {
-		c.Infof("Could not fetch user '%v': %v", userID, err.Error())
+		log.Infof(c, "Could not fetch user '%v': %v", userID, err.Error())
 		http.NotFound(w, r)
 		return
 	}
@@ -129,199 +171,271 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateUser(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	appUser := new(AppUser)
 	if err := readEntity(r, appUser); err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
 	}
 
 	if !appUser.IsValidRequest() {
-		c.Infof("Got an invalid AppUser request: %+v", appUser)
+		log.Infof(c, "Got an invalid AppUser request: %+v", appUser)
 		http.Error(w, "Invalid user data.", http.StatusBadRequest)
 		return
 	}
 
 	// Copy over data from signed-in user account
-	u := user.Current(c)
+	u, err := getRequestUser(r)
+	if err != nil {
+		log.Infof(c, "Must be signed in to register: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
 	appUser.ID = u.ID
 	appUser.Email = u.Email
+	appUser.Role = ROLE_USER
 
 	// Check if a user already exists for this account
 	existingUser, err := FetchAppUser(u.ID, c)
 	if existingUser != nil {
-		c.Infof("User with ID '%v' already exists. Cannot create a new user with that ID.", u.ID)
+		log.Infof(c, "User with ID '%v' already exists. Cannot create a new user with that ID.", u.ID)
 		http.Error(w, fmt.Sprintf("You already have an account with the username '%v'.", existingUser.Username),
 			http.StatusConflict)
 		return
 	}
 
-	// Verify the username is not already in use
 	appUser.Username = strings.ToLower(appUser.Username)
-	_, err = FetchAppUserByName(appUser.Username, c)
-	if err == nil {
-		c.Infof("Username %v is already in use.", appUser.Username)
-		http.Error(w, fmt.Sprintf("Sorry, the username '%v' is already taken!", appUser.Username), http.StatusConflict)
-		return
-	} else {
-		if err != datastore.ErrNoSuchEntity {
-			c.Errorf("Failed to query for user with existing username %v.\n%v", appUser.Username, err)
-			http.Error(w, "An error occurred during registration.", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	appUser.Created = time.Now()
 	appUser.Modified = appUser.Created
 
 	if !appUser.IsValid() {
-		c.Errorf("Cannot store invalid user object: %+v", appUser)
+		log.Errorf(c, "Cannot store invalid user object: %+v", appUser)
 		http.Error(w, "An error occurred during registration.", http.StatusInternalServerError)
 		return
 	}
 
-	c.Infof("Creating user %v...", appUser.ID)
+	log.Infof(c, "Creating user %v...", appUser.ID)
 
-	_, err = saveAppUser(appUser, c)
+	// The username is claimed as the user is stored, so that two users registering at
+	// the same time cannot both get it.
+	err = createAppUser(appUser, c)
+	if err == ErrUsernameTaken {
+		log.Infof(c, "Username %v is already in use.", appUser.Username)
+		http.Error(w, fmt.Sprintf("Sorry, the username '%v' is already taken!", appUser.Username), http.StatusConflict)
+		return
+	}
+	if err == ErrUserExists {
+		log.Infof(c, "User with ID '%v' already exists. Cannot create a new user with that ID.", u.ID)
+		http.Error(w, "You already have an account.", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
+		return
 	}
 
-	c.Infof("Created user %v.", appUser.ID)
+	log.Infof(c, "Created user %v.", appUser.ID)
 
 	resp := UserResponse{true, *appUser}
 	w.WriteHeader(http.StatusCreated)
 	sendJsonResponse(w, resp)
 }
 
+// UpdateUser replaces the profile of the user named in the request URL. Users can update
+// themselves, and admins anyone. Roles are changed with SetUserRole instead.
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := NewContext(r)
 	appUser := new(AppUser)
 	if err := readEntity(r, appUser); err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
 	}
 
 	if !appUser.IsValidRequest() {
-		c.Infof("Got an invalid AppUser request: %+v", appUser)
+		log.Infof(c, "Got an invalid AppUser request: %+v", appUser)
 		http.Error(w, "Invalid user data.", http.StatusBadRequest)
 		return
 	}
 
-	u := user.Current(c)
-	// Verify the user already exists
-	existingUser, err := FetchAppUser(u.ID, c)
-	if existingUser != nil {
-		appUser.Username = strings.ToLower(appUser.Username)
-		appUser.Created = existingUser.Created
-		appUser.Modified = time.Now()
-	} else {
-		c.Errorf("Cannot update a user '%v'. No such user.", appUser.ID)
-		http.Error(w, fmt.Sprintf("User %v does not exist, cannot update.", appUser.ID), http.StatusBadRequest)
+	u, err := GetRequestPrincipal(r, c)
+	if err != nil {
+		log.Infof(c, "Must be signed in to update a user: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+	existingUser, err := FetchAppUserByName(username, c)
+	if err != nil {
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.NotFound(w, r)
+		return
 	}
 
-	if appUser.ID != u.ID {
-		c.Infof("User %v attempted to modify user %v. Denied.", u.ID, appUser.ID)
+	if !Can(u, ACTION_UPDATE_USER, existingUser) {
+		log.Infof(c, "User %v attempted to modify user %v. Denied.", u.ID, existingUser.ID)
 		http.Error(w, "Not authorized to change another user!", http.StatusForbidden)
 		return
 	}
 
+	appUser.ID = existingUser.ID
+	appUser.Email = existingUser.Email
+	appUser.Role = existingUser.Role
+	appUser.Username = strings.ToLower(appUser.Username)
+	appUser.Created = existingUser.Created
+	appUser.Modified = time.Now()
+
 	if !appUser.IsValid() {
-		c.Errorf("Cannot store invalid user object: %+v", appUser)
+		log.Errorf(c, "Cannot store invalid user object: %+v", appUser)
 		http.Error(w, "An error occurred during user update.", http.StatusInternalServerError)
 		return
 	}
 
-	c.Infof("Updating user %v...", appUser.ID)
+	log.Infof(c, "Updating user %v...", appUser.ID)
 
-	_, err = saveAppUser(appUser, c)
+	err = updateAppUser(appUser, c)
+	if err == ErrUsernameTaken {
+		log.Infof(c, "Username %v is already in use.", appUser.Username)
+		http.Error(w, fmt.Sprintf("Sorry, the username '%v' is already taken!", appUser.Username), http.StatusConflict)
+		return
+	}
 	if err != nil {
-		handleError(w, err, &c)
+		handleError(w, err, c)
+		return
 	}
 
-	c.Infof("Updated user %v.", appUser.ID)
+	log.Infof(c, "Updated user %v.", appUser.ID)
 
 	resp := UserResponse{true, *appUser}
 	sendJsonResponse(w, resp)
 }
 
-func FetchAppUser(userID string, c appengine.Context) (*AppUser, error) {
-	appUser := new(AppUser)
-	userKey, err := getUserDSKey(userID, c)
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// SetUserRole gives the user named in the request URL the role in the request body. Only
+// admins can change roles.
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	c := NewContext(r)
+
+	currentUser, err := GetRequestPrincipal(r, c)
 	if err != nil {
-		return nil, err
+		log.Infof(c, "Must be signed in to change a role: %v", err)
+		http.Error(w, "Not signed in.", http.StatusForbidden)
+		return
+	}
+
+	req := new(roleRequest)
+	if err := readEntity(r, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ValidRole(req.Role) {
+		http.Error(w, `Role must be "user", "moderator" or "admin".`, http.StatusBadRequest)
+		return
 	}
 
-	err = datastore.Get(c, userKey, appUser)
+	username := strings.ToLower(GetRequestVar(r, "username", c))
+	appUser, err := FetchAppUserByName(username, c)
 	if err != nil {
-		return nil, err
-	} else {
-		return appUser, nil
+		log.Infof(c, "Could not find user with username '%v': %v", username, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if !Can(currentUser, ACTION_SET_ROLE, appUser) {
+		log.Infof(c, "User %v cannot change the role of user %v.", currentUser.ID, appUser.ID)
+		http.Error(w, "Only admins can change roles.", http.StatusForbidden)
+		return
+	}
+
+	appUser.Role = req.Role
+	appUser.Modified = time.Now()
+	if err := updateAppUser(appUser, c); err != nil {
+		handleError(w, err, c)
+		return
 	}
+
+	log.Infof(c, "User %v gave user %v the role %v.", currentUser.ID, appUser.ID, appUser.Role)
+
+	resp := UserResponse{true, *appUser}
+	sendJsonResponse(w, resp)
 }
 
-func FetchAppUserByName(username string, c appengine.Context) (*AppUser, error) {
-	q := datastore.NewQuery(USER_KIND).
-		Filter("Username =", username)
+func FetchAppUser(userID string, c context.Context) (*AppUser, error) {
+	return Users.Get(userID, c)
+}
 
-	for r := q.Run(c); ; {
-		var u AppUser
-		_, err := r.Next(&u)
-		if err == datastore.Done {
-			return nil, datastore.ErrNoSuchEntity
+// FetchAppUsers looks up many users in one call to the store. Each ID is looked up once,
+// however often it is repeated, and users that do not exist are left out of the result.
+func FetchAppUsers(userIDs []string, c context.Context) (map[string]*AppUser, error) {
+	unique := make([]string, 0, len(userIDs))
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			unique = append(unique, userID)
 		}
-		if err != nil {
-			c.Errorf("Query failed to fetch AppUser with username '%v'\n%v", username, err)
-			return nil, err
-		}
-
-		return &u, nil
 	}
-}
 
-func deleteAppUser(userID string, c appengine.Context) error {
-	userKey, err := getUserDSKey(userID, c)
-	if err != nil {
-		return err
+	if len(unique) == 0 {
+		return map[string]*AppUser{}, nil
 	}
 
-	err = datastore.Delete(c, userKey)
-	return err
+	return Users.GetMulti(unique, c)
 }
 
-func saveAppUser(appUser *AppUser, c appengine.Context) (*datastore.Key, error) {
-	userKey, err := getUserDSKey(appUser.ID, c)
+// FetchUsernames returns the usernames of the users with the given IDs, looked up with
+// FetchAppUsers. Users that no longer exist are named DELETED_USERNAME.
+func FetchUsernames(userIDs []string, c context.Context) (map[string]string, error) {
+	appUsers, err := FetchAppUsers(userIDs, c)
 	if err != nil {
+		log.Errorf(c, "Failed to fetch users: %v", err)
 		return nil, err
 	}
 
-	key, err := datastore.Put(c, userKey, appUser)
-	if err != nil {
-		return nil, err
-	} else {
-		return key, nil
+	usernames := make(map[string]string, len(userIDs))
+	for _, userID := range userIDs {
+		if appUser, ok := appUsers[userID]; ok {
+			usernames[userID] = appUser.Username
+		} else {
+			usernames[userID] = DELETED_USERNAME
+		}
 	}
+
+	return usernames, nil
 }
 
-func getUserID(username string, c appengine.Context) (string, error) {
-	q := datastore.NewQuery(USER_KIND).
-		Filter("Username =", username).
-		Project("ID")
+func FetchAppUserByName(username string, c context.Context) (*AppUser, error) {
+	return Users.GetByName(username, c)
+}
 
-	for r := q.Run(c); ; {
-		var u AppUser
-		_, err := r.Next(&u)
-		if err == datastore.Done {
-			return "", errors.New("No user with username " + username)
-		}
-		if err != nil {
-			c.Warningf("Failed to look up AppUser with username '%v'\n", username)
-			return "", err
-		}
+func deleteAppUser(userID string, c context.Context) error {
+	return Users.Delete(userID, c)
+}
 
-		return u.ID, nil
+func createAppUser(appUser *AppUser, c context.Context) error {
+	return Users.Create(appUser, c)
+}
+
+func updateAppUser(appUser *AppUser, c context.Context) error {
+	return Users.Update(appUser, c)
+}
+
+func getUserID(username string, c context.Context) (string, error) {
+	appUser, err := Users.GetByName(username, c)
+	if err == ErrNoSuchEntity {
+		return "", errors.New("No user with username " + username)
 	}
+	if err != nil {
+		log.Warningf(c, "Failed to look up AppUser with username '%v'\n", username)
+		return "", err
+	}
+
+	return appUser.ID, nil
 }
 
-func getUserDSKey(userID string, c appengine.Context) (*datastore.Key, error) {
+func getUserDSKey(userID string, c context.Context) (*datastore.Key, error) {
 	if userID == "" {
 		return nil, errors.New("No userID provided.")
 	}
@@ -331,28 +445,63 @@ func getUserDSKey(userID string, c appengine.Context) (*datastore.Key, error) {
 	return userKey, nil
 }
 
-func createUserKeyID(userID string, c appengine.Context) string {
+func createUserKeyID(userID string, c context.Context) string {
 	if userID == "" {
-		c.Errorf("Creating an appUser entity key with no userID!")
+		log.Errorf(c, "Creating an appUser entity key with no userID!")
 	}
 
 	return "user:" + userID
 }
 
-func canDeleteAppUser(userID string, currentUser *user.User) bool {
-	return userID == currentUser.ID
+// getRequestUser returns the identity of the signed in user, which middleware.Authorize
+// stores in the request context.
+func getRequestUser(r *http.Request) (*auth.Identity, error) {
+	u := auth.FromContext(r.Context())
+	if u == nil {
+		return nil, errors.New("No user signed in.")
+	}
+
+	return u, nil
 }
 
-func getRequestUser(r *http.Request) (*user.User, error) {
-	val, ok := context.GetOk(r, UserCtxKey)
-	if !ok {
-		return nil, errors.New("No user signed in.")
+func usernameDSKey(username string, c context.Context) *datastore.Key {
+	return datastore.NewKey(c, USERNAME_KIND, strings.ToLower(username), 0, nil)
+}
+
+// claimUsername reserves a username for a user, unless it is claimed by another user.
+// It must be called in a transaction.
+func claimUsername(username, userID string, c context.Context) error {
+	key := usernameDSKey(username, c)
+
+	var claim usernameClaim
+	err := datastore.Get(c, key, &claim)
+	if err == nil && claim.UserID != userID {
+		return ErrUsernameTaken
+	}
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
 	}
 
-	u, ok := val.(*user.User)
-	if !ok {
-		return nil, errors.New("No user signed in.")
+	_, err = datastore.Put(c, key, &usernameClaim{UserID: userID})
+	return err
+}
+
+// releaseUsername removes a user's claim on a username. It must be called in a transaction.
+func releaseUsername(username, userID string, c context.Context) error {
+	key := usernameDSKey(username, c)
+
+	var claim usernameClaim
+	err := datastore.Get(c, key, &claim)
+	if err == datastore.ErrNoSuchEntity || (err == nil && claim.UserID != userID) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return u, nil
+	return datastore.Delete(c, key)
+}
+
+func sameUsername(a, b string) bool {
+	return strings.ToLower(a) == strings.ToLower(b)
 }
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// countingUserStore counts the calls to GetMulti and the IDs passed to it.
+type countingUserStore struct {
+	*MemoryUserStore
+	calls int
+	ids   []string
+}
+
+func (s *countingUserStore) GetMulti(userIDs []string, c context.Context) (map[string]*AppUser, error) {
+	s.calls++
+	s.ids = append(s.ids, userIDs...)
+	return s.MemoryUserStore.GetMulti(userIDs, c)
+}
+
+func TestFetchUsernames(t *testing.T) {
+	store := &countingUserStore{MemoryUserStore: NewMemoryUserStore()}
+	store.Create(&AppUser{ID: "1", Username: "one"}, nil)
+	store.Create(&AppUser{ID: "2", Username: "two"}, nil)
+
+	defer func(users UserStore) { Users = users }(Users)
+	Users = store
+
+	usernames, err := FetchUsernames([]string{"1", "2", "1", "3", "2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.calls != 1 || len(store.ids) != 3 {
+		t.Errorf("FetchUsernames() made %v calls for IDs %v. Wanted 1 call for 3 IDs.", store.calls, store.ids)
+	}
+
+	want := map[string]string{"1": "one", "2": "two", "3": DELETED_USERNAME}
+	for userID, username := range want {
+		if usernames[userID] != username {
+			t.Errorf("FetchUsernames() named user %v %q. Wanted %q.", userID, usernames[userID], username)
+		}
+	}
+}
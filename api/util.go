@@ -1,32 +1,30 @@
 package api
 
 import (
-	"appengine"
-	"appengine/datastore"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
 
 	"github.com/gorilla/mux"
-	"github.com/nu7hatch/gouuid"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/uid"
 
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
 )
 
-const EPOCH = 1420070400000 // Jan 1 2015 midnight GMT
-
-type ctxKey int
+// IDs generates the IDs of new events and posts. Every process creating entities must
+// use a generator with its own node ID, or IDs may collide.
+var IDs, _ = uid.NewGenerator(0)
 
 type OkResponse struct {
 	Ok bool `json:"ok"`
 }
 
 type HasCustomDatastoreKey interface {
-	DSKey(appengine.Context) (*datastore.Key, error)
-	DSKeyID(appengine.Context) (string, error)
+	DSKey(context.Context) (*datastore.Key, error)
+	DSKeyID(context.Context) (string, error)
 }
 
 func requestVarProvided(r *http.Request, varName string) bool {
@@ -35,42 +33,17 @@ func requestVarProvided(r *http.Request, varName string) bool {
 	return ok
 }
 
-func GetRequestVar(r *http.Request, varName string, c appengine.Context) string {
+func GetRequestVar(r *http.Request, varName string, c context.Context) string {
 	vars := mux.Vars(r)
 	value, ok := vars[varName]
 
 	if !ok {
-		c.Infof("No var '%v' present in request URL.", varName)
+		log.Infof(c, "No var '%v' present in request URL.", varName)
 	}
 
 	return value
 }
 
-func NewUID(c appengine.Context) (string, error) {
-	var id uint64
-	millis := time.Now().UnixNano() / 1000000
-	millis -= EPOCH
-
-	id = uint64(millis) << 16
-
-	random, err := uuid.NewV4()
-	if err != nil {
-		return "", err
-	}
-
-	randomStr := strings.Replace(random.String(), "-", "", -1)
-	hexStr := randomStr[23:27]
-	val, err := strconv.ParseInt(hexStr, 16, 32)
-	if err != nil {
-		return "", nil
-	}
-
-	num := uint16(val)
-	id |= (uint64(num) % 65536)
-
-	return strconv.FormatUint(id, 16), nil
-}
-
 // ReadEntity reads a JSON value into entity from a Request body.
 // An error is returned if the body cannot be read into entity.
 func readEntity(r *http.Request, entity interface{}) error {
@@ -101,9 +74,9 @@ func sendJsonResponse(w http.ResponseWriter, resp interface{}) {
 }
 
 // HandleError logs and returns an error in a given HTTP response.
-func handleError(w http.ResponseWriter, err error, c *appengine.Context) {
+func handleError(w http.ResponseWriter, err error, c context.Context) {
 	if c != nil {
-		(*c).Errorf("Error: %v", err)
+		log.Errorf(c, "Error: %v", err)
 	}
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
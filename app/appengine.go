@@ -0,0 +1,62 @@
+//go:build appengine
+// +build appengine
+
+package app
+
+import (
+	"net/http"
+	"os"
+	"sync"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/cache"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/middleware"
+	"github.com/reedperry/gogram/uid"
+)
+
+const NODE_KIND = "idNode"
+
+func init() {
+	mc := &cache.Memcache{Prefix: "gogram/"}
+	api.Users = api.NewCachedUserStore(api.Users, mc)
+	api.Events = api.NewCachedEventStore(api.Events, mc)
+	api.Posts = api.NewCachedPostStore(api.Posts, mc)
+	api.InviteKey = []byte(os.Getenv("GOGRAM_INVITE_KEY"))
+
+	http.Handle("/", assignNode(middleware.Authorize(Router())))
+}
+
+// assignNode gives this instance its own ID generator node before it handles its first
+// request. Datastore allocates IDs in sequence, so instances get different nodes unless
+// more than uid.MaxNode instances start while the first one is still running.
+func assignNode(h http.Handler) http.Handler {
+	var once sync.Once
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			c := appengine.NewContext(r)
+
+			low, _, err := datastore.AllocateIDs(c, NODE_KIND, nil, 1)
+			if err != nil {
+				log.Criticalf(c, "Failed to allocate an ID generator node, using node 0: %v", err)
+				return
+			}
+
+			node := int(low % (uid.MaxNode + 1))
+			ids, err := uid.NewGenerator(node)
+			if err != nil {
+				log.Criticalf(c, "Failed to create ID generator for node %v: %v", node, err)
+				return
+			}
+
+			api.IDs = ids
+			log.Infof(c, "Generating IDs as node %v.", node)
+		})
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -13,9 +13,15 @@ func Router() *mux.Router {
 	r.HandleFunc("/a/u/{username}", api.GetUser).Methods("GET")
 	r.HandleFunc("/a/u/{username}", api.UpdateUser).Methods("PUT")
 	r.HandleFunc("/a/u/{username}", api.DeleteUser).Methods("DELETE")
+	r.HandleFunc("/a/u/{username}/role", api.SetUserRole).Methods("PUT")
+	r.HandleFunc("/a/u/{username}/posts", api.UserPosts).Methods("GET")
+	r.HandleFunc("/a/u/{username}/tokens", api.ListTokens).Methods("GET")
+	r.HandleFunc("/a/u/{username}/tokens", api.CreateToken).Methods("POST")
+	r.HandleFunc("/a/u/{username}/tokens/{id}", api.RevokeToken).Methods("DELETE")
 
 	r.HandleFunc("/a/p", api.CreatePost).Methods("POST")
 	r.HandleFunc("/a/p/{id}/attach", api.AttachImage).Methods("POST")
+	r.HandleFunc("/a/p/{id}/restore", api.RestorePost).Methods("POST")
 	r.HandleFunc("/a/p/{id}", api.GetPost).Methods("GET")
 	r.HandleFunc("/a/p/{id}", api.DeletePost).Methods("DELETE")
 	r.HandleFunc("/a/p/{id}", api.UpdatePost).Methods("PUT")
@@ -24,9 +30,39 @@ func Router() *mux.Router {
 	r.HandleFunc("/a/e/{id}", api.GetEvent).Methods("GET")
 	r.HandleFunc("/a/e/{id}", api.DeleteEvent).Methods("DELETE")
 	r.HandleFunc("/a/e/{id}", api.UpdateEvent).Methods("PUT")
+	r.HandleFunc("/a/e/{id}/posts", api.EventPosts).Methods("GET")
+	r.HandleFunc("/a/e/{id}/restore", api.RestoreEvent).Methods("POST")
+	r.HandleFunc("/a/e/{id}/members", api.ListMembers).Methods("GET")
+	r.HandleFunc("/a/e/{id}/members", api.InviteMember).Methods("POST")
+	r.HandleFunc("/a/e/{id}/members/{username}", api.RemoveMember).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/invite/accept", api.AcceptInvite).Methods("POST")
+	r.HandleFunc("/a/e/{id}/invite/decline", api.DeclineInvite).Methods("POST")
+	r.HandleFunc("/a/e/{id}/links", api.ListInviteLinks).Methods("GET")
+	r.HandleFunc("/a/e/{id}/links", api.CreateInviteLink).Methods("POST")
+	r.HandleFunc("/a/e/{id}/links/{linkID}", api.RevokeInviteLink).Methods("DELETE")
+	r.HandleFunc("/a/e/{id}/join", api.JoinEvent).Methods("POST")
+	r.HandleFunc("/a/e/{id}/cohosts", api.ListCoHosts).Methods("GET")
+	r.HandleFunc("/a/e/{id}/cohosts", api.AddCoHost).Methods("POST")
+	r.HandleFunc("/a/e/{id}/cohosts/{username}", api.RemoveCoHost).Methods("DELETE")
+	r.HandleFunc("/a/invites", api.ListInvites).Methods("GET")
+	r.HandleFunc("/a/stats/cache", api.CacheStats).Methods("GET")
+	r.HandleFunc("/a/jobs/{id}", api.GetJob).Methods("GET")
+	r.HandleFunc("/a/trash", api.GetTrash).Methods("GET")
+	r.HandleFunc("/a/exports", api.CreateExport).Methods("POST")
+	r.HandleFunc("/a/exports/{id}", api.DownloadExport).Methods("GET")
+
 	r.HandleFunc("/a/feed/e", api.EventsFeed).Methods("GET")
-	r.HandleFunc("/a/feed/e/{page}", api.EventsFeed).Methods("GET")
-	r.HandleFunc("/a/feed/e/{order}/{page}", api.EventsFeed).Methods("GET")
+	r.HandleFunc("/a/feed/e/{page}", api.EventsFeedByPage).Methods("GET")
+	r.HandleFunc("/a/feed/e/{order}/{page}", api.EventsFeedByPage).Methods("GET")
+
+	r.HandleFunc("/t/trash-event-posts", api.TrashEventPostsTask).Methods("POST")
+	r.HandleFunc("/t/restore-event-posts", api.RestoreEventPostsTask).Methods("POST")
+	r.HandleFunc("/t/delete-user", api.DeleteUserTask).Methods("POST")
+	r.HandleFunc("/t/delete-image", api.DeleteImageTask).Methods("POST")
+	r.HandleFunc("/t/export-user", api.ExportUserTask).Methods("POST")
+	// Cron requests are GET requests.
+	r.HandleFunc("/t/sweep", api.SweepTask).Methods("GET", "POST")
+	r.HandleFunc("/t/purge-trash", api.PurgeTrashTask).Methods("GET", "POST")
 
 	r.HandleFunc("/", ServeEventFeed).Methods("GET")
 	r.HandleFunc("/e/{id}", ServeEvent).Methods("GET")
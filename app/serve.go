@@ -1,23 +1,24 @@
 package app
 
 import (
-	"appengine"
-
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
 
 	"github.com/reedperry/gogram/api"
-	"github.com/reedperry/gogram/middleware"
+	"github.com/reedperry/gogram/log"
 )
 
-func init() {
-	http.Handle("/", middleware.Authorize(Router()))
-}
+// Dir is the directory holding index.html and the page templates. On App Engine this is the
+// app's own directory, which is the working directory while serving.
+var Dir = "."
 
 func ServeEventFeed(w http.ResponseWriter, r *http.Request) {
-	content, err := ioutil.ReadFile("index.html")
+	content, err := ioutil.ReadFile(filepath.Join(Dir, "index.html"))
 	if err != nil {
 		fmt.Fprint(w, "index.html not found!")
 		return
@@ -28,16 +29,16 @@ func ServeEventFeed(w http.ResponseWriter, r *http.Request) {
 }
 
 func ServeEvent(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := api.NewContext(r)
 
 	id := api.GetRequestVar(r, "id", c)
 	if id == "" {
 		http.Error(w, "Missing event ID.", http.StatusBadRequest)
 	}
 
-	t, err := template.ParseFiles("templates/event.html")
+	t, err := template.ParseFiles(filepath.Join(Dir, "templates/event.html"))
 	if err != nil {
-		c.Errorf("Failed to parse event template: %v", err)
+		log.Errorf(c, "Failed to parse event template: %v", err)
 		http.Error(w, "Failed to load event.", http.StatusInternalServerError)
 		return
 	}
@@ -48,42 +49,56 @@ func ServeEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := api.FetchEventPosts(event.ID, c)
+	req, err := api.ReadPostPageRequest(r)
 	if err != nil {
-		http.Error(w, "Failed to fetch posts for event.", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	eventPosts := make([]api.PostView, 0, 20)
-	for _, post := range *posts {
-
-		// Fill in current username for found posts
-		appUser, err := api.FetchAppUser(post.UserID, c)
-		var username = "[deleted]"
-		if err != nil {
-			c.Infof("No user found for post %v", post.ID)
-		} else {
-			username = appUser.Username
+	// Opening an invite link makes the user a member before the event is shown.
+	p, _ := api.GetRequestPrincipal(r, c)
+	if invite := r.FormValue("invite"); invite != "" {
+		if err := api.RedeemInviteLink(event, p, invite, c); err != nil {
+			http.Error(w, err.Error(), api.InviteErrorStatus(err))
+			return
 		}
+	}
+
+	posts, err := api.FetchEventPosts(event, p, req, c)
+	if _, ok := err.(*api.ErrPrivateEvent); ok {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err == api.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch posts for event.", http.StatusInternalServerError)
+		return
+	}
 
-		eventPosts = append(eventPosts, api.PostView{
-			Username: username,
-			ID:       post.ID,
-			EventID:  post.EventID,
-			Image:    post.Image,
-			Text:     post.Text,
-			Created:  post.Created,
-			Modified: post.Modified,
-		})
+	// Look up the authors of all posts, the event creator and co-hosts at once.
+	userIDs := append([]string{event.Creator}, event.CoHosts...)
+	for _, post := range posts.Items {
+		userIDs = append(userIDs, post.UserID)
 	}
 
-	// Fill in current username for event creator
-	appUser, err := api.FetchAppUser(event.Creator, c)
-	var creator = "[deleted]"
+	usernames, err := api.FetchUsernames(userIDs, c)
 	if err != nil {
-		c.Infof("No user found for event %v", event.ID)
-	} else {
-		creator = appUser.Username
+		http.Error(w, "Failed to fetch posts for event.", http.StatusInternalServerError)
+		return
+	}
+
+	eventPosts := make([]api.PostView, 0, len(posts.Items))
+	for i := range posts.Items {
+		post := &posts.Items[i]
+		eventPosts = append(eventPosts, api.NewPostView(post, usernames[post.UserID]))
+	}
+
+	coHosts := make([]string, len(event.CoHosts))
+	for i, id := range event.CoHosts {
+		coHosts[i] = usernames[id]
 	}
 
 	data := &api.EventView{
@@ -91,10 +106,12 @@ func ServeEvent(w http.ResponseWriter, r *http.Request) {
 		Description: event.Description,
 		Start:       event.Start,
 		End:         event.End,
-		Creator:     creator,
+		Creator:     usernames[event.Creator],
+		CoHosts:     coHosts,
 		Created:     event.Created,
 		Modified:    event.Modified,
 		Posts:       eventPosts,
+		MorePosts:   morePosts(req, posts),
 	}
 
 	t.Execute(w, data)
@@ -103,16 +120,16 @@ func ServeEvent(w http.ResponseWriter, r *http.Request) {
 }
 
 func ServeUser(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := api.NewContext(r)
 
 	username := api.GetRequestVar(r, "username", c)
 	if username == "" {
 		http.Error(w, "Missing username.", http.StatusBadRequest)
 	}
 
-	t, err := template.ParseFiles("templates/user.html")
+	t, err := template.ParseFiles(filepath.Join(Dir, "templates/user.html"))
 	if err != nil {
-		c.Errorf("Failed to parse user template: %v", err)
+		log.Errorf(c, "Failed to parse user template: %v", err)
 		http.Error(w, "Failed to load user.", http.StatusInternalServerError)
 		return
 	}
@@ -123,7 +140,25 @@ func ServeUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := api.FetchUserPosts(appUser.ID, c)
+	req, err := api.ReadPostPageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	posts, err := api.FetchUserPosts(appUser.ID, req, c)
+	if err == api.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch posts for user.", http.StatusInternalServerError)
+		return
+	}
+
+	// Posts to private events the user cannot view are left out of the page.
+	p, _ := api.GetRequestPrincipal(r, c)
+	visible, err := api.VisiblePosts(posts.Items, p, c)
 	if err != nil {
 		http.Error(w, "Failed to fetch posts for user.", http.StatusInternalServerError)
 		return
@@ -134,7 +169,8 @@ func ServeUser(w http.ResponseWriter, r *http.Request) {
 		FirstName: appUser.FirstName,
 		LastName:  appUser.LastName,
 		Created:   appUser.Created,
-		Posts:     *posts,
+		Posts:     visible,
+		MorePosts: morePosts(req, posts),
 	}
 
 	t.Execute(w, appUserView)
@@ -143,16 +179,16 @@ func ServeUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func ServePost(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := api.NewContext(r)
 
 	id := api.GetRequestVar(r, "id", c)
 	if id == "" {
 		http.Error(w, "Missing post ID.", http.StatusBadRequest)
 	}
 
-	t, err := template.ParseFiles("templates/post.html")
+	t, err := template.ParseFiles(filepath.Join(Dir, "templates/post.html"))
 	if err != nil {
-		c.Errorf("Failed to parse post template: %v", err)
+		log.Errorf(c, "Failed to parse post template: %v", err)
 		http.Error(w, "Failed to load post.", http.StatusInternalServerError)
 		return
 	}
@@ -163,25 +199,40 @@ func ServePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appUser, err := api.FetchAppUser(post.UserID, c)
-	var username = "[deleted]"
+	p, _ := api.GetRequestPrincipal(r, c)
+	posts, err := api.VisiblePosts([]api.Post{*post}, p, c)
 	if err != nil {
-		c.Infof("No user found for post %v", post.ID)
-	} else {
-		username = appUser.Username
+		http.Error(w, "Failed to fetch post.", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) == 0 {
+		http.Error(w, new(api.ErrPrivateEvent).Error(), http.StatusForbidden)
+		return
 	}
 
-	postView := &api.PostView{
-		Username: username,
-		ID:       post.ID,
-		EventID:  post.EventID,
-		Image:    post.Image,
-		Text:     post.Text,
-		Created:  post.Created,
-		Modified: post.Modified,
+	postViews, err := api.FetchPostViews(posts, c)
+	if err != nil {
+		http.Error(w, "Failed to fetch post.", http.StatusInternalServerError)
+		return
 	}
+	postView := &postViews[0]
 
 	t.Execute(w, postView)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 }
+
+// morePosts returns the URL of the page after posts, relative to the current page, or an
+// empty string if posts is the last page.
+func morePosts(req api.PageRequest, posts *api.PostPage) string {
+	if posts.NextCursor == "" {
+		return ""
+	}
+
+	q := url.Values{"order": {req.Order}, "cursor": {posts.NextCursor}}
+	if req.Limit != api.PAGE_SIZE {
+		q.Set("limit", strconv.Itoa(req.Limit))
+	}
+
+	return "?" + q.Encode()
+}
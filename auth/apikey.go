@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// API_KEY_HEADER is the request header holding an API key.
+const API_KEY_HEADER = "X-API-Key"
+
+// APIKeys identifies users by an API key in the X-API-Key request header. Only the SHA-256
+// hashes of keys are kept in memory.
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys map[[sha256.Size]byte]Identity
+}
+
+// NewAPIKeys returns an empty set of API keys.
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{keys: make(map[[sha256.Size]byte]Identity)}
+}
+
+// LoadAPIKeys reads API keys, one per line as "KEY USER_ID [EMAIL]". Blank lines and lines
+// starting with # are skipped.
+func LoadAPIKeys(r io.Reader) (*APIKeys, error) {
+	a := NewAPIKeys()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("auth: line %v: want KEY USER_ID [EMAIL]", line)
+		}
+
+		id := Identity{ID: fields[1]}
+		if len(fields) == 3 {
+			id.Email = fields[2]
+		}
+		a.Add(fields[0], &id)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Add accepts key as identifying id, replacing any identity it identified before.
+func (a *APIKeys) Add(key string, id *Identity) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.keys[sha256.Sum256([]byte(key))] = *id
+}
+
+// Remove stops accepting key.
+func (a *APIKeys) Remove(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.keys, sha256.Sum256([]byte(key)))
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (*Identity, error) {
+	key := r.Header.Get(API_KEY_HEADER)
+	if key == "" {
+		return nil, nil
+	}
+
+	a.mu.RLock()
+	id, ok := a.keys[sha256.Sum256([]byte(key))]
+	a.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &id, nil
+}
@@ -0,0 +1,82 @@
+// Package auth identifies the user making a request. Each sign in scheme, such as the App
+// Engine Users API, bearer JWTs or API keys, is an Authenticator, and the identity found by
+// one is passed to handlers in the request context.
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ErrInvalidCredentials is returned by an Authenticator when a request carries credentials
+// for its scheme which are malformed, expired or unknown.
+var ErrInvalidCredentials = errors.New("auth: invalid credentials")
+
+// Identity is the signed in user making a request.
+type Identity struct {
+	ID    string
+	Email string
+	// Admin is set for administrators of the app, where the scheme knows about them.
+	Admin bool
+	// ReadOnly is set when the credentials only allow reading, and requests which could
+	// change anything are refused.
+	ReadOnly bool
+}
+
+// Authenticator identifies the user making a request with one sign in scheme.
+type Authenticator interface {
+	// Authenticate returns the identity of the user making r. It returns nil and no error
+	// if r carries no credentials for this scheme, and ErrInvalidCredentials, or another
+	// error, if it carries credentials which cannot be accepted.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// Chain returns an Authenticator which tries each of authenticators in order, and returns
+// the first identity or error found.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+		for _, a := range authenticators {
+			id, err := a.Authenticate(r)
+			if id != nil || err != nil {
+				return id, err
+			}
+		}
+
+		return nil, nil
+	})
+}
+
+type ctxKey int
+
+const identityKey ctxKey = 0
+
+// NewContext returns a copy of c which holds id.
+func NewContext(c context.Context, id *Identity) context.Context {
+	return context.WithValue(c, identityKey, id)
+}
+
+// FromContext returns the identity held by c, or nil if there is none.
+func FromContext(c context.Context) *Identity {
+	id, _ := c.Value(identityKey).(*Identity)
+	return id
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header, or "" if r has none.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWT(t *testing.T) {
+	j := &JWT{Secret: []byte("secret"), Issuer: "issuer", Audience: "gogram"}
+	now := time.Now()
+
+	sign := func(j *JWT, claims *Claims) string {
+		token, err := j.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() failed: %v", err)
+		}
+		return token
+	}
+	valid := &Claims{Subject: "u", Email: "u@example.com", Issuer: "issuer", Audience: audience{"other", "gogram"}, ExpiresAt: now.Add(time.Hour).Unix()}
+
+	claims, err := j.Verify(sign(j, valid), now)
+	if err != nil || claims.Subject != "u" || claims.Email != "u@example.com" {
+		t.Errorf("Verify() of a valid token returned %+v, %v.", claims, err)
+	}
+
+	tests := map[string]string{
+		"wrong secret":   sign(&JWT{Secret: []byte("other")}, valid),
+		"expired":        sign(j, &Claims{Subject: "u", Issuer: "issuer", Audience: audience{"gogram"}, ExpiresAt: now.Add(-time.Minute).Unix()}),
+		"not yet valid":  sign(j, &Claims{Subject: "u", Issuer: "issuer", Audience: audience{"gogram"}, NotBefore: now.Add(time.Minute).Unix()}),
+		"wrong issuer":   sign(j, &Claims{Subject: "u", Issuer: "other", Audience: audience{"gogram"}}),
+		"wrong audience": sign(j, &Claims{Subject: "u", Issuer: "issuer", Audience: audience{"other"}}),
+		"no subject":     sign(j, &Claims{Issuer: "issuer", Audience: audience{"gogram"}}),
+		"unsigned":       "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1In0.",
+	}
+	for name, token := range tests {
+		if _, err := j.Verify(token, now); err != ErrInvalidCredentials {
+			t.Errorf("Verify() of a token that is %v returned %v. Wanted ErrInvalidCredentials.", name, err)
+		}
+	}
+
+	// A single audience is sent as a string rather than an array.
+	token := "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJ1IiwiaXNzIjoiaXNzdWVyIiwiYXVkIjoiZ29ncmFtIn0."
+	parts := strings.Split(token, ".")
+	token += base64.RawURLEncoding.EncodeToString(j.sign(parts[0] + "." + parts[1]))
+	if claims, err := j.Verify(token, now); err != nil || claims.Subject != "u" {
+		t.Errorf("Verify() of a token with a string audience returned %+v, %v.", claims, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	j := &JWT{Secret: []byte("secret")}
+	keys := NewAPIKeys()
+	keys.Add("key", &Identity{ID: "k"})
+	a := Chain(j, keys, &Proxy{IDHeader: "X-User"})
+
+	token, _ := j.Sign(&Claims{Subject: "j"})
+	tests := []struct {
+		header, value string
+		id            string
+		err           error
+	}{
+		{"Authorization", "Bearer " + token, "j", nil},
+		{"Authorization", "Bearer " + token + "x", "", ErrInvalidCredentials},
+		{API_KEY_HEADER, "key", "k", nil},
+		{API_KEY_HEADER, "wrong", "", ErrInvalidCredentials},
+		{"X-User", "p", "p", nil},
+		{"X-Other", "p", "", nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(test.header, test.value)
+
+		id, err := a.Authenticate(r)
+		if err != test.err || (id == nil) != (test.id == "") || (id != nil && id.ID != test.id) {
+			t.Errorf("Authenticate() with %v: %v returned %+v, %v. Wanted %q, %v.", test.header, test.value, id, err, test.id, test.err)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	keys, err := LoadAPIKeys(strings.NewReader("# comment\n\nkey1 u1\nkey2 u2 u2@example.com\n"))
+	if err != nil {
+		t.Fatalf("LoadAPIKeys() failed: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(API_KEY_HEADER, "key2")
+	if id, err := keys.Authenticate(r); err != nil || *id != (Identity{ID: "u2", Email: "u2@example.com"}) {
+		t.Errorf("Authenticate() returned %+v, %v.", id, err)
+	}
+
+	if _, err := LoadAPIKeys(strings.NewReader("key1\n")); err == nil {
+		t.Error("LoadAPIKeys() accepted a key with no user.")
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWT identifies users by a JSON Web Token in an "Authorization: Bearer" header, signed
+// with HMAC-SHA256 by whoever shares Secret. The token's sub claim is the user's ID, and
+// its email claim the user's email address. Bearer tokens which are not JWTs are left for
+// other authenticators.
+type JWT struct {
+	Secret []byte
+	// Issuer and Audience, if set, must match the token's iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking the exp and nbf claims.
+	Leeway time.Duration
+}
+
+// Claims are the JWT claims read by JWT.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims, err := j.Verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{ID: claims.Subject, Email: claims.Email}, nil
+}
+
+// Verify checks the signature and claims of a token, and returns its claims. Any problem
+// with the token is reported as ErrInvalidCredentials.
+func (j *JWT) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidCredentials
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, j.sign(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims := new(Claims)
+	if err := decodeSegment(parts[1], claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if claims.ExpiresAt != 0 && !now.Before(time.Unix(claims.ExpiresAt, 0).Add(j.Leeway)) {
+		return nil, ErrInvalidCredentials
+	}
+	if claims.NotBefore != 0 && now.Add(j.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidCredentials
+	}
+	if j.Issuer != "" && claims.Issuer != j.Issuer {
+		return nil, ErrInvalidCredentials
+	}
+	if j.Audience != "" && !claims.Audience.has(j.Audience) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return claims, nil
+}
+
+// Sign returns a token holding claims, signed with Secret.
+func (j *JWT) Sign(claims *Claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(j.sign(signed)), nil
+}
+
+func (j *JWT) sign(signed string) []byte {
+	mac := hmac.New(sha256.New, j.Secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func (a audience) has(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"github.com/reedperry/gogram/log"
+)
+
+// Paths of the handlers served by OIDC.
+const LOGIN_PATH = "/auth/login"
+const CALLBACK_PATH = "/auth/callback"
+const LOGOUT_PATH = "/auth/logout"
+
+// LOGIN_COOKIE holds the state of a login while the user is at the identity provider.
+const LOGIN_COOKIE = "gogram_login"
+
+// How long a user has to sign in at the identity provider.
+const LOGIN_TIMEOUT = 10 * time.Minute
+
+// Allowed clock skew when checking the times in ID tokens.
+const ID_TOKEN_LEEWAY = time.Minute
+
+// Provider is an OpenID Connect identity provider, as described by its discovery document.
+type Provider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+
+	// Client makes requests to the provider. http.DefaultClient is used if it is nil.
+	Client *http.Client `json:"-"`
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Discover fetches the discovery document of the identity provider with an issuer URL.
+func Discover(c context.Context, issuer string, client *http.Client) (*Provider, error) {
+	p := &Provider{Client: client}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(c, wellKnown, p); err != nil {
+		return nil, err
+	}
+
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("auth: provider at %v claims to be issuer %v", issuer, p.Issuer)
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		return nil, fmt.Errorf("auth: discovery document of %v is missing endpoints", issuer)
+	}
+
+	return p, nil
+}
+
+// IDClaims are the claims of an ID token read by Provider.
+type IDClaims struct {
+	Claims
+	Nonce         string `json:"nonce"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// VerifyIDToken checks the RS256 signature and claims of an ID token issued to clientID
+// for a login with nonce, and returns its claims.
+func (p *Provider) VerifyIDToken(c context.Context, token, clientID, nonce string, now time.Time) (*IDClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidCredentials
+	}
+
+	key, err := p.key(c, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims := new(IDClaims)
+	if err := decodeSegment(parts[1], claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer,
+		!claims.Audience.has(clientID),
+		claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(ID_TOKEN_LEEWAY)),
+		claims.NotBefore != 0 && now.Add(ID_TOKEN_LEEWAY).Before(time.Unix(claims.NotBefore, 0)),
+		claims.Nonce != nonce:
+		return nil, ErrInvalidCredentials
+	}
+
+	return claims, nil
+}
+
+// key returns the provider's signing key with an ID, fetching the provider's keys if it
+// is not known, as happens when the provider rotates its keys.
+func (p *Provider) key(c context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(c, p.JWKSURL, &jwks); err != nil {
+		return nil, err
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return key, nil
+}
+
+func (p *Provider) getJSON(c context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client().Do(req.WithContext(c))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: GET %v returned %v", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+// OIDC signs users in with an OpenID Connect identity provider, using the authorization
+// code flow with PKCE, and keeps them signed in with Sessions. It serves LOGIN_PATH,
+// CALLBACK_PATH and LOGOUT_PATH, and authenticates requests by their session cookie.
+// The subject and email of the ID token become the ID and Email of the user.
+type OIDC struct {
+	Provider     *Provider
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of CALLBACK_PATH, as registered with the provider.
+	RedirectURL string
+	// Scopes requested in addition to "openid". Defaults to "email".
+	Scopes   []string
+	Sessions *Sessions
+}
+
+// A login is the payload of the login cookie.
+type login struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Dest     string    `json:"dest"`
+	Expires  time.Time `json:"exp"`
+}
+
+func (o *OIDC) Authenticate(r *http.Request) (*Identity, error) {
+	return o.Sessions.Authenticate(r)
+}
+
+// LoginURL returns the URL of a login which returns the user to dest.
+func (o *OIDC) LoginURL(r *http.Request, dest string) (string, error) {
+	return LOGIN_PATH + "?" + url.Values{"dest": {dest}}.Encode(), nil
+}
+
+func (o *OIDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case LOGIN_PATH:
+		o.Login(w, r)
+	case CALLBACK_PATH:
+		o.Callback(w, r)
+	case LOGOUT_PATH:
+		o.Logout(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Login sends the user to sign in at the identity provider, which returns them to
+// Callback. The state, nonce and PKCE verifier of the login are kept in a cookie.
+func (o *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	c := r.Context()
+
+	l := &login{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Dest:     localPath(r.FormValue("dest")),
+		Expires:  time.Now().Add(LOGIN_TIMEOUT),
+	}
+	value, err := o.Sessions.encode(LOGIN_COOKIE, l)
+	if err != nil {
+		log.Errorf(c, "Failed to start login: %v", err)
+		http.Error(w, "Failed to sign in.", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, o.Sessions.cookie(LOGIN_COOKIE, value, LOGIN_TIMEOUT))
+
+	authURL := o.config().AuthCodeURL(l.State, oauth2.S256ChallengeOption(l.Verifier), oauth2.SetAuthURLParam("nonce", l.Nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback completes a login started by Login, exchanging the authorization code for an
+// ID token, and starts a session for the user it identifies.
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	c := r.Context()
+
+	cookie, err := r.Cookie(LOGIN_COOKIE)
+	if err != nil {
+		http.Error(w, "No login in progress.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, o.Sessions.cookie(LOGIN_COOKIE, "", -1))
+
+	var l login
+	if err := o.Sessions.decode(LOGIN_COOKIE, cookie.Value, &l); err != nil || !time.Now().Before(l.Expires) {
+		http.Error(w, "Login expired. Please try again.", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("state") != l.State {
+		log.Infof(c, "Login state does not match.")
+		http.Error(w, "Invalid login state.", http.StatusBadRequest)
+		return
+	}
+	if errCode := r.FormValue("error"); errCode != "" {
+		log.Infof(c, "Identity provider refused login: %v %v", errCode, r.FormValue("error_description"))
+		http.Error(w, "Sign in failed.", http.StatusForbidden)
+		return
+	}
+
+	token, err := o.config().Exchange(context.WithValue(c, oauth2.HTTPClient, o.Provider.client()), r.FormValue("code"), oauth2.VerifierOption(l.Verifier))
+	if err != nil {
+		log.Errorf(c, "Failed to exchange authorization code: %v", err)
+		http.Error(w, "Sign in failed.", http.StatusInternalServerError)
+		return
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	claims, err := o.Provider.VerifyIDToken(c, rawIDToken, o.ClientID, l.Nonce, time.Now())
+	if err != nil {
+		log.Errorf(c, "Invalid ID token: %v", err)
+		http.Error(w, "Sign in failed.", http.StatusForbidden)
+		return
+	}
+
+	id := &Identity{ID: claims.Subject}
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		id.Email = claims.Email
+	}
+	if err := o.Sessions.Start(w, id); err != nil {
+		log.Errorf(c, "Failed to start session: %v", err)
+		http.Error(w, "Sign in failed.", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof(c, "User %v signed in.", id.ID)
+	http.Redirect(w, r, l.Dest, http.StatusFound)
+}
+
+// Logout ends the user's session and returns them to the home page.
+func (o *OIDC) Logout(w http.ResponseWriter, r *http.Request) {
+	o.Sessions.End(w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (o *OIDC) config() *oauth2.Config {
+	scopes := o.Scopes
+	if scopes == nil {
+		scopes = []string{"email"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		RedirectURL:  o.RedirectURL,
+		Scopes:       append([]string{"openid"}, scopes...),
+		Endpoint:     oauth2.Endpoint{AuthURL: o.Provider.AuthURL, TokenURL: o.Provider.TokenURL},
+	}
+}
+
+// localPath returns dest if it is a path on this site, or "/" otherwise, so that logins
+// cannot be used to send users to other sites.
+func localPath(dest string) string {
+	if !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") || strings.HasPrefix(dest, "/\\") {
+		return "/"
+	}
+	return dest
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// mockProvider is an OpenID Connect identity provider which signs in every user as Subject.
+type mockProvider struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	Subject string
+	Email   string
+
+	mu    sync.Mutex
+	codes map[string]url.Values // Authorization requests by code.
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, Subject: "sub", Email: "sub@example.com", codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = r.URL.Query()
+		p.mu.Unlock()
+
+		callback := r.FormValue("redirect_uri") + "?" + url.Values{"code": {code}, "state": {r.FormValue("state")}}.Encode()
+		http.Redirect(w, r, callback, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		auth, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		p.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || auth.Get("code_challenge_method") != "S256" ||
+			auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     p.idToken(t, auth.Get("client_id"), auth.Get("nonce")),
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *mockProvider) idToken(t *testing.T, clientID, nonce string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.URL,
+		"sub":   p.Subject,
+		"aud":   clientID,
+		"email": p.Email,
+		"nonce": nonce,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newMockProvider(t)
+	defer idp.Close()
+
+	provider, err := Discover(context.Background(), idp.URL, nil)
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+
+	o := &OIDC{
+		Provider:    provider,
+		ClientID:    "gogram",
+		RedirectURL: "http://gogram.test" + CALLBACK_PATH,
+		Sessions:    &Sessions{Key: []byte("session key"), MaxAge: time.Hour},
+	}
+
+	// Start a login, and follow the provider's redirect back to the callback.
+	loginURL, _ := o.LoginURL(nil, "/e/1")
+	w := httptest.NewRecorder()
+	o.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loginURL, nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), idp.URL+"/authorize?") {
+		t.Fatalf("Login returned %v, redirecting to %q.", w.Code, w.Header().Get("Location"))
+	}
+	loginCookies := w.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, cookie := range loginCookies {
+		r.AddCookie(cookie)
+	}
+	o.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/e/1" {
+		t.Fatalf("Callback returned %v %q, redirecting to %q.", w.Code, w.Body.String(), w.Header().Get("Location"))
+	}
+
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SESSION_COOKIE {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("Callback did not set a session cookie.")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(session)
+	id, err := o.Authenticate(r)
+	if err != nil || id == nil || *id != (Identity{ID: "sub", Email: "sub@example.com"}) {
+		t.Errorf("Authenticate() with the session cookie returned %+v, %v.", id, err)
+	}
+	if _, err := o.Sessions.identity(r, time.Now().Add(2*time.Hour)); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() with an expired session returned %v. Wanted ErrInvalidCredentials.", err)
+	}
+
+	// The login cannot be completed again, nor without its cookie.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, cookie := range loginCookies {
+		r.AddCookie(cookie)
+	}
+	o.ServeHTTP(w, r)
+	if w.Code == http.StatusFound {
+		t.Error("Callback accepted an authorization code twice.")
+	}
+
+	w = httptest.NewRecorder()
+	o.ServeHTTP(w, httptest.NewRequest(http.MethodGet, callback, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Callback without the login cookie returned %v. Wanted %v.", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	o.ServeHTTP(w, httptest.NewRequest(http.MethodPost, LOGOUT_PATH, nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SESSION_COOKIE || cookies[0].MaxAge >= 0 {
+		t.Errorf("Logout set cookies %v. Wanted the session cookie deleted.", cookies)
+	}
+
+	// A session cookie from a different key is rejected.
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(session)
+	if _, err := (&Sessions{Key: []byte("other key")}).Authenticate(r); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() with another key returned %v. Wanted ErrInvalidCredentials.", err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"/e/1":                "/e/1",
+		"":                    "/",
+		"https://example.com": "/",
+		"//example.com":       "/",
+		"/\\example.com":      "/",
+	}
+	for dest, want := range tests {
+		if got := localPath(dest); got != want {
+			t.Errorf("localPath(%q) = %q. Wanted %q.", dest, got, want)
+		}
+	}
+}
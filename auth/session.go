@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// SESSION_COOKIE is the default name of the session cookie.
+const SESSION_COOKIE = "gogram_session"
+
+// DEFAULT_SESSION_AGE is how long a session lasts when Sessions.MaxAge is not set.
+const DEFAULT_SESSION_AGE = 24 * time.Hour
+
+var errCookie = errors.New("auth: invalid cookie")
+
+// Sessions keeps users signed in with a cookie holding their identity. Cookie values are
+// encrypted and authenticated with AES-GCM, so they can be neither read nor forged by
+// anyone without Key, and expire after MaxAge.
+type Sessions struct {
+	// Key is the secret from which the encryption key is derived, and should hold at least
+	// 32 random bytes. Changing it signs out every user.
+	Key    []byte
+	Name   string
+	MaxAge time.Duration
+	// Secure restricts cookies to HTTPS, and should be set unless serving plain HTTP.
+	Secure bool
+}
+
+// A session is the payload of a session cookie.
+type session struct {
+	ID      string    `json:"id"`
+	Email   string    `json:"email"`
+	Expires time.Time `json:"exp"`
+}
+
+func (s *Sessions) Authenticate(r *http.Request) (*Identity, error) {
+	return s.identity(r, time.Now())
+}
+
+func (s *Sessions) identity(r *http.Request, now time.Time) (*Identity, error) {
+	cookie, err := r.Cookie(s.name())
+	if err != nil {
+		return nil, nil
+	}
+
+	var sess session
+	if err := s.decode(cookie.Name, cookie.Value, &sess); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if !now.Before(sess.Expires) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{ID: sess.ID, Email: sess.Email}, nil
+}
+
+// Start signs in the user identified by id, until the session expires or End is called.
+func (s *Sessions) Start(w http.ResponseWriter, id *Identity) error {
+	maxAge := s.maxAge()
+	value, err := s.encode(s.name(), &session{ID: id.ID, Email: id.Email, Expires: time.Now().Add(maxAge)})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, s.cookie(s.name(), value, maxAge))
+	return nil
+}
+
+// End signs out the user of a session.
+func (s *Sessions) End(w http.ResponseWriter) {
+	http.SetCookie(w, s.cookie(s.name(), "", -1))
+}
+
+func (s *Sessions) name() string {
+	if s.Name == "" {
+		return SESSION_COOKIE
+	}
+	return s.Name
+}
+
+func (s *Sessions) maxAge() time.Duration {
+	if s.MaxAge <= 0 {
+		return DEFAULT_SESSION_AGE
+	}
+	return s.MaxAge
+}
+
+// cookie returns a cookie for the whole site. A negative maxAge deletes the cookie.
+func (s *Sessions) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   s.Secure,
+		HttpOnly: true,
+		// Lax cookies are sent when an identity provider redirects back to the app, but
+		// not with requests made by other sites.
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(maxAge / time.Second)
+	}
+
+	return cookie
+}
+
+// encode encrypts v as the value of the cookie called name. The name is authenticated
+// along with the value, so that the value of one cookie cannot be used as another.
+func (s *Sessions) encode(name string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(name))), nil
+}
+
+// decode decrypts the value of the cookie called name into v.
+func (s *Sessions) decode(name, value string, v interface{}) error {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return errCookie
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return errCookie
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errCookie
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+func (s *Sessions) aead() (cipher.AEAD, error) {
+	if len(s.Key) == 0 {
+		return nil, errors.New("auth: no session key")
+	}
+
+	key := sha256.Sum256(s.Key)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/user"
+)
+
+// AppEngineUsers identifies users signed in with the App Engine Users API. It only works
+// when running on App Engine.
+type AppEngineUsers struct{}
+
+func (AppEngineUsers) Authenticate(r *http.Request) (*Identity, error) {
+	u := user.Current(appengine.NewContext(r))
+	if u == nil {
+		return nil, nil
+	}
+
+	return &Identity{ID: u.ID, Email: u.Email, Admin: u.Admin}, nil
+}
+
+// Proxy identifies users signed in by an authenticating proxy in front of a standalone
+// server, with the IDHeader and EmailHeader request headers. The proxy must remove these
+// headers from all incoming requests.
+type Proxy struct {
+	IDHeader    string
+	EmailHeader string
+}
+
+func (p *Proxy) Authenticate(r *http.Request) (*Identity, error) {
+	id := r.Header.Get(p.IDHeader)
+	if id == "" {
+		return nil, nil
+	}
+
+	return &Identity{ID: id, Email: r.Header.Get(p.EmailHeader)}, nil
+}
+
+// Dev signs in every request as a single user. It is only meant for local development.
+type Dev struct {
+	ID    string
+	Email string
+}
+
+func (d *Dev) Authenticate(r *http.Request) (*Identity, error) {
+	return &Identity{ID: d.ID, Email: d.Email}, nil
+}
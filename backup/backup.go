@@ -0,0 +1,260 @@
+// Package backup dumps the users, events, members of events and posts held by the stores
+// in package api, along with the images of the posts, to a JSON Lines file, and restores
+// such a dump.
+// Entities keep their IDs, so they keep their keys in any store.
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/imgstore"
+)
+
+// Kind of the records holding images. Other records are of api.USER_KIND, api.EVENT_KIND,
+// api.MEMBER_KIND or api.POST_KIND.
+const IMAGE_KIND = "image"
+
+// ErrNotEmpty is returned by Restore when the stores already hold users, events or posts.
+var ErrNotEmpty = errors.New("backup: cannot restore into stores which are not empty")
+
+// A Record is one line of a dump. Exactly one of its entities is set, named by Kind.
+type Record struct {
+	Kind   string     `json:"kind"`
+	User   *User      `json:"user,omitempty"`
+	Event  *api.Event `json:"event,omitempty"`
+	Member *Member    `json:"member,omitempty"`
+	Post   *api.Post  `json:"post,omitempty"`
+	Image  *Image     `json:"image,omitempty"`
+}
+
+// User is an AppUser along with its email address, which is left out of its JSON.
+type User struct {
+	*api.AppUser
+	Email string `json:"email"`
+}
+
+// Member is an event Member along with the IDs of its user and of the user who invited
+// them, which are left out of its JSON.
+type Member struct {
+	*api.Member
+	UserID    string `json:"user"`
+	InvitedBy string `json:"invitedBy"`
+}
+
+// Image is an object from the imgstore: the image of a post, or one of its resized copies.
+type Image struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// Counts is the number of records of each kind dumped or restored.
+type Counts struct {
+	Users   int
+	Events  int
+	Members int
+	Posts   int
+	Images  int
+}
+
+// Dump writes every user, then every event followed by its members, then every post
+// followed by its images, to w.
+// Images which are missing from the imgstore are left out.
+func Dump(w io.Writer, c context.Context) (*Counts, error) {
+	enc := json.NewEncoder(w)
+	counts := new(Counts)
+
+	req := api.PageRequest{Order: "Created", Limit: api.MAX_PAGE_SIZE}
+	for {
+		page, err := api.Users.All(req, c)
+		if err != nil {
+			return counts, err
+		}
+
+		for i := range page.Items {
+			appUser := &page.Items[i]
+			if err := enc.Encode(&Record{Kind: api.USER_KIND, User: &User{appUser, appUser.Email}}); err != nil {
+				return counts, err
+			}
+			counts.Users++
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+
+	req = api.PageRequest{Order: "Created", Limit: api.MAX_PAGE_SIZE}
+	for {
+		page, err := api.Events.FeedPage(req, c)
+		if err != nil {
+			return counts, err
+		}
+
+		for i := range page.Items {
+			event := &page.Items[i]
+			if err := enc.Encode(&Record{Kind: api.EVENT_KIND, Event: event}); err != nil {
+				return counts, err
+			}
+			counts.Events++
+
+			members, err := api.Members.ByEvent(event.ID, c)
+			if err != nil {
+				return counts, err
+			}
+			for j := range members {
+				member := &members[j]
+				record := &Record{Kind: api.MEMBER_KIND, Member: &Member{member, member.UserID, member.InvitedBy}}
+				if err := enc.Encode(record); err != nil {
+					return counts, err
+				}
+				counts.Members++
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+
+	req = api.PageRequest{Order: "Created", Limit: api.MAX_PAGE_SIZE}
+	for {
+		page, err := api.Posts.All(req, c)
+		if err != nil {
+			return counts, err
+		}
+
+		for i := range page.Items {
+			post := &page.Items[i]
+			if err := enc.Encode(&Record{Kind: api.POST_KIND, Post: post}); err != nil {
+				return counts, err
+			}
+			counts.Posts++
+
+			if post.Image == "" {
+				continue
+			}
+			filename := post.ImageName()
+			for _, name := range append([]string{filename}, imgstore.VariantNames(filename)...) {
+				image, err := readImage(name, c)
+				if err == imgstore.ErrObjectNotExist {
+					continue
+				}
+				if err != nil {
+					return counts, err
+				}
+
+				if err := enc.Encode(&Record{Kind: IMAGE_KIND, Image: image}); err != nil {
+					return counts, err
+				}
+				counts.Images++
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+
+	return counts, nil
+}
+
+// Restore reads a dump written by Dump from r, and saves every record in it. The stores
+// must be empty, so that no entity is overwritten.
+func Restore(r io.Reader, c context.Context) (*Counts, error) {
+	counts := new(Counts)
+	if err := checkEmpty(c); err != nil {
+		return counts, err
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var record Record
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+
+		var count *int
+		switch {
+		case record.Kind == api.USER_KIND && record.User != nil && record.User.AppUser != nil:
+			appUser := record.User.AppUser
+			appUser.Email = record.User.Email
+			err, count = api.Users.Create(appUser, c), &counts.Users
+		case record.Kind == api.EVENT_KIND && record.Event != nil:
+			err, count = api.Events.Put(record.Event, c), &counts.Events
+		case record.Kind == api.MEMBER_KIND && record.Member != nil && record.Member.Member != nil:
+			member := record.Member.Member
+			member.UserID, member.InvitedBy = record.Member.UserID, record.Member.InvitedBy
+			err, count = api.Members.Put(member, c), &counts.Members
+		case record.Kind == api.POST_KIND && record.Post != nil:
+			err, count = api.Posts.Put(record.Post, c), &counts.Posts
+		case record.Kind == IMAGE_KIND && record.Image != nil:
+			image := record.Image
+			_, err = imgstore.Store.Put(c, image.Name, image.ContentType, bytes.NewReader(image.Data))
+			count = &counts.Images
+		default:
+			return counts, errors.New("backup: invalid record of kind " + record.Kind)
+		}
+		if err != nil {
+			return counts, err
+		}
+		*count++
+	}
+}
+
+func checkEmpty(c context.Context) error {
+	req := api.PageRequest{Order: "Created", Limit: 1}
+
+	users, err := api.Users.All(req, c)
+	if err != nil {
+		return err
+	}
+	events, err := api.Events.FeedPage(req, c)
+	if err != nil {
+		return err
+	}
+	posts, err := api.Posts.All(req, c)
+	if err != nil {
+		return err
+	}
+
+	if len(users.Items) != 0 || len(events.Items) != 0 || len(posts.Items) != 0 {
+		return ErrNotEmpty
+	}
+
+	return nil
+}
+
+func readImage(name string, c context.Context) (*Image, error) {
+	obj, err := imgstore.Store.Stat(c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := imgstore.Store.Get(c, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{Name: name, ContentType: obj.ContentType, Data: data}, nil
+}
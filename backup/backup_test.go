@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/imgstore"
+)
+
+// useEmptyStores replaces the stores in package api and imgstore.Store with empty ones.
+func useEmptyStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api.Users, api.Events, api.Posts = api.NewMemoryUserStore(), api.NewMemoryEventStore(), api.NewMemoryPostStore()
+	api.Members = api.NewMemoryMemberStore()
+	imgstore.Store = &imgstore.LocalStore{Dir: dir}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	defer func(users api.UserStore, events api.EventStore, members api.MemberStore, posts api.PostStore, store imgstore.BlobStore) {
+		api.Users, api.Events, api.Members, api.Posts, imgstore.Store = users, events, members, posts, store
+	}(api.Users, api.Events, api.Members, api.Posts, imgstore.Store)
+
+	c := context.Background()
+	useEmptyStores(t)
+	defer os.RemoveAll(imgstore.Store.(*imgstore.LocalStore).Dir)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	api.Users.Create(&api.AppUser{ID: "u", Email: "u@example.com", Username: "someone", Created: now}, c)
+	api.Events.Put(&api.Event{ID: "e", Name: "Party", Creator: "u", Created: now, CoHosts: []string{"h"}}, c)
+	api.Members.Put(&api.Member{EventID: "e", UserID: "m", Status: api.MEMBER_JOINED, InvitedBy: "u", Created: now}, c)
+
+	// More than a page of posts, so that the dump has to page through them.
+	count := api.MAX_PAGE_SIZE + 5
+	for i := 0; i < count; i++ {
+		post := &api.Post{ID: strconv.Itoa(i), UserID: "u", EventID: "e", Image: "link", Created: now.Add(time.Duration(i))}
+		api.Posts.Put(post, c)
+		imgstore.Write(c, "u/"+post.ID, "image/gif", strings.NewReader("GIF89a"+post.ID))
+	}
+	imgstore.Write(c, "u/0_thumb", "image/gif", strings.NewReader("GIF89a"))
+	api.Posts.Put(&api.Post{ID: "imageless", UserID: "u", EventID: "e", Created: now}, c)
+
+	var dump bytes.Buffer
+	counts, err := Dump(&dump, c)
+	if err != nil {
+		t.Fatalf("Dump() failed: %v", err)
+	}
+	want := Counts{Users: 1, Events: 1, Members: 1, Posts: count + 1, Images: count + 1}
+	if *counts != want {
+		t.Errorf("Dump() wrote %+v. Wanted %+v.", *counts, want)
+	}
+
+	if _, err := Restore(bytes.NewReader(dump.Bytes()), c); err != ErrNotEmpty {
+		t.Errorf("Restore() into the same stores returned %v. Wanted ErrNotEmpty.", err)
+	}
+
+	useEmptyStores(t)
+	defer os.RemoveAll(imgstore.Store.(*imgstore.LocalStore).Dir)
+
+	counts, err = Restore(&dump, c)
+	if err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	if *counts != want {
+		t.Errorf("Restore() read %+v. Wanted %+v.", *counts, want)
+	}
+
+	appUser, err := api.Users.Get("u", c)
+	if err != nil || appUser.Email != "u@example.com" || !appUser.Created.Equal(now) {
+		t.Errorf("Get() of the restored user returned %+v, %v.", appUser, err)
+	}
+	if event, err := api.Events.Get("e", c); err != nil || event.Name != "Party" || !event.IsCoHost("h") {
+		t.Errorf("Get() of the restored event returned %+v, %v.", event, err)
+	}
+	if member, err := api.Members.Get("e", "m", c); err != nil || member.InvitedBy != "u" || member.Status != api.MEMBER_JOINED {
+		t.Errorf("Get() of the restored member returned %+v, %v.", member, err)
+	}
+
+	post, err := api.Posts.Get("7", c)
+	if err != nil {
+		t.Fatalf("Get() of a restored post failed: %v", err)
+	}
+	if want := (&api.Post{ID: "7", UserID: "u", EventID: "e", Image: "link", Created: now.Add(7)}); !reflect.DeepEqual(post, want) {
+		t.Errorf("Get() of a restored post returned %+v. Wanted %+v.", post, want)
+	}
+
+	rc, err := imgstore.Store.Get(c, "u/7")
+	if err != nil {
+		t.Fatalf("Get() of a restored image failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "GIF89a7" {
+		t.Errorf("Restored image holds %q.", data)
+	}
+}
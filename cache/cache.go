@@ -0,0 +1,50 @@
+// Package cache keeps recently used values close at hand. On App Engine values are kept in
+// memcache, which is shared by all instances; elsewhere they are kept in an in-process LRU.
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrCacheMiss is returned by Get when a key is not in the cache.
+var ErrCacheMiss = errors.New("cache: miss")
+
+// Cache stores values by key. Values may be evicted at any time, so a cache only speeds up
+// lookups and is never the only copy of a value.
+type Cache interface {
+	// Get returns the value of a key, or ErrCacheMiss.
+	Get(c context.Context, key string) ([]byte, error)
+	// GetMulti returns the values of the keys which are in the cache.
+	GetMulti(c context.Context, keys []string) (map[string][]byte, error)
+	// Set stores a value, which expires after ttl. A ttl of zero means the value does
+	// not expire, although it may still be evicted.
+	Set(c context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys from the cache. Keys which are not in the cache are ignored.
+	Delete(c context.Context, keys ...string) error
+}
+
+// Stats counts hits and misses of cache lookups. It is safe for concurrent use.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+func (s *Stats) Hit() {
+	atomic.AddInt64(&s.Hits, 1)
+}
+
+func (s *Stats) Miss() {
+	atomic.AddInt64(&s.Misses, 1)
+}
+
+// Get returns a copy of the current counts.
+func (s *Stats) Get() Stats {
+	return Stats{
+		Hits:   atomic.LoadInt64(&s.Hits),
+		Misses: atomic.LoadInt64(&s.Misses),
+	}
+}
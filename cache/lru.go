@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// LRU is a Cache held in memory by a single process. When it is full, the least recently
+// used value is evicted to make room for a new one.
+type LRU struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+
+	now func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU creates an LRU which holds up to maxEntries values.
+func NewLRU(maxEntries int) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+func (l *LRU) Get(c context.Context, key string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	value, ok := l.get(key)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	return value, nil
+}
+
+func (l *LRU) GetMulti(c context.Context, keys []string) (map[string][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if value, ok := l.get(key); ok {
+			values[key] = value
+		}
+	}
+
+	return values, nil
+}
+
+func (l *LRU) Set(c context.Context, key string, value []byte, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = l.now().Add(ttl)
+	}
+
+	if el, ok := l.entries[key]; ok {
+		el.Value = entry
+		l.order.MoveToFront(el)
+		return nil
+	}
+
+	l.entries[key] = l.order.PushFront(entry)
+	for l.order.Len() > l.maxEntries {
+		l.remove(l.order.Back())
+	}
+
+	return nil
+}
+
+func (l *LRU) Delete(c context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := l.entries[key]; ok {
+			l.remove(el)
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of values in the cache, including expired values which have
+// not been removed yet.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len()
+}
+
+// get returns the value of a key and marks it as recently used. The caller must hold l.mu.
+func (l *LRU) get(key string) ([]byte, bool) {
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && !l.now().Before(entry.expires) {
+		l.remove(el)
+		return nil, false
+	}
+
+	l.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (l *LRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.entries, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEviction(t *testing.T) {
+	l := NewLRU(2)
+
+	l.Set(nil, "a", []byte("1"), 0)
+	l.Set(nil, "b", []byte("2"), 0)
+	l.Get(nil, "a") // b is now the least recently used
+	l.Set(nil, "c", []byte("3"), 0)
+
+	if _, err := l.Get(nil, "b"); err != ErrCacheMiss {
+		t.Errorf("Get() of an evicted key returned %v. Wanted ErrCacheMiss.", err)
+	}
+
+	values, _ := l.GetMulti(nil, []string{"a", "b", "c"})
+	if len(values) != 2 || string(values["a"]) != "1" || string(values["c"]) != "3" {
+		t.Errorf("GetMulti() returned %q. Wanted a and c.", values)
+	}
+
+	l.Delete(nil, "a", "missing")
+	if l.Len() != 1 {
+		t.Errorf("LRU holds %v values after Delete(). Wanted 1.", l.Len())
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	l := NewLRU(10)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.Set(nil, "a", []byte("1"), time.Minute)
+	l.Set(nil, "b", []byte("2"), 0)
+
+	now = now.Add(time.Minute)
+	if _, err := l.Get(nil, "a"); err != ErrCacheMiss {
+		t.Errorf("Get() of an expired key returned %v. Wanted ErrCacheMiss.", err)
+	}
+	if _, err := l.Get(nil, "b"); err != nil {
+		t.Errorf("Get() of a key without a ttl failed: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/memcache"
+)
+
+// Memcache is a Cache using the App Engine memcache service, which is shared by all
+// instances of the app. Keys are prefixed with Prefix, so that different caches, or
+// versions of the app, can use the same memcache without seeing each other's values.
+type Memcache struct {
+	Prefix string
+}
+
+func (m *Memcache) Get(c context.Context, key string) ([]byte, error) {
+	item, err := memcache.Get(c, m.Prefix+key)
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+func (m *Memcache) GetMulti(c context.Context, keys []string) (map[string][]byte, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = m.Prefix + key
+	}
+
+	items, err := memcache.GetMulti(c, prefixed)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(items))
+	for i, key := range keys {
+		if item, ok := items[prefixed[i]]; ok {
+			values[key] = item.Value
+		}
+	}
+
+	return values, nil
+}
+
+func (m *Memcache) Set(c context.Context, key string, value []byte, ttl time.Duration) error {
+	return memcache.Set(c, &memcache.Item{
+		Key:        m.Prefix + key,
+		Value:      value,
+		Expiration: ttl,
+	})
+}
+
+func (m *Memcache) Delete(c context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = m.Prefix + key
+	}
+
+	err := memcache.DeleteMulti(c, prefixed)
+	if errs, ok := err.(appengine.MultiError); ok {
+		for _, err := range errs {
+			if err != nil && err != memcache.ErrCacheMiss {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+// Command gogram-backup dumps the users, events and posts of a gogram deployment, along
+// with their images, to a JSON Lines file, or restores such a dump into an empty one:
+//
+//	gogram-backup [flags] dump FILE
+//	gogram-backup [flags] restore FILE
+//
+// FILE may be "-" for standard output or input. The stores are selected with the same
+// flags as cmd/gogram. Datastore is reached through the remote API of the App Engine app
+// at -remote-host, with Application Default Credentials.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine/remote_api"
+
+	"github.com/reedperry/gogram/backup"
+	"github.com/reedperry/gogram/config"
+)
+
+func main() {
+	var cfg config.Config
+	cfg.RegisterFlags(flag.CommandLine)
+
+	remoteHost := flag.String("remote-host", "", "host of the App Engine app, e.g. my-app.appspot.com, for the datastore store")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] dump|restore FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, filename := flag.Arg(0), flag.Arg(1)
+
+	// Entities are only read or written once, so caching them would only use memory.
+	cfg.CacheSize = 0
+	if err := cfg.Apply(); err != nil {
+		stdlog.Fatalf("Failed to configure storage: %v", err)
+	}
+	defer cfg.Close()
+
+	c := context.Background()
+	if cfg.Store == "datastore" {
+		var err error
+		if c, err = remoteContext(*remoteHost); err != nil {
+			stdlog.Fatalf("Failed to connect to %v: %v", *remoteHost, err)
+		}
+	}
+
+	var counts *backup.Counts
+	var err error
+	switch command {
+	case "dump":
+		var f io.WriteCloser = os.Stdout
+		if filename != "-" {
+			if f, err = os.Create(filename); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		counts, err = backup.Dump(f, c)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	case "restore":
+		var f io.ReadCloser = os.Stdin
+		if filename != "-" {
+			if f, err = os.Open(filename); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		counts, err = backup.Restore(f, c)
+		f.Close()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "%v: %v users, %v events, %v members, %v posts and %v images.\n",
+		command, counts.Users, counts.Events, counts.Members, counts.Posts, counts.Images)
+	if err != nil {
+		stdlog.Fatalf("Failed to %v %v: %v", command, filename, err)
+	}
+}
+
+// remoteContext returns a context whose App Engine API calls, such as those to Datastore,
+// are made through the remote API of the app at host.
+func remoteContext(host string) (context.Context, error) {
+	if host == "" {
+		return nil, fmt.Errorf("-remote-host is required for the datastore store")
+	}
+
+	client, err := google.DefaultClient(context.Background(),
+		"https://www.googleapis.com/auth/appengine.apis",
+		"https://www.googleapis.com/auth/userinfo.email",
+		"https://www.googleapis.com/auth/cloud-platform",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote_api.NewRemoteContext(host, client)
+}
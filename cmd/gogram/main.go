@@ -0,0 +1,221 @@
+// Command gogram runs gogram as a standalone HTTP server, serving the app and the image
+// processor in one process.
+package main
+
+import (
+	"flag"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/app"
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/config"
+	"github.com/reedperry/gogram/imgproc"
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/middleware"
+	"github.com/reedperry/gogram/tasks"
+)
+
+func main() {
+	var cfg config.Config
+	cfg.RegisterFlags(flag.CommandLine)
+
+	addr := flag.String("addr", ":8080", "address to listen on")
+	appDir := flag.String("app-dir", "app", "directory holding index.html, templates and static files")
+	authSchemes := flag.String("auth", "", `how users sign in, which is required: a comma separated list of "proxy", "dev", "oidc", "jwt" or "apikey", tried in order`)
+	idHeader := flag.String("auth-id-header", "X-Forwarded-User", "request header with the user ID, for proxy auth")
+	emailHeader := flag.String("auth-email-header", "X-Forwarded-Email", "request header with the user email, for proxy auth")
+	devUser := flag.String("dev-user", "dev", "ID of the user signed in to every request, for dev auth")
+	oidcIssuer := flag.String("oidc-issuer", "", "issuer URL of the OpenID Connect provider, for oidc auth")
+	oidcClientID := flag.String("oidc-client-id", "", "client ID registered with the provider, for oidc auth")
+	oidcClientSecret := flag.String("oidc-client-secret", os.Getenv("GOGRAM_OIDC_CLIENT_SECRET"), "client secret registered with the provider, for oidc auth (default $GOGRAM_OIDC_CLIENT_SECRET)")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "http://localhost:8080"+auth.CALLBACK_PATH, "URL of "+auth.CALLBACK_PATH+" registered with the provider, for oidc auth")
+	sessionKey := flag.String("session-key", os.Getenv("GOGRAM_SESSION_KEY"), "secret encrypting session cookies, for oidc auth (default $GOGRAM_SESSION_KEY)")
+	sessionAge := flag.Duration("session-age", auth.DEFAULT_SESSION_AGE, "time until users must sign in again, for oidc auth")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("GOGRAM_JWT_SECRET"), "secret signing bearer tokens with HS256, for jwt auth (default $GOGRAM_JWT_SECRET)")
+	jwtIssuer := flag.String("jwt-issuer", "", "required iss claim of bearer tokens, for jwt auth")
+	jwtAudience := flag.String("jwt-audience", "", "required aud claim of bearer tokens, for jwt auth")
+	inviteKey := flag.String("invite-key", os.Getenv("GOGRAM_INVITE_KEY"), "secret signing invite links to events, which are disabled if empty (default $GOGRAM_INVITE_KEY)")
+	apiKeys := flag.String("api-keys", "", `file of API keys, one "KEY USER_ID [EMAIL]" per line, for apikey auth`)
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time to wait for requests and tasks to finish when stopping")
+	sweepInterval := flag.Duration("sweep-interval", 24*time.Hour, "time between sweeps of orphaned images and posts, or 0 to never sweep")
+	sweepDryRun := flag.Bool("sweep-dry-run", false, "only log what sweeps would delete")
+	purgeInterval := flag.Duration("purge-interval", 24*time.Hour, "time between purges of expired trash, or 0 to never purge")
+	flag.Parse()
+
+	// There is no default scheme. Proxy auth trusts headers which any client can send unless
+	// a proxy strips them, and dev auth signs in everyone.
+	if strings.TrimSpace(*authSchemes) == "" {
+		stdlog.Fatal("-auth is required, e.g. -auth dev for local development.")
+	}
+
+	// Personal access tokens are accepted whichever way users sign in.
+	authenticators := []auth.Authenticator{api.AccessTokens{}}
+	var oidc *auth.OIDC
+	for _, scheme := range strings.Split(*authSchemes, ",") {
+		switch strings.TrimSpace(scheme) {
+		case "proxy":
+			stdlog.Printf("Trusting the %v and %v headers for proxy auth. The proxy must strip them from client requests.", *idHeader, *emailHeader)
+			authenticators = append(authenticators, &auth.Proxy{IDHeader: *idHeader, EmailHeader: *emailHeader})
+		case "dev":
+			stdlog.Printf("Signing in every request as %q for dev auth. Do not use dev auth outside local development.", *devUser)
+			authenticators = append(authenticators, &auth.Dev{ID: *devUser, Email: *devUser + "@example.com"})
+		case "oidc":
+			if *oidcIssuer == "" || *oidcClientID == "" || *sessionKey == "" {
+				stdlog.Fatal("-oidc-issuer, -oidc-client-id and -session-key are required for oidc auth.")
+			}
+			provider, err := auth.Discover(context.Background(), *oidcIssuer, nil)
+			if err != nil {
+				stdlog.Fatalf("Failed to discover OpenID Connect provider: %v", err)
+			}
+			oidc = &auth.OIDC{
+				Provider:     provider,
+				ClientID:     *oidcClientID,
+				ClientSecret: *oidcClientSecret,
+				RedirectURL:  *oidcRedirectURL,
+				Sessions: &auth.Sessions{
+					Key:    []byte(*sessionKey),
+					MaxAge: *sessionAge,
+					Secure: strings.HasPrefix(*oidcRedirectURL, "https:"),
+				},
+			}
+			authenticators = append(authenticators, oidc)
+		case "jwt":
+			if *jwtSecret == "" {
+				stdlog.Fatal("-jwt-secret is required for jwt auth.")
+			}
+			authenticators = append(authenticators, &auth.JWT{
+				Secret:   []byte(*jwtSecret),
+				Issuer:   *jwtIssuer,
+				Audience: *jwtAudience,
+				Leeway:   time.Minute,
+			})
+		case "apikey":
+			keys, err := loadAPIKeys(*apiKeys)
+			if err != nil {
+				stdlog.Fatalf("Failed to load API keys: %v", err)
+			}
+			authenticators = append(authenticators, keys)
+		default:
+			stdlog.Fatalf("Unknown auth %q.", scheme)
+		}
+	}
+	middleware.Authenticator = auth.Chain(authenticators...)
+	middleware.LoginURL = func(r *http.Request, dest string) (string, error) {
+		return "", nil
+	}
+	if oidc != nil {
+		middleware.LoginURL = oidc.LoginURL
+	}
+
+	if err := cfg.Apply(); err != nil {
+		stdlog.Fatalf("Failed to configure storage: %v", err)
+	}
+	defer cfg.Close()
+
+	app.Dir = *appDir
+	api.InviteKey = []byte(*inviteKey)
+
+	// Tasks are sent straight to the router, like push tasks on App Engine, which are
+	// made by an administrator rather than a signed in user.
+	router := app.Router()
+	queue := tasks.NewLocalQueue(map[string]http.Handler{
+		"image-processor": http.HandlerFunc(imgproc.ProcessImage),
+		"default":         router,
+	})
+	tasks.Default = queue
+
+	mux := http.NewServeMux()
+	mux.Handle("/", middleware.Authorize(router))
+	if oidc != nil {
+		mux.Handle("/auth/", oidc)
+	}
+	mux.Handle("/w/", http.StripPrefix("/w/", http.FileServer(http.Dir(filepath.Join(*appDir, "static")))))
+	if local, ok := imgstore.Store.(*imgstore.LocalStore); ok && strings.HasPrefix(local.BaseURL, "/") {
+		// Local images are served by this server unless their URL points elsewhere. Exports
+		// are private, and only served by DownloadExport.
+		prefix := strings.TrimSuffix(local.BaseURL, "/") + "/"
+		mux.Handle(prefix, http.StripPrefix(prefix, local))
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: mux}
+
+	stopCron := make(chan struct{})
+	if *sweepInterval > 0 {
+		go every(*sweepInterval, "/t/sweep", url.Values{"dryRun": {strconv.FormatBool(*sweepDryRun)}}, stopCron)
+	}
+	if *purgeInterval > 0 {
+		go every(*purgeInterval, "/t/purge-trash", nil, stopCron)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		stdlog.Print("Shutting down...")
+		close(stopCron)
+		c, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(c); err != nil {
+			stdlog.Printf("Failed to stop server: %v", err)
+		}
+		if err := queue.Shutdown(c); err != nil {
+			stdlog.Printf("Failed to stop task queue: %v", err)
+		}
+		close(stopped)
+	}()
+
+	stdlog.Printf("Listening on %v", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		stdlog.Fatal(err)
+	}
+	<-stopped
+}
+
+// every adds a task to the DELETION_QUEUE every interval, like cron does on App Engine,
+// until stop is closed.
+func every(interval time.Duration, path string, params url.Values, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := tasks.Add(context.Background(), api.DELETION_QUEUE, path, params); err != nil {
+				stdlog.Printf("Failed to add task %v: %v", path, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// loadAPIKeys reads the API keys in the file at path.
+func loadAPIKeys(path string) (*auth.APIKeys, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-api-keys is required for apikey auth")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return auth.LoadAPIKeys(f)
+}
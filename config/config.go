@@ -0,0 +1,161 @@
+// Package config selects the storage backends used by gogram when running outside of
+// App Engine, where Datastore and Cloud Storage are not the only choices.
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strconv"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/cache"
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/sqlstore"
+	"github.com/reedperry/gogram/uid"
+)
+
+// Config describes where users, events and posts, and uploaded images are kept, and how
+// IDs are generated.
+type Config struct {
+	// Node is the ID generator node, which must be different for every running server.
+	Node int
+
+	// Store is "datastore", "memory", "sqlite3" or "postgres".
+	Store string
+	// DSN is the data source name of a sqlite3 or postgres database.
+	DSN string
+	// CacheSize is the number of users, events and posts each kept in an in-process
+	// cache. Zero disables caching.
+	CacheSize int
+
+	// Blobs is "gcs", "local" or "s3".
+	Blobs string
+	// BlobDir and BlobURL configure "local" blobs. See imgstore.LocalStore.
+	BlobDir string
+	BlobURL string
+	// Bucket is the GCS or S3 bucket. The app's default bucket is used for GCS if empty.
+	Bucket string
+
+	S3Endpoint  string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+	S3ACL       string
+	S3PublicURL string
+
+	db *sqlstore.Store
+}
+
+// RegisterFlags defines command line flags for each setting in fs. The node defaults to
+// the GOGRAM_NODE_ID environment variable, and S3 credentials default to the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, so that they don't
+// have to be passed on the command line.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	node, _ := strconv.Atoi(os.Getenv("GOGRAM_NODE_ID"))
+	fs.IntVar(&cfg.Node, "node", node, "ID generator node, unique to each server, from 0 to 1023")
+
+	fs.StringVar(&cfg.Store, "store", "memory", `where data is stored: "datastore", "memory", "sqlite3" or "postgres"`)
+	fs.StringVar(&cfg.DSN, "dsn", "gogram.db", "data source name of the sqlite3 or postgres database")
+	fs.IntVar(&cfg.CacheSize, "cache-size", 10000, "number of users, events and posts each kept in memory, or 0 to disable caching")
+
+	fs.StringVar(&cfg.Blobs, "blobs", "local", `where images are stored: "gcs", "local" or "s3"`)
+	fs.StringVar(&cfg.BlobDir, "blob-dir", "blobs", "directory holding images, for local blobs")
+	fs.StringVar(&cfg.BlobURL, "blob-url", "/img/", "base URL of images, for local blobs")
+	fs.StringVar(&cfg.Bucket, "bucket", "", "GCS or S3 bucket holding images")
+
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "base URL of the S3 service")
+	fs.StringVar(&cfg.S3Region, "s3-region", "us-east-1", "S3 region")
+	fs.StringVar(&cfg.S3AccessKey, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key")
+	fs.StringVar(&cfg.S3SecretKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret key")
+	fs.StringVar(&cfg.S3ACL, "s3-acl", "public-read", "canned ACL applied to new S3 images, while exports are always private")
+	fs.StringVar(&cfg.S3PublicURL, "s3-public-url", "", "base URL of image links, if not the bucket URL")
+}
+
+// Apply replaces api.IDs, the stores in package api and imgstore.Store with the configured
+// backends, putting the user, event and post stores behind caches unless CacheSize is
+// zero. Close must be called when they are no longer used.
+func (cfg *Config) Apply() error {
+	ids, err := uid.NewGenerator(cfg.Node)
+	if err != nil {
+		return err
+	}
+	api.IDs = ids
+
+	switch cfg.Store {
+	case "datastore":
+		// The Datastore stores are the defaults.
+	case "memory":
+		api.Users = api.NewMemoryUserStore()
+		api.Events = api.NewMemoryEventStore()
+		api.Posts = api.NewMemoryPostStore()
+		api.Jobs = api.NewMemoryJobStore()
+		api.DeadLetters = api.NewMemoryDeadLetterStore()
+		api.Trash = api.NewMemoryTrashStore()
+		api.Tokens = api.NewMemoryTokenStore()
+		api.Members = api.NewMemoryMemberStore()
+		api.InviteLinks = api.NewMemoryInviteLinkStore()
+	case "sqlite3", "postgres":
+		db, err := sqlstore.Open(cfg.Store, cfg.DSN)
+		if err != nil {
+			return err
+		}
+		cfg.db = db
+		api.Users = db.Users()
+		api.Events = db.Events()
+		api.Posts = db.Posts()
+		api.Jobs = db.Jobs()
+		api.DeadLetters = db.DeadLetters()
+		api.Trash = db.Trash()
+		api.Tokens = db.Tokens()
+		api.Members = db.Members()
+		api.InviteLinks = db.InviteLinks()
+	default:
+		return errors.New("config: unknown store " + cfg.Store)
+	}
+
+	if cfg.CacheSize > 0 {
+		api.Users = api.NewCachedUserStore(api.Users, cache.NewLRU(cfg.CacheSize))
+		api.Events = api.NewCachedEventStore(api.Events, cache.NewLRU(cfg.CacheSize))
+		api.Posts = api.NewCachedPostStore(api.Posts, cache.NewLRU(cfg.CacheSize))
+	}
+
+	switch cfg.Blobs {
+	case "gcs":
+		imgstore.Store = &imgstore.GCSStore{Bucket: cfg.Bucket}
+	case "local":
+		if err := os.MkdirAll(cfg.BlobDir, 0755); err != nil {
+			return err
+		}
+		imgstore.Store = &imgstore.LocalStore{Dir: cfg.BlobDir, BaseURL: cfg.BlobURL}
+	case "s3":
+		if cfg.Bucket == "" {
+			return errors.New("config: a bucket is required for s3 blobs")
+		}
+		imgstore.Store = &imgstore.S3Store{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			ACL:       cfg.S3ACL,
+			PublicURL: cfg.S3PublicURL,
+		}
+	default:
+		return errors.New("config: unknown blob store " + cfg.Blobs)
+	}
+
+	return nil
+}
+
+// Close releases the database connection opened by Apply, if any.
+func (cfg *Config) Close() error {
+	if cfg.db == nil {
+		return nil
+	}
+
+	return cfg.db.Close()
+}
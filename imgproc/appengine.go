@@ -0,0 +1,12 @@
+//go:build appengine
+// +build appengine
+
+package imgproc
+
+import (
+	"net/http"
+)
+
+func init() {
+	http.Handle("/", http.HandlerFunc(ProcessImage))
+}
@@ -1,85 +1,82 @@
 package imgproc
 
 import (
-	"appengine"
-	"github.com/reedperry/gogram/imgstore"
+	"bytes"
 	"net/http"
-)
 
-func init() {
-	http.Handle("/", http.HandlerFunc(ProcessImage))
-}
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/imgstore"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
+)
 
 func ProcessImage(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := api.NewContext(r)
 
 	if isAppEngineModuleRequest(r) {
 		return
 	}
 
-	if r.Header.Get("X-AppEngine-QueueName") == "" {
-		c.Errorf("Request missing required header for a Task Queue request. Processing aborted.")
+	if !tasks.IsTaskRequest(r) {
+		log.Errorf(c, "Request missing required header for a Task Queue request. Processing aborted.")
 		return
 	}
 
 	filename := r.FormValue("filename")
 	if filename == "" {
-		c.Errorf("Form Value 'filename' is missing or empty.")
+		log.Errorf(c, "Form Value 'filename' is missing or empty.")
 		http.Error(w, "Failed to process image.", http.StatusBadRequest)
 		return
 	}
 
-	filetype, err := imgstore.Filetype(filename, r)
+	filetype, err := imgstore.Filetype(c, filename)
 	if err != nil {
-		c.Errorf("Cannot process image %v: %v", filename, err)
+		log.Errorf(c, "Cannot process image %v: %v", filename, err)
 		http.Error(w, "Failed to process image.", http.StatusInternalServerError)
 		return
 	}
 
-	c.Infof("Processing image %v of type %v...", filename, filetype)
+	log.Infof(c, "Processing image %v of type %v...", filename, filetype)
 
 	tr := &ThumbnailSizer{}
-	err = doResize(tr, filename, filetype, r)
+	err = doResize(tr, filename, filetype, c)
 	if err != nil {
 		http.Error(w, "Failed to process image.", http.StatusInternalServerError)
 		return
 	}
 
 	vr := &ViewSizer{}
-	err = doResize(vr, filename, filetype, r)
+	err = doResize(vr, filename, filetype, c)
 }
 
-func doResize(sizer resizer, filename, filetype string, r *http.Request) error {
-	c := appengine.NewContext(r)
-
+func doResize(sizer resizer, filename, filetype string, c context.Context) error {
 	// Read file out of storage
-	reader, err := imgstore.Reader(filename, r)
+	reader, err := imgstore.Reader(c, filename)
 	if err != nil {
-		c.Errorf("Failed to open file %v: %v", filename, err)
+		log.Errorf(c, "Failed to open file %v: %v", filename, err)
 		return err
 	}
 
 	defer reader.Close()
 
 	newName := sizer.Filename(filename)
-	writer, err := imgstore.Writer(newName, r)
-	if err != nil {
-		c.Errorf("Failed to open new file %v for writing: %v", newName, err)
-		return err
-	}
 
-	defer writer.Close()
+	log.Infof(c, "Creating thumbnail %v of type %v from file %v.", newName, filetype, filename)
 
-	writer.ContentType = filetype
-
-	c.Infof("Creating thumbnail %v of type %v from file %v.", newName, filetype, filename)
+	var resized bytes.Buffer
+	if err = sizer.Resize(filetype, reader, &resized); err != nil {
+		log.Errorf(c, "Failed to create thumbnail from image %v: %v", filename, err)
+		return err
+	}
 
-	if err = sizer.Resize(filetype, reader, writer); err != nil {
-		c.Errorf("Failed to create thumbnail from image %v: %v", filename, err)
+	if _, err = imgstore.Write(c, newName, filetype, &resized); err != nil {
+		log.Errorf(c, "Failed to write new file %v: %v", newName, err)
 		return err
 	}
 
-	c.Infof("Created resized image: %v.", newName)
+	log.Infof(c, "Created resized image: %v.", newName)
 
 	return nil
 }
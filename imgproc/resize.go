@@ -2,7 +2,7 @@ package imgproc
 
 import (
 	"github.com/nfnt/resize"
-	"google.golang.org/cloud/storage"
+	"github.com/reedperry/gogram/imgstore"
 
 	"errors"
 	"image"
@@ -20,30 +20,30 @@ const PNG = "image/png"
 const GIF = "image/gif"
 
 type resizer interface {
-	Resize(string, io.Reader, *storage.Writer) error
+	Resize(string, io.Reader, io.Writer) error
 	Filename(string) string
 }
 
 type ThumbnailSizer struct{}
 type ViewSizer struct{}
 
-func (t *ThumbnailSizer) Resize(filetype string, r io.Reader, w *storage.Writer) error {
+func (t *ThumbnailSizer) Resize(filetype string, r io.Reader, w io.Writer) error {
 	return createSizedCopy(TN_SIZE, filetype, r, w)
 }
 
 func (t *ThumbnailSizer) Filename(filename string) string {
-	return filename + "_thumb"
+	return filename + imgstore.THUMB_SUFFIX
 }
 
-func (v *ViewSizer) Resize(filetype string, r io.Reader, w *storage.Writer) error {
+func (v *ViewSizer) Resize(filetype string, r io.Reader, w io.Writer) error {
 	return createSizedCopy(VIEW_SIZE, filetype, r, w)
 }
 
 func (v *ViewSizer) Filename(filename string) string {
-	return filename + "_view"
+	return filename + imgstore.VIEW_SUFFIX
 }
 
-func createSizedCopy(maxSize uint, filetype string, r io.Reader, w *storage.Writer) error {
+func createSizedCopy(maxSize uint, filetype string, r io.Reader, w io.Writer) error {
 	img, err := decodeImage(r, filetype)
 	if err != nil {
 		return errors.New("Failed to decode image: " + err.Error())
@@ -75,7 +75,7 @@ func decodeImage(reader io.Reader, filetype string) (img image.Image, err error)
 	return
 }
 
-func encodeImage(w *storage.Writer, filetype string, m image.Image) (err error) {
+func encodeImage(w io.Writer, filetype string, m image.Image) (err error) {
 	switch filetype {
 	case JPEG, JPG:
 		err = jpeg.Encode(w, m, nil)
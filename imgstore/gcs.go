@@ -3,263 +3,211 @@ package imgstore
 import (
 	"errors"
 	"io"
-	"net/http"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
 
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/file"
-	"google.golang.org/appengine/log"
 	"google.golang.org/cloud"
 	"google.golang.org/cloud/storage"
-)
-
-var bucket string
-
-func Create(filename string, r *http.Request) (*storage.Object, error) {
-	c := appengine.NewContext(r)
-	bucket, err := file.DefaultBucketName(c)
-	if err != nil {
-		log.Errorf(c, "Failed to get default bucket: %v", err)
-		return nil, err
-	}
-
-	ctx, err := auth(r)
-	if err != nil {
-		log.Errorf(c, "Failed to get context: %v", err)
-		return nil, err
-	}
 
-	log.Infof(c, "Recieved post with content length %v", r.ContentLength)
+	"github.com/reedperry/gogram/log"
+)
 
-	w := storage.NewWriter(ctx, bucket, filename)
+// GCSStore keeps objects in a Google Cloud Storage bucket. The app's default bucket
+// is used when Bucket is empty.
+type GCSStore struct {
+	Bucket string
+}
 
-	file, header, err := r.FormFile("image")
+func (s *GCSStore) Put(c context.Context, name, contentType string, r io.Reader) (*Object, error) {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		log.Errorf(c, "Failed to read form file: %v", err)
 		return nil, err
 	}
 
-	log.Infof(c, "File Header:\nFilename = %v\nHeader Data = %v", header.Filename, header.Header)
-
-	sample := make([]byte, 512)
-	read, err := file.Read(sample)
-	if err != nil {
-		log.Warningf(c, "Failed to sniff content type from file sample: %v", err)
-	} else {
-		ct := http.DetectContentType(sample)
-		log.Infof(c, "Sniffed content type: %v", ct)
-		valid := validateContentType(ct)
-		if !valid {
-			log.Warningf(c, "Invalid Content-Type '%v'. Aborting upload.", ct)
-			return nil, errors.New("Invalid file type.")
+	w := storage.NewWriter(ctx, bucket, name)
+	w.ContentType = contentType
+	if IsPrivate(name) {
+		w.ACL, err = privateACL(ctx, bucket)
+		if err != nil {
+			log.Errorf(c, "Failed to get default ACL of bucket %v: %v", bucket, err)
+			return nil, err
 		}
-		w.ContentType = ct
 	}
 
-	log.Infof(c, "Writing %v sample bytes to file.", read)
-	sampleWritten, err := w.Write(sample)
-	if err != nil {
-		log.Errorf(c, "Error during write of file sample bytes. Wrote %v. %v", sampleWritten, err)
-		w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		log.Errorf(c, "Error during write of file %v: %v", name, err)
+		w.CloseWithError(err)
 		return nil, err
 	}
 
-	log.Infof(c, "Copying remainder of file...")
-	written, err := io.Copy(w, file)
-
-	log.Infof(c, "Done. Wrote %v bytes.", written)
-
 	err = w.Close()
 	if err != nil {
 		log.Errorf(c, "Failed to close writer: %v", err)
 		return nil, err
 	}
 
-	obj := w.Object()
-
-	return obj, nil
+	return gcsObject(w.Object()), nil
 }
 
-func Read(filename string, w http.ResponseWriter, r *http.Request) error {
-	c := appengine.NewContext(r)
-
-	rc, err := Reader(filename, r)
+// privateACL returns the bucket's default object ACL without the rules granting public
+// access, for objects which must not be made public.
+func privateACL(ctx context.Context, bucket string) ([]storage.ACLRule, error) {
+	rules, err := storage.DefaultACL(ctx, bucket)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer rc.Close()
-
-	read, err := io.Copy(w, rc)
-	if err != nil {
-		log.Errorf(c, "Failed to create reader for file: %v", err)
-		return err
+	acl := make([]storage.ACLRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Entity != storage.AllUsers && rule.Entity != storage.AllAuthenticatedUsers {
+			acl = append(acl, rule)
+		}
+	}
+	// An empty ACL would leave the bucket's default in place.
+	if len(acl) == 0 {
+		return nil, errors.New("imgstore: bucket has no private default ACL rules")
 	}
 
-	log.Infof(c, "Read %v bytes.", read)
-
-	return nil
+	return acl, nil
 }
 
-func Reader(filename string, r *http.Request) (io.ReadCloser, error) {
-	c := appengine.NewContext(r)
-	bucket, err := file.DefaultBucketName(c)
+func (s *GCSStore) Get(c context.Context, name string) (io.ReadCloser, error) {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get default bucket: %v", err)
 		return nil, err
 	}
 
-	ctx, err := auth(r)
-	if err != nil {
-		log.Errorf(c, "Failed to get context: %v", err)
-		return nil, err
-	}
-
-	log.Infof(c, "Retrieving file %v from bucket %v.", filename, bucket)
-
-	rc, err := storage.NewReader(ctx, bucket, filename)
-	if err != nil {
-		log.Errorf(c, "Failed to open file: %v", err)
-		return nil, err
+	rc, err := storage.NewReader(ctx, bucket, name)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
 	}
 
-	return rc, nil
+	return rc, err
 }
 
-func Writer(filename string, r *http.Request) (*storage.Writer, error) {
-	c := appengine.NewContext(r)
-	bucket, err := file.DefaultBucketName(c)
+func (s *GCSStore) Stat(c context.Context, name string) (*Object, error) {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get default bucket: %v", err)
 		return nil, err
 	}
 
-	ctx, err := auth(r)
+	obj, err := storage.StatObject(ctx, bucket, name)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
+	}
 	if err != nil {
-		log.Errorf(c, "Failed to get context: %v", err)
 		return nil, err
 	}
 
-	w := storage.NewWriter(ctx, bucket, filename)
-
-	return w, nil
+	return gcsObject(obj), nil
 }
-func MediaLink(filename string, r *http.Request) (string, error) {
-	obj, err := FileStats(filename, r)
+
+func (s *GCSStore) Delete(c context.Context, name string) error {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		c := appengine.NewContext(r)
-		log.Errorf(c, "Failed to get file stats: %v", err)
-		return "", err
+		return err
 	}
 
-	return obj.MediaLink, nil
-}
-
-func Filetype(filename string, r *http.Request) (string, error) {
-	obj, err := FileStats(filename, r)
-	if err != nil {
-		c := appengine.NewContext(r)
-		log.Errorf(c, "Failed to get file stats: %v", err)
-		return "", err
+	// StatObject is used here to check existence before calling DeleteObject.
+	// If the object does not exist, DeleteObject returns an error that is NOT
+	// ErrObjectNotExist, so it seemed more reliable to check with StatObject first...
+	_, err = storage.StatObject(ctx, bucket, name)
+	if err == storage.ErrObjectNotExist {
+		log.Warningf(c, "Object does not exist, nothing to delete.")
+		return nil
 	}
 
-	return obj.ContentType, nil
+	return storage.DeleteObject(ctx, bucket, name)
 }
 
-func FileStats(filename string, r *http.Request) (*storage.Object, error) {
-	c := appengine.NewContext(r)
-	bucket, err := file.DefaultBucketName(c)
+func (s *GCSStore) Revoke(c context.Context, name string) error {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get default bucket: %v", err)
-		return nil, err
+		return err
 	}
 
-	ctx, err := auth(r)
-	if err != nil {
-		log.Errorf(c, "Failed to get context: %v", err)
-		return nil, err
+	// Like DeleteObject, DeleteACLRule does not return ErrObjectNotExist for a missing object.
+	_, err = storage.StatObject(ctx, bucket, name)
+	if err == storage.ErrObjectNotExist {
+		return nil
 	}
 
-	log.Infof(c, "Getting stats for file %v from bucket %v.", filename, bucket)
+	return storage.DeleteACLRule(ctx, bucket, name, storage.AllUsers)
+}
 
-	obj, err := storage.StatObject(ctx, bucket, filename)
+func (s *GCSStore) URL(c context.Context, name string) (string, error) {
+	bucket, err := s.bucket(c)
 	if err != nil {
-		log.Errorf(c, "Failed to stat file: %v", err)
-		return nil, err
+		return "", err
 	}
 
-	return obj, nil
+	return "https://storage.googleapis.com/" + bucket + "/" + name, nil
 }
 
-// Delete removes an object by name from the bucket being used. If the object does not
-// exist and there is nothing to delete, Delete returns with no error.
-// TODO Delete thumbnail and web view copies too!
-func Delete(filename string, r *http.Request) error {
-	c := appengine.NewContext(r)
-	bucket, err := file.DefaultBucketName(c)
+func (s *GCSStore) List(c context.Context, prefix, cursor string, limit int) ([]*Object, string, error) {
+	bucket, ctx, err := s.auth(c)
 	if err != nil {
-		log.Errorf(c, "Failed to get default bucket: %v", err)
-		return err
+		return nil, "", err
 	}
 
-	ctx, err := auth(r)
+	objs, err := storage.ListObjects(ctx, bucket, &storage.Query{Prefix: prefix, Cursor: cursor, MaxResults: limit})
 	if err != nil {
-		log.Errorf(c, "Failed to get context: %v", err)
-		return err
+		return nil, "", err
 	}
 
-	log.Infof(c, "Attempting to delete file %v from bucket %v.", filename, bucket)
-
-	// StatObject is used here to check existence before calling DeleteObject.
-	// If the object does not exist, DeleteObject returns an error that is NOT
-	// ErrObjectNotExist, so it seemed more reliable to check with StatObject first...
-	_, err = storage.StatObject(ctx, bucket, filename)
-	if err == storage.ErrObjectNotExist {
-		log.Warningf(c, "Object does not exist, nothing to delete.")
-		return nil
+	page := make([]*Object, len(objs.Results))
+	for i, obj := range objs.Results {
+		page[i] = gcsObject(obj)
 	}
 
-	err = storage.DeleteObject(ctx, bucket, filename)
-	if err != nil {
-		log.Errorf(c, "Failed to delete file.")
-
-		log.Infof(c, "Attempting to remove public access to file...")
-		aclErr := storage.DeleteACLRule(ctx, bucket, filename, storage.AllUsers)
-		if aclErr != nil {
-			log.Errorf(c, "Failed to remove public file access!")
-		} else {
-			log.Infof(c, "File access removed.")
-		}
-
-		return err
+	next := ""
+	if objs.Next != nil {
+		next = objs.Next.Cursor
 	}
 
-	return nil
+	return page, next, nil
 }
 
-func ObjectLink(obj *storage.Object) string {
-	return "https://storage.googleapis.com/" + obj.Bucket + "/" + obj.Name
-}
+func (s *GCSStore) bucket(c context.Context) (string, error) {
+	if s.Bucket != "" {
+		return s.Bucket, nil
+	}
 
-func validateContentType(filetype string) bool {
-	if filetype == "" {
-		return false
+	bucket, err := file.DefaultBucketName(c)
+	if err != nil {
+		log.Errorf(c, "Failed to get default bucket: %v", err)
+		return "", err
 	}
 
-	return filetype == "image/png" || filetype == "image/jpg" ||
-		filetype == "image/jpeg" || filetype == "image/gif"
+	return bucket, nil
 }
 
-func auth(r *http.Request) (context.Context, error) {
-	c := appengine.NewContext(r)
+// auth returns the bucket to use, and a context authorized to access it.
+func (s *GCSStore) auth(c context.Context) (string, context.Context, error) {
+	bucket, err := s.bucket(c)
+	if err != nil {
+		return "", nil, err
+	}
+
 	client, err := google.DefaultClient(c, storage.ScopeFullControl)
 	if err != nil {
-		return nil, err
+		log.Errorf(c, "Failed to get context: %v", err)
+		return "", nil, err
 	}
 
 	ctx := cloud.NewContext(appengine.AppID(c), client)
 
-	return ctx, nil
+	return bucket, ctx, nil
+}
+
+func gcsObject(obj *storage.Object) *Object {
+	return &Object{
+		Name:        obj.Name,
+		ContentType: obj.ContentType,
+		Size:        obj.Size,
+		Updated:     obj.Updated,
+	}
 }
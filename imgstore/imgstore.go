@@ -0,0 +1,242 @@
+package imgstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/log"
+)
+
+// ErrObjectNotExist is returned by a BlobStore when the named object does not exist.
+var ErrObjectNotExist = errors.New("imgstore: object doesn't exist")
+
+// Object describes a blob held in a BlobStore.
+type Object struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Updated     time.Time
+}
+
+// BlobStore is a storage backend for image files and their resized copies.
+type BlobStore interface {
+	// Put stores the contents of r under name, replacing any existing object. Objects
+	// named with PRIVATE_PREFIX are created without public access.
+	Put(ctx context.Context, name, contentType string, r io.Reader) (*Object, error)
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	Stat(ctx context.Context, name string) (*Object, error)
+	// Delete removes the named object. Deleting an object that does not exist is not an error.
+	Delete(ctx context.Context, name string) error
+	// Revoke removes public access to the named object, so that its link stops working
+	// even if it cannot be deleted yet. Revoking an object that does not exist is not an error.
+	Revoke(ctx context.Context, name string) error
+	// URL returns the public link to the named object.
+	URL(ctx context.Context, name string) (string, error)
+	// List returns up to limit objects whose names start with prefix, sorted by name and
+	// starting after cursor, along with the cursor of the next page. The first page is
+	// listed with an empty cursor, and the next cursor is empty on the last page.
+	List(ctx context.Context, prefix, cursor string, limit int) ([]*Object, string, error)
+}
+
+// Store is the BlobStore used by all of the functions in this package.
+var Store BlobStore = &GCSStore{}
+
+// Objects whose names start with PRIVATE_PREFIX hold users' data exports, and are never
+// made public. Stores create them without public access, and LocalStore does not serve
+// them, so they can only be read with Get.
+const PRIVATE_PREFIX = "exports/"
+
+// IsPrivate reports whether the named object must not be made public.
+func IsPrivate(name string) bool {
+	return strings.HasPrefix(cleanName(name), PRIVATE_PREFIX)
+}
+
+// Suffixes added to the name of an image to name its resized copies.
+const (
+	THUMB_SUFFIX = "_thumb"
+	VIEW_SUFFIX  = "_view"
+)
+
+// VariantNames returns the names of the resized copies of an image.
+func VariantNames(filename string) []string {
+	return []string{filename + THUMB_SUFFIX, filename + VIEW_SUFFIX}
+}
+
+// Create stores the image uploaded in the "image" field of a multipart form request.
+func Create(c context.Context, filename string, r *http.Request) (*Object, error) {
+	log.Infof(c, "Recieved post with content length %v", r.ContentLength)
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		log.Errorf(c, "Failed to read form file: %v", err)
+		return nil, err
+	}
+
+	defer file.Close()
+
+	log.Infof(c, "File Header:\nFilename = %v\nHeader Data = %v", header.Filename, header.Header)
+
+	sample := make([]byte, 512)
+	read, err := io.ReadFull(file, sample)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		log.Errorf(c, "Failed to read file sample: %v", err)
+		return nil, err
+	}
+
+	sample = sample[:read]
+
+	ct := http.DetectContentType(sample)
+	log.Infof(c, "Sniffed content type: %v", ct)
+	if !validateContentType(ct) {
+		log.Warningf(c, "Invalid Content-Type '%v'. Aborting upload.", ct)
+		return nil, errors.New("Invalid file type.")
+	}
+
+	obj, err := Write(c, filename, ct, io.MultiReader(bytes.NewReader(sample), file))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof(c, "Done. Wrote %v bytes.", obj.Size)
+
+	return obj, nil
+}
+
+// Write stores the contents of src as a new object.
+func Write(c context.Context, filename, contentType string, src io.Reader) (*Object, error) {
+	obj, err := Store.Put(c, filename, contentType, src)
+	if err != nil {
+		log.Errorf(c, "Failed to write file %v: %v", filename, err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func Read(c context.Context, filename string, w http.ResponseWriter) error {
+	rc, err := Reader(c, filename)
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	read, err := io.Copy(w, rc)
+	if err != nil {
+		log.Errorf(c, "Failed to create reader for file: %v", err)
+		return err
+	}
+
+	log.Infof(c, "Read %v bytes.", read)
+
+	return nil
+}
+
+func Reader(c context.Context, filename string) (io.ReadCloser, error) {
+	log.Infof(c, "Retrieving file %v.", filename)
+
+	rc, err := Store.Get(c, filename)
+	if err != nil {
+		log.Errorf(c, "Failed to open file: %v", err)
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// Link returns the public URL of a stored file.
+func Link(c context.Context, filename string) (string, error) {
+	link, err := Store.URL(c, filename)
+	if err != nil {
+		log.Errorf(c, "Failed to get link to file %v: %v", filename, err)
+		return "", err
+	}
+
+	return link, nil
+}
+
+func Filetype(c context.Context, filename string) (string, error) {
+	obj, err := FileStats(c, filename)
+	if err != nil {
+		return "", err
+	}
+
+	return obj.ContentType, nil
+}
+
+func FileStats(c context.Context, filename string) (*Object, error) {
+	log.Infof(c, "Getting stats for file %v.", filename)
+
+	obj, err := Store.Stat(c, filename)
+	if err != nil {
+		log.Errorf(c, "Failed to stat file: %v", err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// Delete removes an object by name from the store being used, along with its resized
+// copies. If an object does not exist and there is nothing to delete, Delete returns
+// with no error.
+func Delete(c context.Context, filename string) error {
+	log.Infof(c, "Attempting to delete file %v.", filename)
+
+	// The original is deleted last, so that if deleting a copy fails, the original is
+	// still there to find the copies by.
+	names := append(VariantNames(filename), filename)
+	for _, name := range names {
+		err := Store.Delete(c, name)
+		if err != nil {
+			log.Errorf(c, "Failed to delete file %v: %v", name, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Revoke removes public access to an object and its resized copies. Every copy is
+// revoked even if revoking another one fails, and the first error is returned.
+func Revoke(c context.Context, filename string) error {
+	log.Infof(c, "Revoking public access to file %v.", filename)
+
+	var firstErr error
+	for _, name := range append(VariantNames(filename), filename) {
+		err := Store.Revoke(c, name)
+		if err != nil {
+			log.Errorf(c, "Failed to revoke public access to file %v: %v", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// List returns a page of the objects whose names start with prefix. See BlobStore.List.
+func List(c context.Context, prefix, cursor string, limit int) ([]*Object, string, error) {
+	objs, next, err := Store.List(c, prefix, cursor, limit)
+	if err != nil {
+		log.Errorf(c, "Failed to list files with prefix %q: %v", prefix, err)
+		return nil, "", err
+	}
+
+	return objs, next, nil
+}
+
+func validateContentType(filetype string) bool {
+	if filetype == "" {
+		return false
+	}
+
+	return filetype == "image/png" || filetype == "image/jpg" ||
+		filetype == "image/jpeg" || filetype == "image/gif"
+}
@@ -0,0 +1,197 @@
+package imgstore
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// LocalStore keeps objects as files under a directory on local disk, which is useful for
+// development without access to Cloud Storage. Object links are formed by appending
+// the object name to BaseURL, and LocalStore can serve them itself as an http.Handler.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s *LocalStore) Put(c context.Context, name, contentType string, r io.Reader) (*Object, error) {
+	filename := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so readers never see a partial object.
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".upload")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return s.Stat(c, name)
+}
+
+func (s *LocalStore) Get(c context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+
+	return f, err
+}
+
+func (s *LocalStore) Stat(c context.Context, name string) (*Object, error) {
+	f, err := os.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The content type is not kept alongside the file, so sniff it like Create does.
+	sample := make([]byte, 512)
+	read, err := io.ReadFull(f, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return &Object{
+		Name:        name,
+		ContentType: http.DetectContentType(sample[:read]),
+		Size:        info.Size(),
+		Updated:     info.ModTime(),
+	}, nil
+}
+
+func (s *LocalStore) Delete(c context.Context, name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Revoke does nothing, since a LocalStore has no access control. Its objects are only
+// meant to be served during development.
+func (s *LocalStore) Revoke(c context.Context, name string) error {
+	return nil
+}
+
+func (s *LocalStore) URL(c context.Context, name string) (string, error) {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + cleanName(name), nil
+}
+
+// List walks the whole directory, so it is only suited to the small number of objects
+// kept during development.
+func (s *LocalStore) List(c context.Context, prefix, cursor string, limit int) ([]*Object, string, error) {
+	var names []string
+	err := filepath.Walk(s.Dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip directories and uploads in progress.
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Dir, filename)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) && name > cursor {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return []*Object{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Strings(names)
+
+	next := ""
+	if len(names) > limit {
+		names = names[:limit]
+		next = names[limit-1]
+	}
+
+	objs := make([]*Object, 0, len(names))
+	for _, name := range names {
+		obj, err := s.Stat(c, name)
+		if err == ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, next, nil
+}
+
+// ServeHTTP serves stored objects by name, except private ones. Requests are expected to
+// have BaseURL's path stripped, e.g. by http.StripPrefix.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if IsPrivate(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(s.path(r.URL.Path))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// path returns the location of an object on disk. Names can never refer to a file
+// outside of Dir.
+func (s *LocalStore) path(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(cleanName(name)))
+}
+
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
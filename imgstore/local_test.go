@@ -0,0 +1,127 @@
+package imgstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// A 1x1 transparent GIF
+var gifData = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+func newTestLocalStore(t *testing.T) *LocalStore {
+	dir, err := ioutil.TempDir("", "imgstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &LocalStore{Dir: dir, BaseURL: "http://localhost:8080/img/"}
+}
+
+func TestLocalStore(t *testing.T) {
+	s := newTestLocalStore(t)
+	defer os.RemoveAll(s.Dir)
+	c := context.Background()
+
+	obj, err := s.Put(c, "user1/post1", "image/gif", bytes.NewReader(gifData))
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	if obj.Size != int64(len(gifData)) || obj.ContentType != "image/gif" {
+		t.Errorf("Put() returned object %+v. Wanted size %v and type image/gif.", obj, len(gifData))
+	}
+
+	rc, err := s.Get(c, "user1/post1")
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(data, gifData) {
+		t.Errorf("Get() returned different data than was stored.")
+	}
+
+	link, _ := s.URL(c, "user1/post1")
+	if link != "http://localhost:8080/img/user1/post1" {
+		t.Errorf("URL() returned %v.", link)
+	}
+
+	if err := s.Delete(c, "user1/post1"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := s.Stat(c, "user1/post1"); err != ErrObjectNotExist {
+		t.Errorf("Stat() of a deleted object returned %v. Wanted ErrObjectNotExist.", err)
+	}
+	if err := s.Delete(c, "user1/post1"); err != nil {
+		t.Errorf("Delete() of a missing object returned %v.", err)
+	}
+}
+
+func TestLocalStoreNames(t *testing.T) {
+	s := newTestLocalStore(t)
+	defer os.RemoveAll(s.Dir)
+	c := context.Background()
+
+	s.Put(c, "../../escape", "image/gif", bytes.NewReader(gifData))
+	if _, err := s.Stat(c, "escape"); err != nil {
+		t.Errorf("Object name was not kept inside the store directory: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/escape", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gifData) {
+		t.Errorf("ServeHTTP() returned status %v for a stored object.", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() returned status %v for a directory. Wanted 404.", w.Code)
+	}
+
+	s.Put(c, "exports/user1/export.zip", "application/zip", bytes.NewReader(gifData))
+	for _, url := range []string{"/exports/user1/export.zip", "/user1/../exports/user1/export.zip"} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("ServeHTTP() returned status %v for private object %v. Wanted 404.", w.Code, url)
+		}
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	s := newTestLocalStore(t)
+	defer os.RemoveAll(s.Dir)
+	c := context.Background()
+
+	for _, name := range []string{"user2/post3", "user1/post1_thumb", "user1/post1", "user1/post2"} {
+		s.Put(c, name, "image/gif", bytes.NewReader(gifData))
+	}
+
+	var names []string
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		objs, next, err := s.List(c, "user1/", cursor, 2)
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		for _, obj := range objs {
+			names = append(names, obj.Name)
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	want := "user1/post1 user1/post1_thumb user1/post2"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Listed %q. Wanted %q.", got, want)
+	}
+}
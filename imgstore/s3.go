@@ -0,0 +1,346 @@
+package imgstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// S3Store keeps objects in a bucket of Amazon S3 or any S3-compatible service, such as
+// MinIO. Objects are addressed path-style, as Endpoint/Bucket/name.
+type S3Store struct {
+	// Endpoint is the base URL of the service, e.g. "https://s3.amazonaws.com" or
+	// "http://localhost:9000".
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// ACL is a canned ACL, e.g. "public-read", applied to new objects. It is not sent if
+	// empty, and private objects are always created with the private canned ACL.
+	ACL string
+	// PublicURL is the base of object links. Endpoint/Bucket is used when it is empty.
+	PublicURL string
+	// Client is used to make requests, or http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// S3Error is returned when the service responds to a request with an unexpected status.
+type S3Error struct {
+	Status int
+	Body   string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("imgstore: S3 request failed with status %v: %v", e.Status, e.Body)
+}
+
+const s3TimeFormat = "20060102T150405Z"
+
+func (s *S3Store) Put(c context.Context, name, contentType string, r io.Reader) (*Object, error) {
+	// S3 needs to know the length of an upload up front, and the payload hash is part
+	// of the request signature, so the object is read into memory first.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if IsPrivate(name) {
+		req.Header.Set("X-Amz-Acl", "private")
+	} else if s.ACL != "" {
+		req.Header.Set("X-Amz-Acl", s.ACL)
+	}
+
+	resp, err := s.do(c, req, data)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &Object{
+		Name:        name,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Updated:     time.Now(),
+	}, nil
+}
+
+func (s *S3Store) Get(c context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(c, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3Store) Stat(c context.Context, name string) (*Object, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(c, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	updated, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return &Object{
+		Name:        name,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        size,
+		Updated:     updated,
+	}, nil
+}
+
+func (s *S3Store) Delete(c context.Context, name string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(c, req, nil)
+	if err == ErrObjectNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Revoke replaces the object's ACL with the private canned ACL.
+func (s *S3Store) Revoke(c context.Context, name string) error {
+	req, err := http.NewRequest("PUT", s.objectURL(name)+"?acl", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-Amz-Acl", "private")
+
+	resp, err := s.do(c, req, nil)
+	if err == ErrObjectNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *S3Store) URL(c context.Context, name string) (string, error) {
+	if s.PublicURL == "" {
+		return s.objectURL(name), nil
+	}
+
+	return strings.TrimSuffix(s.PublicURL, "/") + "/" + s3Escape(name, false), nil
+}
+
+// s3ListResult is the response to a ListObjectsV2 request.
+type s3ListResult struct {
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+// List uses ListObjectsV2, which does not return content types, so ContentType is empty
+// in the listed objects.
+func (s *S3Store) List(c context.Context, prefix, cursor string, limit int) ([]*Object, string, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+		"max-keys":  {strconv.Itoa(limit)},
+	}
+	if cursor != "" {
+		query.Set("continuation-token", cursor)
+	}
+
+	req, err := http.NewRequest("GET", s.bucketURL()+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := s.do(c, req, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var result s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	objs := make([]*Object, len(result.Contents))
+	for i, content := range result.Contents {
+		objs[i] = &Object{Name: content.Key, Size: content.Size, Updated: content.LastModified}
+	}
+
+	return objs, result.NextContinuationToken, nil
+}
+
+func (s *S3Store) bucketURL() string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s3Escape(s.Bucket, true)
+}
+
+func (s *S3Store) objectURL(name string) string {
+	return s.bucketURL() + "/" + s3Escape(name, false)
+}
+
+// do signs and sends a request with the given payload, and checks the response status.
+// ErrObjectNotExist is returned for a 404 response.
+func (s *S3Store) do(c context.Context, req *http.Request, payload []byte) (*http.Response, error) {
+	s.sign(req, payload, time.Now())
+
+	resp, err := ctxhttp.Do(c, s.Client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrObjectNotExist
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &S3Error{resp.StatusCode, string(body)}
+	}
+
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a request.
+// See http://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3TimeFormat)
+	day := amzDate[:8]
+
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		s3CanonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape URI-encodes a string the way S3 expects in a canonical request: every byte except
+// unreserved characters is escaped, and slashes are escaped only if escapeSlash is true.
+func s3Escape(s string, escapeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !escapeSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
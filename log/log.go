@@ -0,0 +1,33 @@
+// Package log writes application logs. It mirrors google.golang.org/appengine/log, which it
+// uses when built for App Engine; otherwise messages go to the standard logger.
+package log
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Debugf formats its arguments according to the format, analogous to fmt.Printf,
+// and records the text as a log message at Debug level.
+func Debugf(c context.Context, format string, args ...interface{}) {
+	logf(c, "DEBUG", format, args...)
+}
+
+// Infof is like Debugf, but at Info level.
+func Infof(c context.Context, format string, args ...interface{}) {
+	logf(c, "INFO", format, args...)
+}
+
+// Warningf is like Debugf, but at Warning level.
+func Warningf(c context.Context, format string, args ...interface{}) {
+	logf(c, "WARNING", format, args...)
+}
+
+// Errorf is like Debugf, but at Error level.
+func Errorf(c context.Context, format string, args ...interface{}) {
+	logf(c, "ERROR", format, args...)
+}
+
+// Criticalf is like Debugf, but at Critical level.
+func Criticalf(c context.Context, format string, args ...interface{}) {
+	logf(c, "CRITICAL", format, args...)
+}
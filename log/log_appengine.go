@@ -0,0 +1,25 @@
+//go:build appengine
+// +build appengine
+
+package log
+
+import (
+	"golang.org/x/net/context"
+
+	aelog "google.golang.org/appengine/log"
+)
+
+func logf(c context.Context, level, format string, args ...interface{}) {
+	switch level {
+	case "DEBUG":
+		aelog.Debugf(c, format, args...)
+	case "INFO":
+		aelog.Infof(c, format, args...)
+	case "WARNING":
+		aelog.Warningf(c, format, args...)
+	case "ERROR":
+		aelog.Errorf(c, format, args...)
+	default:
+		aelog.Criticalf(c, format, args...)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !appengine
+// +build !appengine
+
+package log
+
+import (
+	"fmt"
+	stdlog "log"
+
+	"golang.org/x/net/context"
+)
+
+func logf(c context.Context, level, format string, args ...interface{}) {
+	stdlog.Print(level + ": " + fmt.Sprintf(format, args...))
+}
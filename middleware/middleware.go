@@ -1,55 +1,106 @@
 package middleware
 
 import (
-	"appengine"
-	"appengine/user"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/user"
 
-	"github.com/gorilla/context"
 	"github.com/reedperry/gogram/api"
+	"github.com/reedperry/gogram/auth"
+	"github.com/reedperry/gogram/log"
+	"github.com/reedperry/gogram/tasks"
 
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+// Prefixes of the paths of pages, which users are sent to sign in to view rather than
+// being refused.
+var pagePrefixes = []string{"/e/", "/p/", "/u/"}
+
+// Authenticator identifies the signed in user making a request. It accepts personal access
+// tokens and the App Engine Users API by default, and is replaced when running as a
+// standalone server, e.g. with auth.Proxy or auth.JWT.
+var Authenticator = auth.Chain(api.AccessTokens{}, auth.AppEngineUsers{})
+
+// LoginURL returns a URL that signs a user in and then returns them to dest. An empty
+// URL means that users cannot be sent to sign in.
+var LoginURL = func(r *http.Request, dest string) (string, error) {
+	return user.LoginURL(api.NewContext(r), dest)
+}
+
 // Authorize wraps a Handler to run authorization before executing it. If authorization fails,
 // the user will either be sent to a login page, or receive a 403 Forbidden response.
+// Otherwise the signed in user's identity is stored in the request context, where handlers
+// read it with auth.FromContext.
+// Task requests have no signed in user, and are passed through without one.
 func Authorize(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c := appengine.NewContext(r)
-		_, err := authorize(r, c)
+		if tasks.IsTaskRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c := api.NewContext(r)
+		id, err := authorize(r, c)
 		if err != nil {
-			// TODO Really need to decide this based on whether the user is attempting to view a
-			// page or calling for a raw data response
-			if r.URL.Path == "/" {
-				loginURL, _ := user.LoginURL(c, "/")
+			// Users opening a page, such as a shared invite link, are sent to sign in and
+			// then back to the same URL, while API calls are refused.
+			loginURL, _ := LoginURL(r, r.URL.RequestURI())
+			if loginURL != "" && isPage(r) {
+				log.Infof(c, "Sending user to sign in before viewing %v.", r.URL.Path)
+				http.Redirect(w, r, loginURL, http.StatusFound)
+			} else if r.URL.Path == "/" && loginURL != "" {
 				w.Header().Set("Content-Type", "text/html; charset=utf-8")
 				fmt.Fprintf(w, `You are not signed in! Sign in <a href="%s">here</a>.`, loginURL)
 			} else {
-				c.Infof("User authorization failed: %v", err)
+				log.Infof(c, "User authorization failed: %v", err)
 				http.Error(w, "Not Authorized!", http.StatusForbidden)
 			}
 
 			return
 		}
 
-		h.ServeHTTP(w, r)
+		if id.ReadOnly && r.Method != "GET" && r.Method != "HEAD" {
+			log.Infof(c, "User %v cannot %v %v with read only credentials.", id.ID, r.Method, r.URL.Path)
+			http.Error(w, "Your credentials are read only.", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
 	})
 }
 
 // Verify that the user making the request is signed in and authorized to continue.
 // The user is returned if signed in and authorized, otherwise an error is returned
 // with a nil user value.
-// When the user is signed in, this function stores the user in the request context.
-func authorize(r *http.Request, c appengine.Context) (*user.User, error) {
-	u := user.Current(c)
-	if u == nil {
-		c.Infof("No user signed in.")
+func authorize(r *http.Request, c context.Context) (*auth.Identity, error) {
+	id, err := Authenticator.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		log.Infof(c, "No user signed in.")
 		return nil, errors.New("Authorization failed. User not logged in.")
 	}
 
-	c.Infof("Active user: %#v", u)
-	context.Set(r, api.UserCtxKey, u)
+	log.Infof(c, "Active user: %#v", id)
+
+	return id, nil
+}
+
+// isPage reports whether a request is for one of the app's pages, rather than an API call.
+func isPage(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	for _, prefix := range pagePrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
 
-	return u, nil
+	return false
 }
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/reedperry/gogram/auth"
+)
+
+func TestAuthorizeSignedOut(t *testing.T) {
+	defer func(authenticator auth.Authenticator, loginURL func(*http.Request, string) (string, error)) {
+		Authenticator, LoginURL = authenticator, loginURL
+	}(Authenticator, LoginURL)
+	Authenticator = auth.Chain()
+	LoginURL = func(r *http.Request, dest string) (string, error) {
+		return "/auth/login?" + url.Values{"dest": {dest}}.Encode(), nil
+	}
+
+	h := Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Authorize() let a signed out user %v %v.", r.Method, r.URL)
+	}))
+
+	tests := []struct {
+		method, url string
+		status      int
+		location    string
+	}{
+		{"GET", "/e/1?invite=abc", http.StatusFound, "/auth/login?dest=%2Fe%2F1%3Finvite%3Dabc"},
+		{"GET", "/u/someone", http.StatusFound, "/auth/login?dest=%2Fu%2Fsomeone"},
+		{"POST", "/e/1", http.StatusForbidden, ""},
+		{"GET", "/a/e/1", http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%v %v returned %v to %q. Wanted %v to %q.", test.method, test.url,
+				w.Code, w.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
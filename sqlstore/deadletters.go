@@ -0,0 +1,34 @@
+package sqlstore
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type deadLetterStore struct {
+	*Store
+}
+
+const deadLetterColumns = "id, queue, path, params, error, retries, created"
+
+func (s *deadLetterStore) Get(deadLetterID string, c context.Context) (*api.DeadLetter, error) {
+	deadLetter := new(api.DeadLetter)
+	err := s.queryRow(c, "SELECT "+deadLetterColumns+" FROM dead_letters WHERE id = ?", deadLetterID).Scan(
+		&deadLetter.ID, &deadLetter.Queue, &deadLetter.Path, &deadLetter.Params, &deadLetter.Error,
+		&deadLetter.Retries, &deadLetter.Created)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return deadLetter, nil
+}
+
+func (s *deadLetterStore) Put(deadLetter *api.DeadLetter, c context.Context) error {
+	return s.exec(c, `INSERT INTO dead_letters (`+deadLetterColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET queue = excluded.queue, path = excluded.path,
+			params = excluded.params, error = excluded.error, retries = excluded.retries,
+			created = excluded.created`,
+		deadLetter.ID, deadLetter.Queue, deadLetter.Path, deadLetter.Params, deadLetter.Error,
+		deadLetter.Retries, deadLetter.Created.UTC())
+}
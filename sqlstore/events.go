@@ -0,0 +1,198 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type eventStore struct {
+	*Store
+}
+
+const eventColumns = "id, name, description, start_time, end_time, private, creator, created, modified, co_hosts"
+
+func (s *eventStore) Get(eventID string, c context.Context) (*api.Event, error) {
+	row := s.queryRow(c, "SELECT "+eventColumns+" FROM events WHERE id = ?", eventID)
+	return scanEvent(row)
+}
+
+func (s *eventStore) Put(event *api.Event, c context.Context) error {
+	return s.put(s.db, event, c)
+}
+
+// Update locks the event's row on Postgres until the transaction ends. SQLite needs no
+// lock, as it has a single connection, so transactions never overlap.
+func (s *eventStore) Update(eventID string, update func(*api.Event) error, c context.Context) (*api.Event, error) {
+	tx, err := s.db.BeginTx(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT " + eventColumns + " FROM events WHERE id = ?"
+	if s.dialect == Postgres {
+		query += " FOR UPDATE"
+	}
+	event, err := scanEvent(tx.QueryRowContext(c, s.rebind(query), eventID))
+	if err != nil {
+		return nil, err
+	}
+	if err := update(event); err != nil {
+		return nil, err
+	}
+	if err := s.put(tx, event, c); err != nil {
+		return nil, err
+	}
+
+	return event, tx.Commit()
+}
+
+// execer runs statements on a database or within a transaction.
+type execer interface {
+	ExecContext(c context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *eventStore) put(db execer, event *api.Event, c context.Context) error {
+	// Co-hosts are stored as a JSON array, as they are only ever read along with the event.
+	coHosts, err := json.Marshal(event.CoHosts)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(c, s.rebind(`INSERT INTO events (`+eventColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, description = excluded.description,
+			start_time = excluded.start_time, end_time = excluded.end_time,
+			private = excluded.private, creator = excluded.creator,
+			created = excluded.created, modified = excluded.modified, co_hosts = excluded.co_hosts`),
+		event.ID, event.Name, event.Description, event.Start.UTC(), event.End.UTC(),
+		event.Private, event.Creator, event.Created.UTC(), event.Modified.UTC(), string(coHosts))
+	return err
+}
+
+func (s *eventStore) Delete(eventID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM events WHERE id = ?", eventID)
+}
+
+func (s *eventStore) Feed(order string, page int, c context.Context) ([]api.Event, error) {
+	rows, err := s.query(c, "SELECT "+eventColumns+" FROM events ORDER BY "+orderBy(order)+" LIMIT ? OFFSET ?",
+		api.PAGE_SIZE, api.PAGE_SIZE*page)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanEvents(rows)
+}
+
+func (s *eventStore) FeedPage(req api.PageRequest, c context.Context) (*api.EventPage, error) {
+	return s.page("", nil, req, c)
+}
+
+func (s *eventStore) ByCreator(creatorID string, req api.PageRequest, c context.Context) (*api.EventPage, error) {
+	return s.page("creator = ?", []interface{}{creatorID}, req, c)
+}
+
+// page returns a page of the events matching the where condition, or of all events if it
+// is empty.
+func (s *eventStore) page(where string, args []interface{}, req api.PageRequest, c context.Context) (*api.EventPage, error) {
+	column, direction := sortColumn(req.Order)
+
+	if req.Cursor != "" {
+		value, id, err := api.DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+		if where != "" {
+			where += " AND "
+		}
+		where += "(" + column + " " + op + " ? OR (" + column + " = ? AND id " + op + " ?))"
+		args = append(args, value.UTC(), value.UTC(), id)
+	}
+	if where != "" {
+		where = " WHERE " + where
+	}
+
+	// One event more than the limit is fetched only to find out whether there is a next page.
+	rows, err := s.query(c, "SELECT "+eventColumns+" FROM events"+where+" ORDER BY "+orderBy(req.Order)+" LIMIT ?",
+		append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &api.EventPage{Items: events}
+	if len(events) > req.Limit {
+		page.Items = events[:req.Limit]
+		page.NextCursor = api.EventCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+// orderBy translates a feed order, such as "-Created", into an ORDER BY clause. Events
+// with equal sort values are ordered by ID so that pages are stable.
+func orderBy(order string) string {
+	column, direction := sortColumn(order)
+	return column + " " + direction + ", id " + direction
+}
+
+func sortColumn(order string) (column, direction string) {
+	direction = "ASC"
+	if strings.HasPrefix(order, "-") {
+		direction = "DESC"
+	}
+
+	column = "created"
+	if strings.TrimPrefix(order, "-") == "End" {
+		column = "end_time"
+	}
+
+	return column, direction
+}
+
+func scanEvent(row scanner) (*api.Event, error) {
+	event := new(api.Event)
+	var coHosts string
+	err := row.Scan(&event.ID, &event.Name, &event.Description, &event.Start, &event.End,
+		&event.Private, &event.Creator, &event.Created, &event.Modified, &coHosts)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	// Events stored before co-hosts were added have none.
+	if coHosts != "" {
+		if err := json.Unmarshal([]byte(coHosts), &event.CoHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]api.Event, error) {
+	defer rows.Close()
+
+	events := make([]api.Event, 0, api.PAGE_SIZE)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,35 @@
+package sqlstore
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type jobStore struct {
+	*Store
+}
+
+const jobColumns = "id, kind, user_id, status, progress, error, delete_content, file, created, modified"
+
+func (s *jobStore) Get(jobID string, c context.Context) (*api.Job, error) {
+	job := new(api.Job)
+	err := s.queryRow(c, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", jobID).Scan(
+		&job.ID, &job.Kind, &job.UserID, &job.Status, &job.Progress, &job.Error,
+		&job.DeleteContent, &job.File, &job.Created, &job.Modified)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return job, nil
+}
+
+func (s *jobStore) Put(job *api.Job, c context.Context) error {
+	return s.exec(c, `INSERT INTO jobs (`+jobColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET kind = excluded.kind, user_id = excluded.user_id,
+			status = excluded.status, progress = excluded.progress, error = excluded.error,
+			delete_content = excluded.delete_content, file = excluded.file,
+			created = excluded.created, modified = excluded.modified`,
+		job.ID, job.Kind, job.UserID, job.Status, job.Progress, job.Error,
+		job.DeleteContent, job.File, job.Created.UTC(), job.Modified.UTC())
+}
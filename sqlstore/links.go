@@ -0,0 +1,82 @@
+package sqlstore
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type inviteLinkStore struct {
+	*Store
+}
+
+const linkColumns = "id, event_id, created_by, max_uses, uses, expires, created"
+
+func (s *inviteLinkStore) Get(linkID string, c context.Context) (*api.InviteLink, error) {
+	link := new(api.InviteLink)
+	err := s.queryRow(c, "SELECT "+linkColumns+" FROM invite_links WHERE id = ?", linkID).Scan(
+		&link.ID, &link.EventID, &link.CreatedBy, &link.MaxUses, &link.Uses, &link.Expires, &link.Created)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return link, nil
+}
+
+func (s *inviteLinkStore) Put(link *api.InviteLink, c context.Context) error {
+	return s.exec(c, `INSERT INTO invite_links (`+linkColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET event_id = excluded.event_id, created_by = excluded.created_by,
+			max_uses = excluded.max_uses, uses = excluded.uses, expires = excluded.expires,
+			created = excluded.created`,
+		link.ID, link.EventID, link.CreatedBy, link.MaxUses, link.Uses, link.Expires.UTC(), link.Created.UTC())
+}
+
+func (s *inviteLinkStore) Delete(linkID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM invite_links WHERE id = ?", linkID)
+}
+
+func (s *inviteLinkStore) Use(linkID string, c context.Context) (*api.InviteLink, error) {
+	// The use is counted in a single statement, so that concurrent uses cannot exceed
+	// max_uses.
+	res, err := s.db.ExecContext(c, s.rebind("UPDATE invite_links SET uses = uses + 1 WHERE id = ? AND uses < max_uses"),
+		linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.Get(linkID, c)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, api.ErrLinkUsedUp
+	}
+
+	return link, nil
+}
+
+func (s *inviteLinkStore) ByEvent(eventID string, c context.Context) ([]api.InviteLink, error) {
+	rows, err := s.query(c, "SELECT "+linkColumns+" FROM invite_links WHERE event_id = ?"+
+		" ORDER BY created DESC, id DESC LIMIT ?", eventID, api.MAX_LINKS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]api.InviteLink, 0)
+	for rows.Next() {
+		var link api.InviteLink
+		err := rows.Scan(&link.ID, &link.EventID, &link.CreatedBy, &link.MaxUses, &link.Uses, &link.Expires, &link.Created)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
@@ -0,0 +1,97 @@
+package sqlstore
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type memberStore struct {
+	*Store
+}
+
+const memberColumns = "event_id, user_id, status, invited_by, created, modified"
+
+func (s *memberStore) Get(eventID, userID string, c context.Context) (*api.Member, error) {
+	member := new(api.Member)
+	err := s.queryRow(c, "SELECT "+memberColumns+" FROM event_members WHERE event_id = ? AND user_id = ?",
+		eventID, userID).Scan(&member.EventID, &member.UserID, &member.Status, &member.InvitedBy,
+		&member.Created, &member.Modified)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return member, nil
+}
+
+// Put locks the event's row on Postgres while counting its members, so that concurrent
+// writes cannot go past MAX_MEMBERS. SQLite needs no lock, as it has a single connection.
+func (s *memberStore) Put(member *api.Member, c context.Context) error {
+	tx, err := s.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect == Postgres {
+		_, err := tx.ExecContext(c, s.rebind("SELECT id FROM events WHERE id = ? FOR UPDATE"), member.EventID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var exists, count int
+	err = tx.QueryRowContext(c, s.rebind(`SELECT COUNT(CASE WHEN user_id = ? THEN 1 END), COUNT(*)
+		FROM event_members WHERE event_id = ?`), member.UserID, member.EventID).Scan(&exists, &count)
+	if err != nil {
+		return err
+	}
+	if exists == 0 && count >= api.MAX_MEMBERS {
+		return api.ErrEventFull
+	}
+
+	_, err = tx.ExecContext(c, s.rebind(`INSERT INTO event_members (`+memberColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (event_id, user_id) DO UPDATE SET status = excluded.status,
+			invited_by = excluded.invited_by, created = excluded.created, modified = excluded.modified`),
+		member.EventID, member.UserID, member.Status, member.InvitedBy, member.Created.UTC(), member.Modified.UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *memberStore) Delete(eventID, userID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM event_members WHERE event_id = ? AND user_id = ?", eventID, userID)
+}
+
+func (s *memberStore) ByEvent(eventID string, c context.Context) ([]api.Member, error) {
+	return s.list(c, "SELECT "+memberColumns+" FROM event_members WHERE event_id = ?"+
+		" ORDER BY created, user_id LIMIT ?", eventID, api.MAX_MEMBERS)
+}
+
+func (s *memberStore) ByUser(userID string, c context.Context) ([]api.Member, error) {
+	return s.list(c, "SELECT "+memberColumns+" FROM event_members WHERE user_id = ?"+
+		" ORDER BY created, event_id", userID)
+}
+
+func (s *memberStore) list(c context.Context, query string, args ...interface{}) ([]api.Member, error) {
+	rows, err := s.query(c, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]api.Member, 0)
+	for rows.Next() {
+		var member api.Member
+		err := rows.Scan(&member.EventID, &member.UserID, &member.Status, &member.InvitedBy,
+			&member.Created, &member.Modified)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
@@ -0,0 +1,193 @@
+package sqlstore
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// migrations are applied in order, and each one is applied only once. Existing migrations
+// must never be changed; schema changes are made by appending a new migration.
+// {{timestamp}} is replaced with the timestamp type of the database.
+var migrations = []string{
+	`CREATE TABLE app_users (
+		id TEXT PRIMARY KEY,
+		email TEXT NOT NULL,
+		username TEXT NOT NULL,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		created {{timestamp}} NOT NULL,
+		modified {{timestamp}} NOT NULL
+	);
+	CREATE INDEX app_users_username ON app_users (username);`,
+
+	`CREATE TABLE events (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL,
+		start_time {{timestamp}} NOT NULL,
+		end_time {{timestamp}} NOT NULL,
+		private BOOLEAN NOT NULL,
+		creator TEXT NOT NULL,
+		created {{timestamp}} NOT NULL,
+		modified {{timestamp}} NOT NULL
+	);
+	CREATE INDEX events_created ON events (created, id);
+	CREATE INDEX events_end_time ON events (end_time, id);`,
+
+	`CREATE TABLE posts (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		image TEXT NOT NULL,
+		text TEXT NOT NULL,
+		created {{timestamp}} NOT NULL,
+		modified {{timestamp}} NOT NULL
+	);
+	CREATE INDEX posts_user_created ON posts (user_id, created);
+	CREATE INDEX posts_event_created ON posts (event_id, created);`,
+
+	`DROP INDEX app_users_username;
+	CREATE UNIQUE INDEX app_users_username ON app_users (LOWER(username));`,
+
+	`CREATE TABLE jobs (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		progress INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		delete_content BOOLEAN NOT NULL,
+		created {{timestamp}} NOT NULL,
+		modified {{timestamp}} NOT NULL
+	);`,
+
+	`CREATE TABLE dead_letters (
+		id TEXT PRIMARY KEY,
+		queue TEXT NOT NULL,
+		path TEXT NOT NULL,
+		params TEXT NOT NULL,
+		error TEXT NOT NULL,
+		retries INTEGER NOT NULL,
+		created {{timestamp}} NOT NULL
+	);`,
+
+	`CREATE TABLE trash (
+		item_key TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		id TEXT NOT NULL,
+		owner_id TEXT NOT NULL,
+		parent_id TEXT NOT NULL,
+		trashed {{timestamp}} NOT NULL,
+		data TEXT NOT NULL
+	);
+	CREATE INDEX trash_owner_trashed ON trash (owner_id, trashed);
+	CREATE INDEX trash_parent_trashed ON trash (parent_id, trashed);
+	CREATE INDEX trash_trashed ON trash (trashed);`,
+
+	`ALTER TABLE jobs ADD COLUMN file TEXT NOT NULL DEFAULT '';
+	CREATE INDEX events_creator_created ON events (creator, created, id);`,
+
+	`CREATE TABLE access_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		created {{timestamp}} NOT NULL,
+		last_used {{timestamp}} NOT NULL
+	);
+	CREATE INDEX access_tokens_user_created ON access_tokens (user_id, created);`,
+
+	`ALTER TABLE app_users ADD COLUMN role TEXT NOT NULL DEFAULT '';
+	ALTER TABLE trash ADD COLUMN trashed_by TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE TABLE event_members (
+		event_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		invited_by TEXT NOT NULL,
+		created {{timestamp}} NOT NULL,
+		modified {{timestamp}} NOT NULL,
+		PRIMARY KEY (event_id, user_id)
+	);
+	CREATE INDEX event_members_user_created ON event_members (user_id, created);`,
+
+	`CREATE TABLE invite_links (
+		id TEXT PRIMARY KEY,
+		event_id TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		max_uses INTEGER NOT NULL,
+		uses INTEGER NOT NULL,
+		expires {{timestamp}} NOT NULL,
+		created {{timestamp}} NOT NULL
+	);
+	CREATE INDEX invite_links_event_created ON invite_links (event_id, created);`,
+
+	`ALTER TABLE events ADD COLUMN co_hosts TEXT NOT NULL DEFAULT '';`,
+}
+
+// Migrate brings the database schema up to date.
+func (s *Store) Migrate() error {
+	c := context.Background()
+
+	err := s.exec(c, s.ddl(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied {{timestamp}} NOT NULL
+	)`))
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = s.queryRow(c, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		if err := s.migrate(version+1, migrations[version]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrate applies a single migration and records it in the same transaction.
+func (s *Store) migrate(version int, migration string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range strings.Split(s.ddl(migration), ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(s.rebind("INSERT INTO schema_migrations (version, applied) VALUES (?, ?)"),
+		version, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ddl(stmt string) string {
+	timestamp := "TIMESTAMP"
+	if s.dialect == Postgres {
+		timestamp = "TIMESTAMP WITH TIME ZONE"
+	}
+
+	return strings.Replace(stmt, "{{timestamp}}", timestamp, -1)
+}
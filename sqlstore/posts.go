@@ -0,0 +1,112 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type postStore struct {
+	*Store
+}
+
+const postColumns = "id, user_id, event_id, image, text, created, modified"
+
+func (s *postStore) Get(postID string, c context.Context) (*api.Post, error) {
+	row := s.queryRow(c, "SELECT "+postColumns+" FROM posts WHERE id = ?", postID)
+	return scanPost(row)
+}
+
+func (s *postStore) Put(post *api.Post, c context.Context) error {
+	return s.exec(c, `INSERT INTO posts (`+postColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, event_id = excluded.event_id,
+			image = excluded.image, text = excluded.text,
+			created = excluded.created, modified = excluded.modified`,
+		post.ID, post.UserID, post.EventID, post.Image, post.Text, post.Created.UTC(), post.Modified.UTC())
+}
+
+func (s *postStore) Delete(postID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM posts WHERE id = ?", postID)
+}
+
+func (s *postStore) ByUser(userID string, req api.PageRequest, c context.Context) (*api.PostPage, error) {
+	return s.page("user_id = ?", []interface{}{userID}, req, c)
+}
+
+func (s *postStore) ByEvent(eventID string, req api.PageRequest, c context.Context) (*api.PostPage, error) {
+	return s.page("event_id = ?", []interface{}{eventID}, req, c)
+}
+
+func (s *postStore) Imageless(before time.Time, req api.PageRequest, c context.Context) (*api.PostPage, error) {
+	return s.page("image = '' AND created < ?", []interface{}{before.UTC()}, req, c)
+}
+
+func (s *postStore) All(req api.PageRequest, c context.Context) (*api.PostPage, error) {
+	return s.page("1 = 1", nil, req, c)
+}
+
+// page returns a page of the posts matching the where condition.
+func (s *postStore) page(where string, args []interface{}, req api.PageRequest, c context.Context) (*api.PostPage, error) {
+	_, direction := sortColumn(req.Order)
+
+	if req.Cursor != "" {
+		created, id, err := api.DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+		where += " AND (created " + op + " ? OR (created = ? AND id " + op + " ?))"
+		args = append(args, created.UTC(), created.UTC(), id)
+	}
+
+	rows, err := s.query(c, "SELECT "+postColumns+" FROM posts WHERE "+where+
+		" ORDER BY created "+direction+", id "+direction+" LIMIT ?", append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &api.PostPage{Items: posts}
+	if len(posts) > req.Limit {
+		page.Items = posts[:req.Limit]
+		page.NextCursor = api.PostCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+func scanPost(row scanner) (*api.Post, error) {
+	post := new(api.Post)
+	err := row.Scan(&post.ID, &post.UserID, &post.EventID, &post.Image, &post.Text, &post.Created, &post.Modified)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return post, nil
+}
+
+func scanPosts(rows *sql.Rows) ([]api.Post, error) {
+	defer rows.Close()
+
+	posts := make([]api.Post, 0, api.PAGE_SIZE)
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *post)
+	}
+
+	return posts, rows.Err()
+}
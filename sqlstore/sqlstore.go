@@ -0,0 +1,150 @@
+// Package sqlstore stores gogram entities in a relational database. PostgreSQL and SQLite
+// are supported; the database driver must be registered by the program using this package,
+// e.g. by importing github.com/lib/pq or github.com/mattn/go-sqlite3.
+package sqlstore
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+// Dialect identifies the flavor of SQL spoken by a database.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// Store holds the database connection shared by all of the stores.
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// Open connects to a database using a registered driver, "postgres" or "sqlite3", and
+// applies any schema migrations that have not been run yet.
+func Open(driverName, dataSource string) (*Store, error) {
+	var dialect Dialect
+	switch driverName {
+	case "postgres":
+		dialect = Postgres
+	case "sqlite3":
+		dialect = SQLite
+	default:
+		return nil, errors.New("sqlstore: unsupported database driver " + driverName)
+	}
+
+	db, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == SQLite {
+		// SQLite only allows one writer at a time, and each connection to an
+		// in-memory database would see a different database.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := New(db, dialect)
+	if err := s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// New creates a Store using an existing database connection. Migrate must be called
+// before the store is used if the schema may be out of date.
+func New(db *sql.DB, dialect Dialect) *Store {
+	return &Store{db, dialect}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Users() api.UserStore {
+	return &userStore{s}
+}
+
+func (s *Store) Events() api.EventStore {
+	return &eventStore{s}
+}
+
+func (s *Store) Posts() api.PostStore {
+	return &postStore{s}
+}
+
+func (s *Store) Jobs() api.JobStore {
+	return &jobStore{s}
+}
+
+func (s *Store) DeadLetters() api.DeadLetterStore {
+	return &deadLetterStore{s}
+}
+
+func (s *Store) Trash() api.TrashStore {
+	return &trashStore{s}
+}
+
+func (s *Store) Tokens() api.TokenStore {
+	return &tokenStore{s}
+}
+
+func (s *Store) Members() api.MemberStore {
+	return &memberStore{s}
+}
+
+func (s *Store) InviteLinks() api.InviteLinkStore {
+	return &inviteLinkStore{s}
+}
+
+// rebind rewrites the ? placeholders in a query to the style used by the database.
+func (s *Store) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+
+	var buf bytes.Buffer
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		} else {
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+func (s *Store) exec(c context.Context, query string, args ...interface{}) error {
+	_, err := s.db.ExecContext(c, s.rebind(query), args...)
+	return err
+}
+
+func (s *Store) query(c context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(c, s.rebind(query), args...)
+}
+
+func (s *Store) queryRow(c context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(c, s.rebind(query), args...)
+}
+
+// notFound translates sql.ErrNoRows into the error returned by all stores.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return api.ErrNoSuchEntity
+	}
+
+	return err
+}
@@ -0,0 +1,504 @@
+package sqlstore
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+func openTestStore(t *testing.T) *Store {
+	s, err := Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	s := openTestStore(t)
+	defer s.Close()
+
+	// Running migrations again must be a no-op.
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("Migrate() failed on an up to date database: %v", err)
+	}
+
+	var count int
+	s.queryRow(context.Background(), "SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+	if count != len(migrations) {
+		t.Errorf("Found %v applied migrations. Wanted %v.", count, len(migrations))
+	}
+}
+
+func TestRebind(t *testing.T) {
+	s := &Store{dialect: Postgres}
+	got := s.rebind("SELECT a FROM b WHERE c = ? AND d = ?")
+	if got != "SELECT a FROM b WHERE c = $1 AND d = $2" {
+		t.Errorf("rebind() returned %q.", got)
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	users := s.Users()
+
+	now := time.Now()
+	appUser := &api.AppUser{ID: "123", Username: "someone", Email: "a@b.com", Created: now, Modified: now}
+	if err := users.Create(appUser, c); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	appUser.FirstName = "Some"
+	appUser.Role = api.ROLE_MODERATOR
+	if err := users.Update(appUser, c); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+
+	if err := users.Create(appUser, c); err != api.ErrUserExists {
+		t.Errorf("Create() of an existing user returned %v. Wanted ErrUserExists.", err)
+	}
+
+	other := &api.AppUser{ID: "456", Username: "SomeOne", Created: now, Modified: now}
+	if err := users.Create(other, c); err != api.ErrUsernameTaken {
+		t.Errorf("Create() with a taken username returned %v. Wanted ErrUsernameTaken.", err)
+	}
+
+	other.Username = "other"
+	users.Create(other, c)
+	other.Username = "someone"
+	if err := users.Update(other, c); err != api.ErrUsernameTaken {
+		t.Errorf("Update() to a taken username returned %v. Wanted ErrUsernameTaken.", err)
+	}
+
+	found, err := users.GetMulti([]string{"123", "456", "789"}, c)
+	if err != nil || len(found) != 2 || found["456"].Username != "other" {
+		t.Errorf("GetMulti() returned %v, %v. Wanted users 123 and 456.", found, err)
+	}
+
+	if err := users.Update(&api.AppUser{ID: "789", Username: "nobody"}, c); err != api.ErrNoSuchEntity {
+		t.Errorf("Update() of a missing user returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	got, err := users.GetByName("someone", c)
+	if err != nil {
+		t.Fatalf("GetByName() failed: %v", err)
+	}
+	if got.ID != "123" || got.FirstName != "Some" || got.Role != api.ROLE_MODERATOR || !got.Created.Equal(now) {
+		t.Errorf("GetByName() returned %+v.", got)
+	}
+
+	page, err := users.All(api.PageRequest{Order: "Created", Limit: 1}, c)
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != "123" || page.NextCursor == "" {
+		t.Fatalf("All() returned %+v, %v.", page, err)
+	}
+	page, err = users.All(api.PageRequest{Order: "Created", Cursor: page.NextCursor, Limit: 1}, c)
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != "456" || page.NextCursor != "" {
+		t.Errorf("All() of the second page returned %+v, %v.", page, err)
+	}
+
+	users.Delete("123", c)
+	if _, err := users.Get("123", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted user returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestEventFeed(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	events := s.Events()
+
+	now := time.Now()
+	for i := 0; i < api.PAGE_SIZE+5; i++ {
+		err := events.Put(&api.Event{
+			ID:      strconv.Itoa(i),
+			Name:    "Event",
+			Start:   now,
+			Created: now.Add(time.Duration(i) * time.Minute),
+			End:     now.Add(-time.Duration(i) * time.Minute),
+		}, c)
+		if err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+
+	feedTests := []struct {
+		order  string
+		page   int
+		length int
+		first  string
+	}{
+		{"-Created", 0, api.PAGE_SIZE, "24"},
+		{"-Created", 1, 5, "4"},
+		{"Created", 0, api.PAGE_SIZE, "0"},
+		{"End", 0, api.PAGE_SIZE, "24"},
+		{"-End", 1, 5, "20"},
+	}
+
+	for _, test := range feedTests {
+		feed, err := events.Feed(test.order, test.page, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(feed) != test.length || feed[0].ID != test.first {
+			t.Errorf("Feed(%v, %v) returned %v events starting with %v. Wanted %v starting with %v.",
+				test.order, test.page, len(feed), feed[0].ID, test.length, test.first)
+		}
+	}
+}
+
+func TestEventFeedPage(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	events := s.Events()
+
+	now := time.Now()
+	for i := 0; i < 25; i++ {
+		events.Put(&api.Event{ID: strconv.Itoa(100 + i), Created: now.Add(time.Duration(i/2) * time.Minute)}, c)
+	}
+
+	for _, order := range []string{"-Created", "Created"} {
+		var ids []string
+		req := api.PageRequest{Order: order, Limit: 10}
+		for {
+			page, err := events.FeedPage(req, c)
+			if err != nil {
+				t.Fatalf("FeedPage() failed: %v", err)
+			}
+
+			for _, event := range page.Items {
+				ids = append(ids, event.ID)
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+
+		feed, _ := events.Feed(order, 0, c)
+		if len(ids) != 25 || ids[0] != feed[0].ID || ids[19] != feed[19].ID {
+			t.Errorf("Paging through the %v feed returned %v.", order, ids)
+		}
+	}
+}
+
+func TestPostStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	posts := s.Posts()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		posts.Put(&api.Post{
+			ID:      strconv.Itoa(i),
+			UserID:  "u" + strconv.Itoa(i%2),
+			EventID: "e1",
+			Created: now.Add(time.Duration(i) * time.Second),
+		}, c)
+	}
+
+	newest := api.PageRequest{Order: "-Created", Limit: 2}
+	byEvent, err := posts.ByEvent("e1", newest, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byEvent.Items) != 2 || byEvent.Items[0].ID != "2" || byEvent.NextCursor == "" {
+		t.Errorf("ByEvent() returned %+v. Wanted 2 posts, newest first, and a cursor.", byEvent)
+	}
+
+	newest.Cursor = byEvent.NextCursor
+	rest, err := posts.ByEvent("e1", newest, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest.Items) != 1 || rest.Items[0].ID != "0" || rest.NextCursor != "" {
+		t.Errorf("ByEvent() of the second page returned %+v. Wanted only the oldest post.", rest)
+	}
+
+	byUser, _ := posts.ByUser("u0", api.PageRequest{Order: "Created", Limit: 10}, c)
+	if len(byUser.Items) != 2 || byUser.Items[0].ID != "0" {
+		t.Errorf("ByUser() returned %+v. Wanted 2 posts, oldest first.", byUser.Items)
+	}
+
+	posts.Put(&api.Post{ID: "img", UserID: "u0", EventID: "e2", Image: "link", Created: now}, c)
+	imageless, err := posts.Imageless(now.Add(1500*time.Millisecond), api.PageRequest{Order: "Created", Limit: 10}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imageless.Items) != 2 || imageless.Items[0].ID != "0" || imageless.Items[1].ID != "1" {
+		t.Errorf("Imageless() returned %+v. Wanted the 2 oldest posts without an image.", imageless.Items)
+	}
+
+	if _, err := posts.Get("9", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a missing post returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	jobs := s.Jobs()
+
+	now := time.Now()
+	job := &api.Job{ID: "1", Kind: api.JOB_DELETE_USER, UserID: "u", Status: api.JOB_PENDING, DeleteContent: true,
+		Created: now, Modified: now}
+	if err := jobs.Put(job, c); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	job.Status, job.Progress, job.File = api.JOB_RUNNING, 5, "exports/u/1.zip"
+	if err := jobs.Put(job, c); err != nil {
+		t.Fatalf("Put() of an existing job failed: %v", err)
+	}
+
+	got, err := jobs.Get("1", c)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if got.Status != api.JOB_RUNNING || got.Progress != 5 || !got.DeleteContent || got.UserID != "u" ||
+		got.File != "exports/u/1.zip" {
+		t.Errorf("Get() returned %+v.", got)
+	}
+
+	if _, err := jobs.Get("2", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a missing job returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestTrashStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	trash := s.Trash()
+
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c"} {
+		item := &api.TrashItem{Kind: api.POST_KIND, ID: id, OwnerID: "u", ParentID: "e", TrashedBy: "m",
+			Trashed: now.Add(time.Duration(-i) * time.Hour), Data: []byte(`{"id":"` + id + `"}`)}
+		if err := trash.Put(item, c); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	trash.Put(&api.TrashItem{Kind: api.EVENT_KIND, ID: "a", OwnerID: "u", Trashed: now}, c)
+
+	got, err := trash.Get(api.POST_KIND, "a", c)
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if got.ParentID != "e" || got.TrashedBy != "m" || string(got.Data) != `{"id":"a"}` {
+		t.Errorf("Get() returned %+v.", got)
+	}
+
+	var ids []string
+	req := api.PageRequest{Order: "-Trashed", Limit: 2}
+	for {
+		page, err := trash.ByOwner("u", req, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.Key())
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		req.Cursor = page.NextCursor
+	}
+	if got := strings.Join(ids, " "); got != "post:a event:a post:b post:c" {
+		t.Errorf("ByOwner() returned %q.", got)
+	}
+
+	page, err := trash.Expired(now.Add(-time.Minute), api.PageRequest{Order: "Trashed", Limit: 10}, c)
+	if err != nil || len(page.Items) != 2 || page.Items[0].ID != "c" {
+		t.Errorf("Expired() returned %+v, %v.", page, err)
+	}
+
+	trash.Delete(api.POST_KIND, "a", c)
+	if _, err := trash.Get(api.POST_KIND, "a", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted item returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+	if _, err := trash.Get(api.EVENT_KIND, "a", c); err != nil {
+		t.Errorf("Get() of an event with the ID of a deleted post failed: %v", err)
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	tokens := s.Tokens()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, id := range []string{"a", "b"} {
+		token := &api.AccessToken{ID: id, UserID: "u", Name: "Token " + id, Scope: api.TOKEN_READ,
+			Hash: "hash-" + id, Created: now.Add(time.Duration(i) * time.Hour)}
+		if err := tokens.Put(token, c); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+
+	if err := tokens.Touch("a", now.Add(time.Minute), c); err != nil {
+		t.Fatalf("Touch() failed: %v", err)
+	}
+	got, err := tokens.Get("a", c)
+	if err != nil || got.Hash != "hash-a" || !got.LastUsed.Equal(now.Add(time.Minute)) {
+		t.Errorf("Get() returned %+v, %v.", got, err)
+	}
+
+	list, err := tokens.ByUser("u", c)
+	if err != nil || len(list) != 2 || list[0].ID != "b" {
+		t.Errorf("ByUser() returned %+v, %v. Wanted the newest token first.", list, err)
+	}
+
+	tokens.Delete("a", c)
+	if err := tokens.Touch("a", now, c); err != api.ErrNoSuchEntity {
+		t.Errorf("Touch() of a deleted token returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+	if _, err := tokens.Get("a", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted token returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestMemberStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	members := s.Members()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, id := range []string{"b", "a"} {
+		member := &api.Member{EventID: "e", UserID: id, Status: api.MEMBER_INVITED, InvitedBy: "c",
+			Created: now.Add(time.Duration(i) * time.Hour), Modified: now}
+		if err := members.Put(member, c); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	members.Put(&api.Member{EventID: "f", UserID: "a", Status: api.MEMBER_JOINED, Created: now, Modified: now}, c)
+
+	member, _ := members.Get("e", "a", c)
+	member.Status = api.MEMBER_JOINED
+	if err := members.Put(member, c); err != nil {
+		t.Fatalf("Put() of an existing member failed: %v", err)
+	}
+	got, err := members.Get("e", "a", c)
+	if err != nil || got.Status != api.MEMBER_JOINED || got.InvitedBy != "c" || !got.Created.Equal(now.Add(time.Hour)) {
+		t.Errorf("Get() returned %+v, %v.", got, err)
+	}
+
+	list, err := members.ByEvent("e", c)
+	if err != nil || len(list) != 2 || list[0].UserID != "b" {
+		t.Errorf("ByEvent() returned %+v, %v. Wanted the oldest member first.", list, err)
+	}
+	list, err = members.ByUser("a", c)
+	if err != nil || len(list) != 2 || list[0].EventID != "f" {
+		t.Errorf("ByUser() returned %+v, %v. Wanted the oldest membership first.", list, err)
+	}
+
+	members.Delete("e", "a", c)
+	if _, err := members.Get("e", "a", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted member returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	for i := 0; i < api.MAX_MEMBERS; i++ {
+		members.Put(&api.Member{EventID: "full", UserID: strconv.Itoa(i), Created: now, Modified: now}, c)
+	}
+	if err := members.Put(&api.Member{EventID: "full", UserID: "late", Created: now, Modified: now}, c); err != api.ErrEventFull {
+		t.Errorf("Put() of a new member of a full event returned %v. Wanted ErrEventFull.", err)
+	}
+	if err := members.Put(&api.Member{EventID: "full", UserID: "0", Status: api.MEMBER_JOINED, Created: now, Modified: now}, c); err != nil {
+		t.Errorf("Put() of an existing member of a full event failed: %v", err)
+	}
+}
+
+func TestInviteLinkStore(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	links := s.InviteLinks()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, id := range []string{"a", "b"} {
+		link := &api.InviteLink{ID: id, EventID: "e", CreatedBy: "u", MaxUses: 1,
+			Expires: now.Add(time.Hour), Created: now.Add(time.Duration(i) * time.Minute)}
+		if err := links.Put(link, c); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+
+	link, err := links.Use("a", c)
+	if err != nil || link.Uses != 1 || link.CreatedBy != "u" || !link.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("Use() returned %+v, %v.", link, err)
+	}
+	if _, err := links.Use("a", c); err != api.ErrLinkUsedUp {
+		t.Errorf("Use() of a used up link returned %v. Wanted ErrLinkUsedUp.", err)
+	}
+	if _, err := links.Use("missing", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Use() of a missing link returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+
+	list, err := links.ByEvent("e", c)
+	if err != nil || len(list) != 2 || list[0].ID != "b" || list[1].Uses != 1 {
+		t.Errorf("ByEvent() returned %+v, %v. Wanted the newest link first.", list, err)
+	}
+
+	links.Delete("a", c)
+	if _, err := links.Get("a", c); err != api.ErrNoSuchEntity {
+		t.Errorf("Get() of a deleted link returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
+
+func TestEventCoHosts(t *testing.T) {
+	c := context.Background()
+	s := openTestStore(t)
+	defer s.Close()
+	events := s.Events()
+
+	for _, coHosts := range [][]string{nil, {"a", "b"}} {
+		if err := events.Put(&api.Event{ID: "e", Name: "Event", CoHosts: coHosts}, c); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+
+		event, err := events.Get("e", c)
+		if err != nil || strings.Join(event.CoHosts, ",") != strings.Join(coHosts, ",") {
+			t.Errorf("Get() returned co-hosts %q, %v. Wanted %q.", event.CoHosts, err, coHosts)
+		}
+	}
+
+	addCoHost := func(event *api.Event) error {
+		event.CoHosts = append(event.CoHosts, "c")
+		return nil
+	}
+	if event, err := events.Update("e", addCoHost, c); err != nil || strings.Join(event.CoHosts, ",") != "a,b,c" {
+		t.Fatalf("Update() returned %+v, %v.", event, err)
+	}
+
+	// A failed update must be rolled back.
+	_, err := events.Update("e", func(event *api.Event) error {
+		event.CoHosts = nil
+		return api.ErrNotCoHost
+	}, c)
+	if err != api.ErrNotCoHost {
+		t.Errorf("Update() returned %v. Wanted ErrNotCoHost.", err)
+	}
+	if event, err := events.Get("e", c); err != nil || strings.Join(event.CoHosts, ",") != "a,b,c" {
+		t.Errorf("Get() after a failed Update() returned co-hosts %q, %v.", event.CoHosts, err)
+	}
+
+	if _, err := events.Update("missing", addCoHost, c); err != api.ErrNoSuchEntity {
+		t.Errorf("Update() of a missing event returned %v. Wanted ErrNoSuchEntity.", err)
+	}
+}
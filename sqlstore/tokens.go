@@ -0,0 +1,73 @@
+package sqlstore
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type tokenStore struct {
+	*Store
+}
+
+const tokenColumns = "id, user_id, name, scope, hash, created, last_used"
+
+func (s *tokenStore) Get(tokenID string, c context.Context) (*api.AccessToken, error) {
+	token := new(api.AccessToken)
+	err := s.queryRow(c, "SELECT "+tokenColumns+" FROM access_tokens WHERE id = ?", tokenID).Scan(
+		&token.ID, &token.UserID, &token.Name, &token.Scope, &token.Hash, &token.Created, &token.LastUsed)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return token, nil
+}
+
+func (s *tokenStore) Put(token *api.AccessToken, c context.Context) error {
+	return s.exec(c, `INSERT INTO access_tokens (`+tokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, name = excluded.name,
+			scope = excluded.scope, hash = excluded.hash, created = excluded.created,
+			last_used = excluded.last_used`,
+		token.ID, token.UserID, token.Name, token.Scope, token.Hash, token.Created.UTC(), token.LastUsed.UTC())
+}
+
+func (s *tokenStore) Delete(tokenID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM access_tokens WHERE id = ?", tokenID)
+}
+
+func (s *tokenStore) Touch(tokenID string, lastUsed time.Time, c context.Context) error {
+	res, err := s.db.ExecContext(c, s.rebind("UPDATE access_tokens SET last_used = ? WHERE id = ?"),
+		lastUsed.UTC(), tokenID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return api.ErrNoSuchEntity
+	}
+
+	return nil
+}
+
+func (s *tokenStore) ByUser(userID string, c context.Context) ([]api.AccessToken, error) {
+	rows, err := s.query(c, "SELECT "+tokenColumns+" FROM access_tokens WHERE user_id = ?"+
+		" ORDER BY created DESC, id DESC LIMIT ?", userID, api.MAX_TOKENS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]api.AccessToken, 0)
+	for rows.Next() {
+		var token api.AccessToken
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Scope, &token.Hash, &token.Created, &token.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type trashStore struct {
+	*Store
+}
+
+const trashColumns = "kind, id, owner_id, parent_id, trashed_by, trashed, data"
+
+func (s *trashStore) Get(kind, id string, c context.Context) (*api.TrashItem, error) {
+	row := s.queryRow(c, "SELECT "+trashColumns+" FROM trash WHERE item_key = ?", kind+":"+id)
+	return scanTrashItem(row)
+}
+
+func (s *trashStore) Put(item *api.TrashItem, c context.Context) error {
+	return s.exec(c, `INSERT INTO trash (item_key, `+trashColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (item_key) DO UPDATE SET owner_id = excluded.owner_id, parent_id = excluded.parent_id,
+			trashed_by = excluded.trashed_by, trashed = excluded.trashed, data = excluded.data`,
+		item.Key(), item.Kind, item.ID, item.OwnerID, item.ParentID, item.TrashedBy, item.Trashed.UTC(), string(item.Data))
+}
+
+func (s *trashStore) Delete(kind, id string, c context.Context) error {
+	return s.exec(c, "DELETE FROM trash WHERE item_key = ?", kind+":"+id)
+}
+
+func (s *trashStore) ByOwner(ownerID string, req api.PageRequest, c context.Context) (*api.TrashPage, error) {
+	return s.page("owner_id = ?", []interface{}{ownerID}, req, c)
+}
+
+func (s *trashStore) ByParent(parentID string, req api.PageRequest, c context.Context) (*api.TrashPage, error) {
+	return s.page("parent_id = ?", []interface{}{parentID}, req, c)
+}
+
+func (s *trashStore) Expired(before time.Time, req api.PageRequest, c context.Context) (*api.TrashPage, error) {
+	return s.page("trashed < ?", []interface{}{before.UTC()}, req, c)
+}
+
+// page returns a page of the trashed items matching the where condition.
+func (s *trashStore) page(where string, args []interface{}, req api.PageRequest, c context.Context) (*api.TrashPage, error) {
+	_, direction := sortColumn(req.Order)
+
+	if req.Cursor != "" {
+		trashed, key, err := api.DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+		where += " AND (trashed " + op + " ? OR (trashed = ? AND item_key " + op + " ?))"
+		args = append(args, trashed.UTC(), trashed.UTC(), key)
+	}
+
+	rows, err := s.query(c, "SELECT "+trashColumns+" FROM trash WHERE "+where+
+		" ORDER BY trashed "+direction+", item_key "+direction+" LIMIT ?", append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := scanTrashItems(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &api.TrashPage{Items: items}
+	if len(items) > req.Limit {
+		page.Items = items[:req.Limit]
+		page.NextCursor = api.TrashCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+func scanTrashItem(row scanner) (*api.TrashItem, error) {
+	item := new(api.TrashItem)
+	var data string
+	err := row.Scan(&item.Kind, &item.ID, &item.OwnerID, &item.ParentID, &item.TrashedBy, &item.Trashed, &data)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	item.Data = []byte(data)
+	return item, nil
+}
+
+func scanTrashItems(rows *sql.Rows) ([]api.TrashItem, error) {
+	defer rows.Close()
+
+	items := make([]api.TrashItem, 0, api.PAGE_SIZE)
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+
+	return items, rows.Err()
+}
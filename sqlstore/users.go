@@ -0,0 +1,166 @@
+package sqlstore
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/api"
+)
+
+type userStore struct {
+	*Store
+}
+
+const userColumns = "id, email, username, first_name, last_name, role, created, modified"
+
+func (s *userStore) Get(userID string, c context.Context) (*api.AppUser, error) {
+	row := s.queryRow(c, "SELECT "+userColumns+" FROM app_users WHERE id = ?", userID)
+	return scanUser(row)
+}
+
+func (s *userStore) GetMulti(userIDs []string, c context.Context) (map[string]*api.AppUser, error) {
+	found := make(map[string]*api.AppUser, len(userIDs))
+	if len(userIDs) == 0 {
+		return found, nil
+	}
+
+	args := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		args[i] = userID
+	}
+
+	rows, err := s.query(c, "SELECT "+userColumns+" FROM app_users WHERE id IN ("+placeholders(len(args))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		appUser, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		found[appUser.ID] = appUser
+	}
+
+	return found, rows.Err()
+}
+
+func (s *userStore) GetByName(username string, c context.Context) (*api.AppUser, error) {
+	row := s.queryRow(c, "SELECT "+userColumns+" FROM app_users WHERE LOWER(username) = LOWER(?)", username)
+	return scanUser(row)
+}
+
+// Create relies on the primary key and the unique index on usernames to reject
+// conflicting users, and then finds out which one was violated.
+func (s *userStore) Create(appUser *api.AppUser, c context.Context) error {
+	err := s.exec(c, `INSERT INTO app_users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		appUser.ID, appUser.Email, appUser.Username, appUser.FirstName, appUser.LastName, appUser.Role,
+		appUser.Created.UTC(), appUser.Modified.UTC())
+	if err == nil {
+		return nil
+	}
+
+	if _, getErr := s.Get(appUser.ID, c); getErr == nil {
+		return api.ErrUserExists
+	}
+
+	return s.conflict(appUser, err, c)
+}
+
+func (s *userStore) Update(appUser *api.AppUser, c context.Context) error {
+	res, err := s.db.ExecContext(c, s.rebind(`UPDATE app_users SET email = ?, username = ?,
+		first_name = ?, last_name = ?, role = ?, created = ?, modified = ? WHERE id = ?`),
+		appUser.Email, appUser.Username, appUser.FirstName, appUser.LastName, appUser.Role,
+		appUser.Created.UTC(), appUser.Modified.UTC(), appUser.ID)
+	if err != nil {
+		return s.conflict(appUser, err, c)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return api.ErrNoSuchEntity
+	}
+
+	return nil
+}
+
+// conflict returns ErrUsernameTaken if err was caused by another user having appUser's
+// username, and otherwise returns err.
+func (s *userStore) conflict(appUser *api.AppUser, err error, c context.Context) error {
+	if other, getErr := s.GetByName(appUser.Username, c); getErr == nil && other.ID != appUser.ID {
+		return api.ErrUsernameTaken
+	}
+
+	return err
+}
+
+func (s *userStore) Delete(userID string, c context.Context) error {
+	return s.exec(c, "DELETE FROM app_users WHERE id = ?", userID)
+}
+
+func (s *userStore) All(req api.PageRequest, c context.Context) (*api.UserPage, error) {
+	_, direction := sortColumn(req.Order)
+	where, args := "", []interface{}{}
+
+	if req.Cursor != "" {
+		created, id, err := api.DecodeCursor(req.Cursor, req.Order)
+		if err != nil {
+			return nil, err
+		}
+
+		op := ">"
+		if direction == "DESC" {
+			op = "<"
+		}
+		where = " WHERE (created " + op + " ? OR (created = ? AND id " + op + " ?))"
+		args = append(args, created.UTC(), created.UTC(), id)
+	}
+
+	rows, err := s.query(c, "SELECT "+userColumns+" FROM app_users"+where+
+		" ORDER BY created "+direction+", id "+direction+" LIMIT ?", append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]api.AppUser, 0, req.Limit)
+	for rows.Next() {
+		appUser, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *appUser)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &api.UserPage{Items: users}
+	if len(users) > req.Limit {
+		page.Items = users[:req.Limit]
+		page.NextCursor = api.UserCursor(&page.Items[req.Limit-1], req.Order)
+	}
+
+	return page, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*api.AppUser, error) {
+	appUser := new(api.AppUser)
+	err := row.Scan(&appUser.ID, &appUser.Email, &appUser.Username, &appUser.FirstName,
+		&appUser.LastName, &appUser.Role, &appUser.Created, &appUser.Modified)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return appUser, nil
+}
+
+// placeholders returns n comma separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
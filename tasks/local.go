@@ -0,0 +1,152 @@
+package tasks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/reedperry/gogram/log"
+)
+
+// Header set on task requests made by a LocalQueue. Its value is a secret known only to
+// this process, so that task handlers cannot be called from outside.
+const localTaskHeader = "X-Gogram-Task"
+
+var localTaskSecret = newSecret()
+
+// ErrQueueStopped is returned when adding a task to a LocalQueue that has been shut down.
+var ErrQueueStopped = errors.New("tasks: queue has been shut down")
+
+// LocalQueue runs tasks in the current process by sending them to an http.Handler.
+// Failed tasks, those with a response status outside of 2xx, are retried with
+// exponential backoff, like tasks in an App Engine push queue.
+type LocalQueue struct {
+	// Handlers serve the tasks of each queue. Tasks for any queue without its own
+	// handler are sent to the "default" handler.
+	Handlers map[string]http.Handler
+
+	// RetryLimit is the number of times a failed task is retried. Zero means no retries.
+	RetryLimit int
+	// MinBackoff is the time to wait before the first retry. It doubles with every retry.
+	MinBackoff time.Duration
+
+	mu      sync.Mutex
+	stopped bool
+	stop    chan struct{}
+	running sync.WaitGroup
+}
+
+// NewLocalQueue creates a LocalQueue which retries failed tasks up to 5 times.
+func NewLocalQueue(handlers map[string]http.Handler) *LocalQueue {
+	return &LocalQueue{
+		Handlers:   handlers,
+		RetryLimit: 5,
+		MinBackoff: time.Second,
+		stop:       make(chan struct{}),
+	}
+}
+
+func (q *LocalQueue) Add(c context.Context, queueName, path string, params url.Values) error {
+	h, ok := q.Handlers[queueName]
+	if !ok {
+		h, ok = q.Handlers["default"]
+	}
+	if !ok {
+		return errors.New("tasks: no handler for queue " + queueName)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return ErrQueueStopped
+	}
+
+	q.running.Add(1)
+	go q.run(h, queueName, path, params)
+
+	return nil
+}
+
+// Shutdown stops the queue from accepting new tasks, and waits for running tasks to
+// finish. Tasks waiting to be retried are abandoned.
+func (q *LocalQueue) Shutdown(c context.Context) error {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.stop)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-c.Done():
+		return c.Err()
+	}
+}
+
+func (q *LocalQueue) run(h http.Handler, queueName, path string, params url.Values) {
+	defer q.running.Done()
+
+	c := context.Background()
+	backoff := q.MinBackoff
+
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest("POST", path, strings.NewReader(params.Encode()))
+		if err != nil {
+			log.Errorf(c, "Failed to create task request for %v: %v", path, err)
+			return
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-AppEngine-QueueName", queueName)
+		req.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(retry))
+		req.Header.Set(localTaskHeader, localTaskSecret)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code >= 200 && w.Code <= 299 {
+			return
+		}
+
+		if retry >= q.RetryLimit {
+			log.Errorf(c, "Task %v on queue %v failed with status %v. Giving up after %v retries.",
+				path, queueName, w.Code, retry)
+			return
+		}
+
+		log.Warningf(c, "Task %v on queue %v failed with status %v. Retrying in %v.", path, queueName, w.Code, backoff)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-q.stop:
+			log.Warningf(c, "Queue shut down, abandoning task %v on queue %v.", path, queueName)
+			return
+		}
+	}
+}
+
+func newSecret() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("tasks: cannot generate secret: " + err.Error())
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,64 @@
+package tasks
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestLocalQueue(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsTaskRequest(r) {
+			t.Errorf("IsTaskRequest() returned false for a task request.")
+		}
+		if r.FormValue("file") != "a.jpg" || r.Header.Get("X-AppEngine-QueueName") != "images" {
+			t.Errorf("Task request had file %q on queue %q.", r.FormValue("file"), r.Header.Get("X-AppEngine-QueueName"))
+		}
+
+		// Fail the first attempt so that the task is retried.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "Try again.", http.StatusInternalServerError)
+		}
+	})
+
+	q := NewLocalQueue(map[string]http.Handler{"default": handler})
+	q.MinBackoff = time.Millisecond
+
+	c := context.Background()
+	if err := q.Add(c, "images", "/", url.Values{"file": {"a.jpg"}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := q.Shutdown(c); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Task was run %v times. Wanted 2.", n)
+	}
+
+	if err := q.Add(c, "images", "/", nil); err != ErrQueueStopped {
+		t.Errorf("Add() after Shutdown() returned %v. Wanted ErrQueueStopped.", err)
+	}
+}
+
+func TestIsTaskRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/", nil)
+	if IsTaskRequest(r) {
+		t.Errorf("IsTaskRequest() returned true for a request without the task header.")
+	}
+
+	r.Header.Set(localTaskHeader, "guess")
+	if IsTaskRequest(r) {
+		t.Errorf("IsTaskRequest() returned true for a request with the wrong secret.")
+	}
+}
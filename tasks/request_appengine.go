@@ -0,0 +1,13 @@
+//go:build appengine
+// +build appengine
+
+package tasks
+
+import "net/http"
+
+// IsTaskRequest reports whether a request was made by a task queue or by cron. App Engine
+// removes the X-AppEngine-QueueName and X-Appengine-Cron headers from requests made by
+// anyone else.
+func IsTaskRequest(r *http.Request) bool {
+	return r.Header.Get("X-AppEngine-QueueName") != "" || r.Header.Get("X-Appengine-Cron") == "true"
+}
@@ -0,0 +1,11 @@
+//go:build !appengine
+// +build !appengine
+
+package tasks
+
+import "net/http"
+
+// IsTaskRequest reports whether a request was made by a LocalQueue in this process.
+func IsTaskRequest(r *http.Request) bool {
+	return r.Header.Get(localTaskHeader) == localTaskSecret
+}
@@ -0,0 +1,43 @@
+// Package tasks runs work in the background using push task queues. On App Engine tasks
+// are added to the Task Queue service; a standalone server uses a LocalQueue, which sends
+// tasks to its own handlers.
+package tasks
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/appengine/taskqueue"
+)
+
+// Queue adds tasks to named push queues. A task is a POST request of params to path,
+// made to the handler serving the queue.
+type Queue interface {
+	Add(c context.Context, queueName, path string, params url.Values) error
+}
+
+// Default is the Queue used by Add.
+var Default Queue = &AppEngineQueue{}
+
+// Add adds a task to a queue using the Default Queue.
+func Add(c context.Context, queueName, path string, params url.Values) error {
+	return Default.Add(c, queueName, path, params)
+}
+
+// RetryCount returns the number of times a task request has been retried before.
+func RetryCount(r *http.Request) int {
+	n, _ := strconv.Atoi(r.Header.Get("X-AppEngine-TaskRetryCount"))
+	return n
+}
+
+// AppEngineQueue adds tasks to App Engine push queues, which are configured in queue.yaml.
+type AppEngineQueue struct{}
+
+func (q *AppEngineQueue) Add(c context.Context, queueName, path string, params url.Values) error {
+	t := taskqueue.NewPOSTTask(path, params)
+	_, err := taskqueue.Add(c, t, queueName)
+	return err
+}
@@ -0,0 +1,116 @@
+// Package uid generates unique 64 bit IDs without coordination, in the style of Twitter's
+// Snowflake. An ID holds the milliseconds since Epoch in its top 41 bits, then a 10 bit
+// node ID, then a 12 bit sequence number counting IDs generated in the same millisecond.
+//
+// IDs are unique as long as every process generating them at the same time uses a
+// different node ID. IDs generated later compare greater, apart from clock skew between nodes.
+package uid
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	NodeBits     = 10
+	SequenceBits = 12
+
+	MaxNode     = 1<<NodeBits - 1
+	MaxSequence = 1<<SequenceBits - 1
+
+	timeShift = NodeBits + SequenceBits
+)
+
+// Epoch is the time of the zero timestamp, Jan 1 2015 midnight GMT.
+var Epoch = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrInvalidNode is returned when creating a Generator with a node ID outside of 0 to MaxNode.
+var ErrInvalidNode = errors.New("uid: node ID out of range")
+
+// ID is a generated ID. Its string form is lower case hexadecimal.
+type ID uint64
+
+// Generator creates IDs for a single node. It is safe for concurrent use.
+type Generator struct {
+	node uint64
+
+	mu   sync.Mutex
+	last int64 // milliseconds since Epoch of the last ID
+	seq  uint64
+
+	now func() time.Time
+}
+
+// NewGenerator creates a Generator for a node, which must be between 0 and MaxNode.
+func NewGenerator(node int) (*Generator, error) {
+	if node < 0 || node > MaxNode {
+		return nil, ErrInvalidNode
+	}
+
+	return &Generator{node: uint64(node), last: -1, now: time.Now}, nil
+}
+
+// Next returns a new ID. If more than MaxSequence+1 IDs are requested in one millisecond,
+// Next waits for the next millisecond. If the clock moves backwards, IDs continue from the
+// last time seen, so they are never repeated.
+func (g *Generator) Next() ID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	millis := g.millis()
+	if millis < g.last {
+		millis = g.last
+	}
+
+	if millis == g.last {
+		g.seq = (g.seq + 1) & MaxSequence
+		if g.seq == 0 {
+			// Sequence exhausted, wait for the clock to tick.
+			for millis <= g.last {
+				time.Sleep(100 * time.Microsecond)
+				millis = g.millis()
+			}
+		}
+	} else {
+		g.seq = 0
+	}
+
+	g.last = millis
+
+	return ID(uint64(millis)<<timeShift | g.node<<SequenceBits | g.seq)
+}
+
+func (g *Generator) millis() int64 {
+	return int64(g.now().Sub(Epoch) / time.Millisecond)
+}
+
+// ParseID parses the string form of an ID.
+func ParseID(s string) (ID, error) {
+	n, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return ID(n), nil
+}
+
+func (id ID) String() string {
+	return strconv.FormatUint(uint64(id), 16)
+}
+
+// Time returns the time the ID was generated, to the millisecond.
+func (id ID) Time() time.Time {
+	return Epoch.Add(time.Duration(id>>timeShift) * time.Millisecond)
+}
+
+// Node returns the ID of the node which generated the ID.
+func (id ID) Node() int {
+	return int(id>>SequenceBits) & MaxNode
+}
+
+// Sequence returns the ID's position among IDs generated by its node in the same millisecond.
+func (id ID) Sequence() int {
+	return int(id) & MaxSequence
+}
@@ -0,0 +1,101 @@
+package uid
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	g, err := NewGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, count = 8, 5000
+	ids := make(chan ID, workers*count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				ids <- g.Next()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[ID]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("Duplicate ID %v generated.", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSequenceOverflow(t *testing.T) {
+	g, _ := NewGenerator(1)
+	start := time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC)
+	var offset int64
+	g.now = func() time.Time { return start.Add(time.Duration(atomic.LoadInt64(&offset))) }
+
+	var last ID
+	for i := 0; i <= MaxSequence; i++ {
+		last = g.Next()
+	}
+	if last.Sequence() != MaxSequence || !last.Time().Equal(start) {
+		t.Fatalf("ID %v has sequence %v at %v.", last, last.Sequence(), last.Time())
+	}
+
+	// The next ID must wait for the clock to reach a new millisecond.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt64(&offset, int64(time.Millisecond))
+	}()
+
+	next := g.Next()
+	if next <= last || next.Sequence() != 0 {
+		t.Errorf("Next() after the sequence overflowed returned %v, sequence %v.", next, next.Sequence())
+	}
+}
+
+func TestClockBackwards(t *testing.T) {
+	g, _ := NewGenerator(1)
+	now := time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	first := g.Next()
+	now = now.Add(-time.Second)
+	if second := g.Next(); second <= first {
+		t.Errorf("Next() after the clock moved backwards returned %v. Wanted an ID after %v.", second, first)
+	}
+}
+
+func TestParseID(t *testing.T) {
+	g, _ := NewGenerator(MaxNode)
+	created := time.Date(2021, time.March, 14, 15, 9, 26, 535000000, time.UTC)
+	g.now = func() time.Time { return created }
+
+	id, err := ParseID(g.Next().String())
+	if err != nil {
+		t.Fatalf("ParseID() failed: %v", err)
+	}
+
+	if !id.Time().Equal(created) || id.Node() != MaxNode || id.Sequence() != 0 {
+		t.Errorf("ParseID() returned an ID created %v by node %v. Wanted %v by node %v.",
+			id.Time(), id.Node(), created, MaxNode)
+	}
+
+	if _, err := ParseID("not an id"); err == nil {
+		t.Errorf("ParseID() of an invalid ID returned no error.")
+	}
+
+	if _, err := NewGenerator(MaxNode + 1); err != ErrInvalidNode {
+		t.Errorf("NewGenerator(%v) returned %v. Wanted ErrInvalidNode.", MaxNode+1, err)
+	}
+}